@@ -19,9 +19,11 @@ import (
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
+	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
 	"github.com/morikuni/aec"
@@ -34,14 +36,22 @@ import (
 	"lab47.dev/aperture/pkg/data"
 	"lab47.dev/aperture/pkg/direnv"
 	"lab47.dev/aperture/pkg/gc"
+	"lab47.dev/aperture/pkg/hook"
 	"lab47.dev/aperture/pkg/humanize"
 	"lab47.dev/aperture/pkg/lockfile"
 	"lab47.dev/aperture/pkg/ociutil"
 	"lab47.dev/aperture/pkg/ops"
+	"lab47.dev/aperture/pkg/pkgconfig"
 	"lab47.dev/aperture/pkg/profile"
 )
 
 func main() {
+	// Builds run with iris as their pkg-config, so only the packages of
+	// declared dependencies are found.
+	if filepath.Base(os.Args[0]) == "pkg-config" {
+		os.Exit(pkgconfig.Main(os.Args[1:], os.Getenv("PKG_CONFIG_PATH"), os.Stdout, os.Stderr))
+	}
+
 	if os.Args[0] != "iris" {
 		bi := os.Getenv("APERTURE_BUILD_INFO")
 		sp := os.Getenv("APERTURE_SHIM_PATH")
@@ -104,6 +114,41 @@ func main() {
 				shellF,
 			), nil
 		},
+		"hook": func() (cli.Command, error) {
+			return cmd.New(
+				"hook",
+				"Output shell code that loads the environment of project files",
+				hookF,
+			), nil
+		},
+		"hook export": func() (cli.Command, error) {
+			return cmd.New(
+				"hook export",
+				"Output shell code to update the env for the current directory",
+				hookExportF,
+			), nil
+		},
+		"profile conflicts": func() (cli.Command, error) {
+			return cmd.New(
+				"profile conflicts",
+				"List files provided by more than one package in a profile",
+				profileConflictsF,
+			), nil
+		},
+		"image build": func() (cli.Command, error) {
+			return cmd.New(
+				"image build",
+				"Build a container image of the project's packages",
+				imageBuildF,
+			), nil
+		},
+		"debug-info": func() (cli.Command, error) {
+			return cmd.New(
+				"debug-info",
+				"Install the debug info of a package",
+				debugInfoF,
+			), nil
+		},
 		"inspect-car": func() (cli.Command, error) {
 			return cmd.New(
 				"inspect-car",
@@ -139,6 +184,20 @@ func main() {
 				debugF,
 			), nil
 		},
+		"debug sig-diff": func() (cli.Command, error) {
+			return cmd.New(
+				"debug sig-diff",
+				"Explain why the id of a package differs between two revisions",
+				sigDiffF,
+			), nil
+		},
+		"log": func() (cli.Command, error) {
+			return cmd.New(
+				"log",
+				"Show the build log for a package",
+				logF,
+			), nil
+		},
 		"system-info": func() (cli.Command, error) {
 			return cmd.New(
 				"system-info",
@@ -295,6 +354,17 @@ func buildF(ctx context.Context, opts struct {
 	Global  bool   `short:"G" long:"global" description:"install into the user's global profile"`
 	Build   bool   `short:"B" long:"build-only" description:"build packages only, don't manage any profiles"`
 	Clean   bool   `short:"C" long:"clean" description:"temporarily setup a clean store first"`
+	Check   bool   `long:"check" description:"run the check function of built packages"`
+	Repro   bool   `long:"check-repro" description:"build the named package twice and report any differences"`
+	Jobs    int    `short:"j" long:"jobs" description:"number of jobs build tools run in parallel (default: number of CPUs)"`
+
+	StrictLinks bool     `long:"strict-links" description:"fail packages that need unresolved or undeclared shared libraries"`
+	AllowLib    []string `long:"allow-lib" description:"shared library allowed to come from the host (repeatable)"`
+
+	StrictPkgConfig bool `long:"strict-pkgconfig" description:"fail packages whose pkg-config files have errors"`
+	StrictRepro     bool `long:"strict-repro" description:"fail compiles that use __DATE__, __TIME__ or __TIMESTAMP__"`
+
+	CompDB string `long:"compdb" description:"write the compile_commands.json of the named package to this path, keeping its build directory"`
 
 	Pos struct {
 		Package string `positional-arg-name:"name"`
@@ -305,6 +375,10 @@ func buildF(ctx context.Context, opts struct {
 		return err
 	}
 
+	if opts.Repro {
+		return checkRepro(ctx, cfg, opts.Pos.Package)
+	}
+
 	if opts.Clean {
 		curStore := filepath.Join(cfg.DataDir, fmt.Sprintf("store-%d", os.Getpid()))
 		defer func() {
@@ -355,9 +429,23 @@ func buildF(ctx context.Context, opts struct {
 	ienv := &ops.InstallEnv{
 		Store:      cfg.Store(),
 		BuildDir:   buildRoot,
+		LogDir:     cfg.LogsPath(),
 		StateDir:   stateDir,
 		Config:     cfg,
 		ExportPath: exportDir,
+		RunCheck:   opts.Check,
+		Jobs:       opts.Jobs,
+
+		StrictLinks:    opts.StrictLinks,
+		AllowLibraries: opts.AllowLib,
+
+		StrictPkgConfig: opts.StrictPkgConfig,
+		StrictRepro:     opts.StrictRepro,
+	}
+
+	// The database refers to the sources, so they have to stay around.
+	if opts.CompDB != "" {
+		ienv.RetainBuild = true
 	}
 
 	var cl ops.ProjectLoad
@@ -428,6 +516,13 @@ func buildF(ctx context.Context, opts struct {
 		return err
 	}
 
+	if opts.CompDB != "" {
+		err = writeCompDB(proj, buildRoot, opts.CompDB)
+		if err != nil {
+			return err
+		}
+	}
+
 	if exportDir != "" && opts.Publish {
 		extra, err := proj.FindCachedBuildOnlyDeps(pti, exportDir)
 		if err != nil {
@@ -450,6 +545,79 @@ func buildF(ctx context.Context, opts struct {
 	return nil
 }
 
+// writeCompDB writes the compile commands recorded while building the
+// packages of proj to dest, or to compile_commands.json in it if it's a
+// directory.
+func writeCompDB(proj *ops.Project, buildRoot, dest string) error {
+	if st, err := os.Stat(dest); err == nil && st.IsDir() {
+		dest = filepath.Join(dest, "compile_commands.json")
+	}
+
+	var dbs []string
+
+	for _, pkg := range proj.Install {
+		dbs = append(dbs, ops.CompDBPath(buildRoot, pkg.ID()))
+	}
+
+	count, err := cc.WriteCompilationDatabase(dest, dbs...)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		fmt.Printf("No compile commands were recorded, packages that were already installed aren't rebuilt.\n")
+	}
+
+	fmt.Println(
+		aec.Bold.Apply(
+			fmt.Sprintf("🛠  Wrote %d compile commands to: %s", count, dest),
+		),
+	)
+
+	return nil
+}
+
+func checkRepro(ctx context.Context, cfg *config.Config, name string) error {
+	if name == "" {
+		return fmt.Errorf("package name required")
+	}
+
+	var cl ops.ProjectLoad
+
+	proj, err := cl.Single(ctx, cfg, name)
+	if err != nil {
+		return err
+	}
+
+	dir, err := ioutil.TempDir("", "iris-repro")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(
+		aec.Bold.Apply(
+			fmt.Sprintf("🔍 Building %s twice in: %s", name, dir),
+		),
+	)
+
+	var rc ops.ReproCheck
+	rc.Config = cfg
+	rc.Dir = dir
+
+	report, err := rc.Check(ctx, proj.Install[0])
+	if err != nil {
+		return err
+	}
+
+	report.Write(os.Stdout)
+
+	if !report.Reproducible() {
+		return fmt.Errorf("%s is not reproducible", name)
+	}
+
+	return forceRemoveDir(dir)
+}
+
 func addF(ctx context.Context, opts struct {
 	Explain bool `short:"E" long:"explain" description:"explain what will be installed"`
 	Pos     struct {
@@ -498,6 +666,7 @@ func addF(ctx context.Context, opts struct {
 	ienv := &ops.InstallEnv{
 		Store:    cfg.Store(),
 		BuildDir: buildRoot,
+		LogDir:   cfg.LogsPath(),
 		StateDir: stateDir,
 		Config:   cfg,
 	}
@@ -556,7 +725,7 @@ func addF(ctx context.Context, opts struct {
 		if err != nil {
 			fmt.Printf("! Missing package from global list, pruning: %s\n", id)
 		} else {
-			err = prof.Link(id, path)
+			err = prof.LinkPriority(id, path, pkg.Priority)
 			if err != nil {
 				return err
 			}
@@ -565,11 +734,12 @@ func addF(ctx context.Context, opts struct {
 
 	for _, id := range requested {
 		gp.Packages = append(gp.Packages, &data.GlobalPackage{
-			Name: toInstall.Scripts[id].Name(),
-			Id:   id,
+			Name:     toInstall.Scripts[id].Name(),
+			Id:       id,
+			Priority: toInstall.Scripts[id].Priority(),
 		})
 
-		err = prof.Link(id, toInstall.InstallDirs[id])
+		err = prof.LinkPriority(id, toInstall.InstallDirs[id], toInstall.Scripts[id].Priority())
 		if err != nil {
 			return err
 		}
@@ -580,6 +750,8 @@ func addF(ctx context.Context, opts struct {
 		return err
 	}
 
+	warnConflicts(prof)
+
 	f, err = os.Create(cfg.GlobalPackagesPath())
 	if err != nil {
 		return errors.Wrapf(err, "attempting to update global package list")
@@ -639,6 +811,7 @@ func installF(ctx context.Context, opts struct {
 	ienv := &ops.InstallEnv{
 		Store:    cfg.Store(),
 		BuildDir: buildRoot,
+		LogDir:   cfg.LogsPath(),
 		StateDir: stateDir,
 		Config:   cfg,
 	}
@@ -742,7 +915,7 @@ func installF(ctx context.Context, opts struct {
 		}
 
 		for _, id := range requested {
-			err = prof.Link(id, toInstall.InstallDirs[id])
+			err = prof.LinkPriority(id, toInstall.InstallDirs[id], toInstall.Scripts[id].Priority())
 			if err != nil {
 				return err
 			}
@@ -757,6 +930,8 @@ func installF(ctx context.Context, opts struct {
 		if err != nil {
 			return err
 		}
+
+		warnConflicts(prof)
 	}
 
 	fmt.Println(
@@ -798,16 +973,29 @@ func publishCars(ctx context.Context, cfg *config.Config, cars []*ops.ExportedCa
 }
 
 func shellF(ctx context.Context, opts struct {
-	DumpEnv bool     `short:"E" long:"dump-env" description:"dump updated env in direnv format"`
-	Setup   bool     `short:"s" long:"setup" description:"output shell code to eval to update the env"`
-	Global  bool     `short:"G" long:"global" description:"execute in the context of the global profile"`
-	Args    []string `positional-args:"yes"`
+	DumpEnv bool `short:"E" long:"dump-env" description:"dump updated env in direnv format"`
+	Setup   bool `short:"s" long:"setup" description:"output shell code to eval to update the env"`
+	Global  bool `short:"G" long:"global" description:"execute in the context of the global profile"`
+	Pure    bool `long:"pure" description:"run the command with only the project profile and a minimal environment"`
+	Hide    bool `long:"hide-system" description:"with --pure, leave the host's /usr/bin and /bin out of PATH"`
+
+	Pos struct {
+		Args []string `positional-arg-name:"command"`
+	} `positional-args:"yes"`
 }) error {
 	cfg, err := config.LoadConfig()
 	if err != nil {
 		return err
 	}
 
+	if opts.Pure && (opts.Setup || opts.DumpEnv || opts.Global) {
+		return fmt.Errorf("--pure only applies when running a command")
+	}
+
+	if opts.Hide && !opts.Pure {
+		return fmt.Errorf("--hide-system requires --pure")
+	}
+
 	if opts.Global {
 		if opts.Setup {
 			fmt.Printf("export PATH=%s/bin:%s/bin:%s\n", cfg.GlobalProfilePath(), cfg.StatePath(), os.Getenv("PATH"))
@@ -818,23 +1006,96 @@ func shellF(ctx context.Context, opts struct {
 		return nil
 	}
 
-	buildRoot := cfg.BuildPath()
+	prof, err := projectProfile(ctx, cfg)
+	if err != nil {
+		return err
+	}
 
-	err = os.MkdirAll(buildRoot, 0755)
+	if opts.Setup {
+		updates, err := prof.UpdateEnv(os.Environ())
+		if err != nil {
+			return err
+		}
+
+		for _, u := range updates {
+			eq := strings.IndexByte(u, '=')
+			fmt.Printf("export %s=%s\n", u[:eq], hook.Quote(u[eq+1:]))
+		}
+
+		return nil
+	}
+
+	if opts.DumpEnv {
+		var w io.Writer
+
+		path := os.Getenv("DIRENV_DUMP_FILE_PATH")
+
+		if path == "" {
+			w = os.Stdout
+		} else {
+			f, err := os.Create(path)
+			if err != nil {
+				return err
+			}
+
+			defer f.Close()
+
+			w = f
+		}
+
+		envMap, err := prof.EnvMap(os.Environ())
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(w, direnv.Dump(envMap))
+		return nil
+	}
+
+	if len(opts.Pos.Args) == 0 {
+		return fmt.Errorf("no command given to run")
+	}
+
+	hostEnv := os.Environ()
+
+	if opts.Pure {
+		hostEnv = profile.PureEnv(hostEnv, opts.Hide)
+	}
+
+	env, err := prof.ComputeEnv(hostEnv)
 	if err != nil {
 		return err
 	}
 
+	path, err := exec.LookPath(opts.Pos.Args[0])
+	if err != nil {
+		return err
+	}
+
+	return unix.Exec(path, opts.Pos.Args, env)
+}
+
+// projectProfile installs the packages of the project file in the current
+// directory and links them into the project's profile.
+func projectProfile(ctx context.Context, cfg *config.Config) (*profile.Profile, error) {
+	buildRoot := cfg.BuildPath()
+
+	err := os.MkdirAll(buildRoot, 0755)
+	if err != nil {
+		return nil, err
+	}
+
 	stateDir := cfg.StatePath()
 
 	err = os.MkdirAll(stateDir, 0755)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	ienv := &ops.InstallEnv{
 		Store:    cfg.Store(),
 		BuildDir: buildRoot,
+		LogDir:   cfg.LogsPath(),
 		StateDir: stateDir,
 	}
 
@@ -842,7 +1103,7 @@ func shellF(ctx context.Context, opts struct {
 
 	proj, err := cl.Load(ctx, cfg)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var showLock bool
@@ -853,75 +1114,373 @@ func shellF(ctx context.Context, opts struct {
 		}
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	defer cleanup()
 
 	requested, toInstall, _, err := proj.InstallPackages(ctx, ienv)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	prof, err := profile.OpenProfile(cfg, ".iris-profile")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	for _, id := range requested {
-		err = prof.Link(id, toInstall.InstallDirs[id])
+		err = prof.LinkPriority(id, toInstall.InstallDirs[id], toInstall.Scripts[id].Priority())
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
 	err = prof.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	warnConflicts(prof)
+
+	return prof, nil
+}
+
+func hookF(ctx context.Context, opts struct {
+	Pos struct {
+		Shell string `positional-arg-name:"shell" description:"bash, zsh or fish"`
+	} `positional-args:"yes"`
+}) error {
+	sh, err := hook.Lookup(opts.Pos.Shell)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig()
 	if err != nil {
 		return err
 	}
 
-	cleanup()
+	// The global profile is always available, the hook adds the project
+	// profile on top of it.
+	sh.Export(os.Stdout, "PATH",
+		fmt.Sprintf("%s/bin:%s/bin:%s", cfg.GlobalProfilePath(), cfg.StatePath(), os.Getenv("PATH")))
 
-	if opts.Setup {
-		updates := prof.UpdateEnv(os.Environ())
+	fmt.Print(sh.Hook())
 
-		for _, u := range updates {
-			fmt.Println(u)
+	return nil
+}
+
+func hookExportF(ctx context.Context, opts struct {
+	Pos struct {
+		Shell string `positional-arg-name:"shell" description:"bash, zsh or fish"`
+	} `positional-args:"yes"`
+}) error {
+	sh, err := hook.Lookup(opts.Pos.Shell)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	cur := map[string]string{}
+
+	for _, kv := range os.Environ() {
+		if eq := strings.IndexByte(kv, '='); eq != -1 {
+			cur[kv[:eq]] = kv[eq+1:]
+		}
+	}
+
+	state := &hook.State{}
+
+	if data := cur[hook.StateVar]; data != "" {
+		// A state we can't read can't be undone, so start over from the
+		// current environment.
+		if prev, err := hook.DecodeState(data); err == nil {
+			state = prev
+		}
+	}
+
+	dir := hook.FindProject(cwd)
+
+	var hash string
+
+	if dir != "" {
+		hash, err = hook.ProjectHash(dir)
+		if err != nil {
+			return err
 		}
+	}
 
+	if state.Dir == dir && state.Hash == hash {
 		return nil
 	}
 
-	if opts.DumpEnv {
-		var w io.Writer
+	base := state.Restore(cur)
+	delete(base, hook.StateVar)
 
-		path := os.Getenv("DIRENV_DUMP_FILE_PATH")
+	next := map[string]string{}
 
-		if path == "" {
-			w = os.Stdout
+	for k, v := range base {
+		next[k] = v
+	}
+
+	if dir != "" {
+		env, err := projectEnv(ctx, cfg, dir, hash)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "iris: unable to load %s: %s\n", dir, err)
 		} else {
-			f, err := os.Create(path)
-			if err != nil {
-				return err
+			fmt.Fprintf(os.Stderr, "iris: loaded %s\n", dir)
+
+			updates := env.Updates(base)
+
+			for k, v := range updates {
+				next[k] = v
 			}
 
-			defer f.Close()
+			next[hook.StateVar] = hook.Record(dir, hash, base, updates).Encode()
+		}
+	} else if state.Dir != "" {
+		fmt.Fprintf(os.Stderr, "iris: unloaded %s\n", state.Dir)
+	}
 
-			w = f
+	hook.WriteChanges(os.Stdout, sh, hook.Changes(cur, next))
+
+	return nil
+}
+
+// projectEnv returns the environment of the project in dir, whose project
+// file has the given hash. It's cached, so the packages are only installed
+// when the project file changes.
+func projectEnv(ctx context.Context, cfg *config.Config, dir, hash string) (*profile.Env, error) {
+	cache := &hook.Cache{Dir: cfg.HookCachePath()}
+
+	env, err := cache.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if env != nil {
+		if _, err := os.Stat(filepath.Join(dir, ".iris-profile")); err == nil {
+			return env, nil
 		}
+	}
 
-		fmt.Fprintln(w, direnv.Dump(prof.EnvMap(os.Environ())))
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chdir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.Chdir(cwd)
+
+	// The shell evaluates stdout, so send the progress of installing the
+	// packages to stderr instead.
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+
+	defer func() {
+		os.Stdout = stdout
+	}()
+
+	prof, err := projectProfile(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	env, err = prof.Env()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cache.Put(hash, env)
+	if err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+// warnConflicts prints the files that more than one package linked into
+// prof provides and that weren't decided by priority.
+func warnConflicts(prof *profile.Profile) {
+	for _, c := range prof.Unresolved() {
+		fmt.Printf("! %s is provided by %s, using %s. Set a priority to choose one.\n",
+			c.Path, strings.Join(c.Packages, " and "), c.Packages[0])
+	}
+}
+
+func profileConflictsF(ctx context.Context, opts struct {
+	Global bool `short:"G" long:"global" description:"check the global profile instead of the project's"`
+}) error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	path := ".iris-profile"
+
+	if opts.Global {
+		path = cfg.GlobalProfilePath()
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return errors.Wrapf(err, "no profile at %s", path)
+	}
+
+	prof, err := profile.OpenProfile(cfg, path)
+	if err != nil {
+		return err
+	}
+
+	conflicts, err := prof.Conflicts()
+	if err != nil {
+		return err
+	}
+
+	if len(conflicts) == 0 {
+		fmt.Println("No conflicts")
 		return nil
 	}
 
-	env := prof.ComputeEnv(os.Environ())
+	tw := tabwriter.NewWriter(os.Stdout, 4, 2, 2, ' ', 0)
+	defer tw.Flush()
+
+	fmt.Fprintf(tw, "PATH\tLINKED\tALSO PROVIDED BY\tRESOLVED\n")
+
+	for _, c := range conflicts {
+		resolved := "by link order"
+		if c.Resolved {
+			resolved = "by priority"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Path, c.Packages[0], strings.Join(c.Packages[1:], ", "), resolved)
+	}
 
-	path, err := exec.LookPath(opts.Args[0])
+	return nil
+}
+
+func imageBuildF(ctx context.Context, opts struct {
+	Tag    string `short:"t" long:"tag" description:"push the image to the given reference"`
+	Output string `short:"o" long:"output" description:"write the image to the given tarball"`
+	Cars   string `long:"cars" description:"reuse .car files in the given directory for layers"`
+}) error {
+	if opts.Tag == "" && opts.Output == "" {
+		return fmt.Errorf("one of --tag or --output is required")
+	}
+
+	ref, err := name.ParseReference("iris-image:latest")
 	if err != nil {
 		return err
 	}
 
-	return unix.Exec(path, opts.Args, env)
+	if opts.Tag != "" {
+		ref, err = name.ParseReference(opts.Tag)
+		if err != nil {
+			return errors.Wrapf(err, "invalid tag %s", opts.Tag)
+		}
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	prof, err := projectProfile(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	ib := &ops.ImageBuild{
+		Store:      cfg.Store(),
+		LayerCache: cfg.ImageLayersPath(),
+		PrivateKey: cfg.Private(),
+	}
+
+	if opts.Cars != "" {
+		ib.CarCache = []string{opts.Cars}
+	}
+
+	img, err := ib.Build(prof)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Image contains %d packages\n", len(ib.Closure))
+
+	if opts.Output != "" {
+		err = tarball.WriteToFile(opts.Output, ref, img)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Wrote image to %s\n", opts.Output)
+	}
+
+	if opts.Tag != "" {
+		err = remote.Write(ref, img, remote.WithContext(ctx), remote.WithAuthFromKeychain(authn.DefaultKeychain))
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Pushed image to %s\n", ref)
+	}
+
+	return nil
+}
+
+func debugInfoF(ctx context.Context, opts struct {
+	Cars string `long:"cars" description:"look for debug .car files in the given directory first"`
+	Pos  struct {
+		Package string `positional-arg-name:"name"`
+	} `positional-args:"yes" required:"yes"`
+}) error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	var cl ops.ProjectLoad
+
+	proj, err := cl.Single(ctx, cfg, opts.Pos.Package)
+	if err != nil {
+		return err
+	}
+
+	di := &ops.DebugInfoInstall{
+		Store: cfg.Store(),
+	}
+
+	cars := opts.Cars
+	if cars == "" {
+		cars = os.Getenv("IRIS_EXPORT_DIR")
+	}
+
+	if cars != "" {
+		di.CarCache = []string{cars}
+	}
+
+	path, err := di.Install(ctx, proj.Install[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Debug info installed in %s\n", path)
+	fmt.Printf("Use it in gdb with: set debug-file-directory %s\n", path)
+
+	return nil
 }
 
 func inspectCarF(ctx context.Context, opts struct {
@@ -1147,6 +1706,39 @@ func systemInfoF(ctx context.Context, opts struct{}) error {
 	return nil
 }
 
+func logF(ctx context.Context, opts struct {
+	Follow bool `short:"f" long:"follow" description:"keep printing output while the build is running"`
+	List   bool `short:"l" long:"list" description:"list the package ids that have logs"`
+	Pos    struct {
+		Package string `positional-arg-name:"name|id"`
+	} `positional-args:"yes" required:"yes"`
+}) error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	if opts.List {
+		ids, err := ops.ListBuildLogs(cfg.LogsPath(), opts.Pos.Package)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			fmt.Println(id)
+		}
+
+		return nil
+	}
+
+	path, err := ops.FindBuildLog(cfg.LogsPath(), opts.Pos.Package)
+	if err != nil {
+		return err
+	}
+
+	return ops.CopyBuildLog(ctx, os.Stdout, path, opts.Follow)
+}
+
 func envF(ctx context.Context, opts struct {
 	Global bool `short:"G" long:"global-profile" description:"output location of global profile"`
 }) error {
@@ -1288,6 +1880,7 @@ func devF(ctx context.Context, opts struct {
 	ienv := &ops.InstallEnv{
 		Store:       cfg.Store(),
 		BuildDir:    filepath.Join(root, "build"),
+		LogDir:      filepath.Join(root, "logs"),
 		StateDir:    filepath.Join(root, "state"),
 		RetainBuild: true,
 		StartShell:  true,
@@ -1330,6 +1923,183 @@ func devF(ctx context.Context, opts struct {
 	return forceRemoveDir(path)
 }
 
+func sigDiffF(ctx context.Context, opts struct {
+	Package string `short:"p" long:"package" description:"package to compare when given git revisions"`
+	Repo    string `long:"repo" description:"git repository the revisions are from" default:"."`
+	Trace   bool   `long:"trace" description:"log in trace mode"`
+	Pos     struct {
+		Old string `positional-arg-name:"old"`
+		New string `positional-arg-name:"new"`
+	} `positional-args:"yes" required:"yes"`
+}) error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	level := hclog.Info
+
+	if opts.Trace {
+		level = hclog.Trace
+	}
+
+	L := hclog.New(&hclog.LoggerOptions{
+		Name:  "iris-sig-diff",
+		Level: level,
+	})
+
+	_, errOld := os.Stat(opts.Pos.Old)
+	_, errNew := os.Stat(opts.Pos.New)
+
+	files := errOld == nil && errNew == nil
+
+	if !files && opts.Package == "" {
+		return fmt.Errorf("package name required when comparing git revisions")
+	}
+
+	origPath := cfg.Path
+
+	defer func() {
+		cfg.Path = origPath
+	}()
+
+	load := func(rev string) (*ops.ScriptPackage, error) {
+		var (
+			dir  string
+			name = opts.Package
+		)
+
+		if files {
+			name = filepath.Clean(rev)
+
+			// Mark relative paths so they're loaded as files rather than
+			// looked up as package names.
+			if !filepath.IsAbs(name) {
+				name = "./" + name
+			}
+
+			dir, err = filepath.Abs(filepath.Dir(name))
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			dir, err = extractGitRev(opts.Repo, rev)
+			if err != nil {
+				return nil, err
+			}
+
+			defer os.RemoveAll(dir)
+		}
+
+		// Look in dir first so that dependencies are also loaded from the
+		// revision.
+		cfg.Path = dir + ":" + origPath
+
+		var cl ops.ProjectLoad
+		cl.SetLogger(L)
+
+		proj, err := cl.Single(ctx, cfg, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "loading %s", rev)
+		}
+
+		return proj.Install[0], nil
+	}
+
+	a, err := load(opts.Pos.Old)
+	if err != nil {
+		return err
+	}
+
+	b, err := load(opts.Pos.New)
+	if err != nil {
+		return err
+	}
+
+	ops.DiffSignatures(a, b).Write(os.Stdout)
+
+	return nil
+}
+
+// extractGitRev writes the contents of the git repository at dir, as of
+// rev, into a new temporary directory. If dir is a subdirectory of the
+// repository, only that subdirectory is extracted.
+func extractGitRev(dir, rev string) (string, error) {
+	prefix, err := exec.Command("git", "-C", dir, "rev-parse", "--show-prefix").Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "%s is not a git repository", dir)
+	}
+
+	// The extracted copy has no git metadata, so carry over the repo id
+	// that would be detected for dir.
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	var rd ops.RepoDetect
+
+	id, err := rd.Detect(abs)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := ioutil.TempDir("", "iris-sig-diff")
+	if err != nil {
+		return "", err
+	}
+
+	archive := exec.Command("git", "-C", dir, "archive", "--format=tar",
+		rev+":"+strings.TrimSpace(string(prefix)))
+
+	untar := exec.Command("tar", "-x", "-C", out)
+	untar.Stderr = os.Stderr
+
+	untar.Stdin, err = archive.StdoutPipe()
+	if err != nil {
+		os.RemoveAll(out)
+		return "", err
+	}
+
+	err = untar.Start()
+	if err != nil {
+		os.RemoveAll(out)
+		return "", err
+	}
+
+	archiveErr := archive.Run()
+
+	err = untar.Wait()
+
+	if archiveErr != nil {
+		os.RemoveAll(out)
+		return "", errors.Wrapf(archiveErr, "unable to read revision %s", rev)
+	}
+
+	if err != nil {
+		os.RemoveAll(out)
+		return "", err
+	}
+
+	if _, err := os.Stat(filepath.Join(out, ".repo-info.json")); err != nil {
+		f, err := os.Create(filepath.Join(out, ".repo-info.json"))
+		if err != nil {
+			os.RemoveAll(out)
+			return "", err
+		}
+
+		defer f.Close()
+
+		err = json.NewEncoder(f).Encode(&data.RepoInfo{Id: id})
+		if err != nil {
+			os.RemoveAll(out)
+			return "", err
+		}
+	}
+
+	return out, nil
+}
+
 func debugF(ctx context.Context, opts struct {
 	Script      string `short:"s" long:"script" description:"output info about a script"`
 	TestInstall string `short:"t" long:"test" description:"install a script in a test env"`
@@ -1419,6 +2189,7 @@ func debugF(ctx context.Context, opts struct {
 		ienv := &ops.InstallEnv{
 			Store:       store,
 			BuildDir:    filepath.Join(root, "build"),
+			LogDir:      filepath.Join(root, "logs"),
 			StateDir:    filepath.Join(root, "state"),
 			RetainBuild: true,
 			StartShell:  opts.Shell,
@@ -22,8 +22,16 @@ const (
 	Compile
 	Output
 	Language
+
+	// The modes of the compiler an option has an effect in. Options marked
+	// ForPP are used when preprocessing as well.
+	ModePreprocess
+	ModeCompile
+	ModeLink
 )
 
+const allModes = ModePreprocess | ModeCompile | ModeLink
+
 type CompOpt struct {
 	name    string
 	options CCOption
@@ -46,11 +54,11 @@ var knownOptions = []CompOpt{
 	parseOption("--param", Separated),
 	parseOption("--serialize-diagnostics", Separated),
 	parseOption("-A", CanBeSeparated),
-	parseOption("-D", CanBeSeparated),
+	parseOption("-D", CanBeSeparated, ModePreprocess),
 	parseOption("-G", Separated),
 	parseOption("-remap", ForPP),
 	parseOption("-trigraphs", ForPP),
-	parseOption("-U", CanBeSeparated),
+	parseOption("-U", CanBeSeparated, ModePreprocess),
 	parseOption("-u", CanBeSeparated),
 	parseOption("-x", CanBeSeparated, Language),
 	parseOption("-z", CanBeSeparated),
@@ -79,7 +87,7 @@ var knownOptions = []CompOpt{
 	parseOption("-iwithprefix", CanBeSeparated, ForPP),
 	parseOption("-iwithprefixbefore", CanBeSeparated, ForPP),
 	parseOption("-install_name", Separated),
-	parseOption("-L", CanBeSeparated),
+	parseOption("-L", CanBeSeparated, ModeLink),
 	parseOption("-no-canonical-prefixes"),
 	parseOption("--no-sysroot-suffix"),
 	parseOption("-nostdinc", ForPP),
@@ -95,6 +103,13 @@ var knownOptions = []CompOpt{
 	parseOption("-fno-working-directory", ForPP),
 	parseOption("-fworking-directory", ForPP),
 	parseOption("-stdlib=", Prefix, ForPP),
+
+	// Hardening, see hardening.go
+	parseOption("-fstack-protector-strong", ModeCompile),
+	parseOption("-fstack-clash-protection", ModeCompile),
+	parseOption("-fPIE", ModeCompile),
+	parseOption("-pie", ModeLink),
+	parseOption("-Wl,", Prefix, ModeLink),
 }
 
 // options that mean "don't bother"
@@ -137,6 +152,29 @@ func init() {
 	})
 }
 
+// OptionModes returns the modes of the compiler, out of ModePreprocess,
+// ModeCompile and ModeLink, that the option opt has an effect in. Options
+// the analyzer has no mode for are assumed to affect compiling.
+func OptionModes(opt string) CCOption {
+	for _, co := range knownOptions {
+		if co.name != opt && (co.options&(Prefix|CanBeSeparated) == 0 || !strings.HasPrefix(opt, co.name)) {
+			continue
+		}
+
+		modes := co.options & allModes
+
+		if co.options&ForPP == ForPP {
+			modes |= ModePreprocess
+		}
+
+		if modes != 0 {
+			return modes
+		}
+	}
+
+	return ModeCompile
+}
+
 type AnalyzedOperation struct {
 	Condition CCOption
 	Command   string
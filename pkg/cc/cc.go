@@ -30,6 +30,9 @@ type wrapper struct {
 
 	allowPrefixes map[string]struct{}
 
+	// The flags the compiler was found to reject, see probeCompiler
+	rejected map[string]bool
+
 	mac bool
 }
 
@@ -291,19 +294,15 @@ func refurbArgs(args []string) []string {
 	return out
 }
 
+// extractFile returns the source file compiled by a compiler run with args,
+// or "" if it doesn't compile (-c) exactly one file.
 func extractFile(args []string) string {
-	cmode := false
-	for _, arg := range args {
-		if arg == "-c" {
-			cmode = true
-		}
+	ao, err := Analyze(append([]string{""}, args...))
+	if err != nil || ao.Condition&Compile == 0 || len(ao.Inputs) != 1 {
+		return ""
 	}
 
-	if cmode {
-		return args[len(args)-1]
-	}
-
-	return ""
+	return ao.Inputs[0]
 }
 
 var (
@@ -416,6 +415,34 @@ func (w *wrapper) ldflags() []string {
 
 }
 
+// BuildDirPlaceholder is what the build directory is replaced with in the
+// file names compilers record in debug info and __FILE__.
+const BuildDirPlaceholder = "/aperture/build"
+
+// reproflags returns the flags that keep where and when a package was built
+// out of what the compiler produces.
+func (w *wrapper) reproflags() []string {
+	var args []string
+
+	if w.bi.BuildDir != "" {
+		mapping := w.bi.BuildDir + "=" + BuildDirPlaceholder + "/" + w.bi.Name + "-" + w.bi.Version
+
+		// -ffile-prefix-map covers __FILE__ as well, but it's dropped by
+		// supported on compilers that predate it. -fdebug-prefix-map is
+		// older, so the debug info is covered either way.
+		args = append(args,
+			"-ffile-prefix-map="+mapping,
+			"-fdebug-prefix-map="+mapping,
+		)
+	}
+
+	if w.bi.StrictRepro {
+		args = append(args, "-Wdate-time", "-Werror=date-time")
+	}
+
+	return w.supported(args)
+}
+
 func dup(args []string) []string {
 	n := make([]string, len(args))
 	copy(n, args)
@@ -445,19 +472,19 @@ func (w *wrapper) newArgs() []string {
 
 	switch w.mode {
 	case "ccld":
-		return join(w.cflags(), args, w.ldflags())
+		return join(w.cflags(), w.reproflags(), w.hardenflags(), args, w.ldflags())
 	case "cxxld":
-		return join(w.cxxflags(), args, w.ldflags())
+		return join(w.cxxflags(), w.reproflags(), w.hardenflags(), args, w.ldflags())
 	case "cc":
-		return join(w.cflags(), args)
+		return join(w.cflags(), w.reproflags(), w.hardenflags(), args)
 	case "cxx":
-		return join(w.cxxflags(), args)
+		return join(w.cxxflags(), w.reproflags(), w.hardenflags(), args)
 	case "ccE":
-		return args
+		return join(w.reproflags(), w.hardenflags(), args)
 	case "cpp":
-		return args
+		return join(w.reproflags(), w.hardenflags(), args)
 	case "ld":
-		return join(w.ldflags(), args)
+		return join(w.ldflags(), w.hardenflags(), args)
 	default:
 		panic("Shouldn't be here")
 	}
@@ -535,16 +562,18 @@ func Run(args []string, info, shimPath, cachePath string) error {
 		return err
 	}
 
-	newArgs := w.newArgs()
-
-	updated := append([]string{w.arg0}, newArgs...)
-
 	var (
 		env  []string
 		path string
 	)
 
+	var haveEpoch bool
+
 	for _, e := range os.Environ() {
+		if strings.HasPrefix(e, "SOURCE_DATE_EPOCH=") {
+			haveEpoch = true
+		}
+
 		if strings.HasPrefix(e, "PATH=") {
 			updated := e
 
@@ -559,6 +588,37 @@ func Run(args []string, info, shimPath, cachePath string) error {
 		}
 	}
 
+	// Compilers use it for __DATE__ and __TIME__. Builds set it already, but
+	// a script may have cleared the environment.
+	if !haveEpoch && w.bi.SourceDateEpoch != 0 {
+		env = append(env, fmt.Sprintf("SOURCE_DATE_EPOCH=%d", w.bi.SourceDateEpoch))
+	}
+
+	if w.mode != "ld" {
+		if compiler, err := LookPath(w.arg0, path); err == nil {
+			w.rejected = probeCompiler(os.Getenv("APERTURE_CC_PROBE"), compiler, w.mode, env)
+		}
+	}
+
+	newArgs := w.newArgs()
+
+	updated := append([]string{w.arg0}, newArgs...)
+
+	// Recorded before checking the cache, so that cached compiles are in
+	// the database too.
+	if dbPath := os.Getenv("APERTURE_CC_COMPDB"); dbPath != "" {
+		if cmd := newCompileCommand(dir, updated); cmd != nil {
+			if compiler, err := LookPath(w.arg0, path); err == nil {
+				cmd.Arguments = append([]string{compiler}, cmd.Arguments[1:]...)
+			}
+
+			err = appendCompileCommand(dbPath, cmd)
+			if err != nil {
+				L.Error("error recording compile command", "error", err, "path", dbPath)
+			}
+		}
+	}
+
 	var (
 		output    string
 		cacheInfo string
@@ -0,0 +1,188 @@
+package cc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"lab47.dev/aperture/pkg/data"
+)
+
+func TestWrapper(t *testing.T) {
+	wrap := func(bi *data.BuildInfo, args ...string) *wrapper {
+		return &wrapper{
+			bi:    bi,
+			arg0:  args[0],
+			given: args[1:],
+			mode:  calcMode(args[0], args),
+		}
+	}
+
+	bi := &data.BuildInfo{
+		Name:     "zlib",
+		Version:  "1.2.11",
+		BuildDir: "/home/user/.iris/build/build-abcd-zlib-1.2.11",
+	}
+
+	t.Run("maps the build dir to a placeholder", func(t *testing.T) {
+		w := wrap(bi, "cc", "-c", "adler32.c")
+
+		assert.Equal(t, []string{
+			"-ffile-prefix-map=/home/user/.iris/build/build-abcd-zlib-1.2.11=/aperture/build/zlib-1.2.11",
+			"-fdebug-prefix-map=/home/user/.iris/build/build-abcd-zlib-1.2.11=/aperture/build/zlib-1.2.11",
+			"-c", "adler32.c",
+		}, w.newArgs())
+
+		w = wrap(bi, "cpp", "adler32.c")
+
+		assert.Equal(t, "-ffile-prefix-map=/home/user/.iris/build/build-abcd-zlib-1.2.11=/aperture/build/zlib-1.2.11", w.newArgs()[0])
+	})
+
+	t.Run("rejects date macros when strict", func(t *testing.T) {
+		strict := *bi
+		strict.StrictRepro = true
+
+		w := wrap(&strict, "c++", "-c", "zlib.cc")
+
+		args := w.newArgs()
+
+		assert.Contains(t, args, "-Wdate-time")
+		assert.Contains(t, args, "-Werror=date-time")
+
+		w = wrap(bi, "c++", "-c", "zlib.cc")
+
+		assert.NotContains(t, w.newArgs(), "-Werror=date-time")
+	})
+
+	t.Run("leaves the linker alone", func(t *testing.T) {
+		w := wrap(&data.BuildInfo{BuildDir: "/build", StrictRepro: true}, "ld", "-o", "a.out", "a.o")
+
+		for _, arg := range w.newArgs() {
+			assert.NotContains(t, arg, "prefix-map")
+			assert.NotContains(t, arg, "date-time")
+		}
+	})
+}
+
+func TestHardening(t *testing.T) {
+	wrap := func(bi *data.BuildInfo, args ...string) *wrapper {
+		return &wrapper{
+			bi:    bi,
+			arg0:  args[0],
+			given: args[1:],
+			mode:  calcMode(args[0], args),
+		}
+	}
+
+	bi := &data.BuildInfo{Hardening: DefaultHardening}
+
+	t.Run("resolves opt outs", func(t *testing.T) {
+		features, err := ResolveHardening([]string{"-pie", "-stackclash"})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"stackprotector", "fortify", "relro", "bindnow"}, features)
+
+		_, err = ResolveHardening([]string{"-nx"})
+		assert.ErrorIs(t, err, ErrUnknownHardening)
+	})
+
+	t.Run("adds only the flags for the mode", func(t *testing.T) {
+		w := wrap(bi, "cc", "-O2", "-c", "a.c")
+
+		assert.Equal(t, []string{
+			"-fstack-protector-strong", "-D_FORTIFY_SOURCE=2", "-fPIE", "-fstack-clash-protection",
+		}, w.hardenflags())
+
+		w = wrap(bi, "cc", "-o", "a", "a.o")
+
+		assert.Equal(t, []string{
+			"-fstack-protector-strong", "-fPIE", "-pie", "-Wl,-z,relro", "-Wl,-z,now", "-fstack-clash-protection",
+		}, w.hardenflags())
+
+		w = wrap(bi, "ld", "-o", "a", "a.o")
+
+		assert.Equal(t, []string{"-pie", "-z", "relro", "-z", "now"}, w.hardenflags())
+	})
+
+	t.Run("skips features the args rule out", func(t *testing.T) {
+		w := wrap(bi, "cc", "-shared", "-o", "liba.so", "a.o")
+
+		assert.NotContains(t, w.hardenflags(), "-pie")
+
+		w = wrap(bi, "cc", "-O2", "-D_FORTIFY_SOURCE=1", "-c", "a.c")
+
+		assert.NotContains(t, w.hardenflags(), "-D_FORTIFY_SOURCE=2")
+
+		w = wrap(bi, "cc", "-O0", "-c", "a.c")
+
+		assert.NotContains(t, w.hardenflags(), "-D_FORTIFY_SOURCE=2")
+	})
+
+	t.Run("honors the package settings", func(t *testing.T) {
+		features, err := ResolveHardening([]string{"-pie"})
+		require.NoError(t, err)
+
+		w := wrap(&data.BuildInfo{Hardening: features}, "cc", "-o", "a", "a.o")
+
+		assert.NotContains(t, w.hardenflags(), "-pie")
+		assert.NotContains(t, w.hardenflags(), "-fPIE")
+	})
+}
+
+func TestProbe(t *testing.T) {
+	top, err := ioutil.TempDir("", "ccprobe")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(top)
+
+	// A compiler that predates -fstack-clash-protection, and counts how
+	// often it's run.
+	compiler := filepath.Join(top, "oldcc")
+	runs := filepath.Join(top, "runs")
+
+	script := "#!/bin/sh\necho >> " + runs + "\nfor a; do [ \"$a\" = -fstack-clash-protection ] && exit 1; done\nexit 0\n"
+	require.NoError(t, ioutil.WriteFile(compiler, []byte(script), 0755))
+
+	path := filepath.Join(top, "ccprobe.json")
+
+	t.Run("finds the flags the compiler rejects", func(t *testing.T) {
+		rejected := probeCompiler(path, compiler, "cc", nil)
+
+		assert.Equal(t, map[string]bool{"-fstack-clash-protection": true}, rejected)
+	})
+
+	t.Run("probes each compiler once", func(t *testing.T) {
+		rejected := probeCompiler(path, compiler, "cc", nil)
+
+		assert.Equal(t, map[string]bool{"-fstack-clash-protection": true}, rejected)
+
+		data, err := ioutil.ReadFile(runs)
+		require.NoError(t, err)
+
+		assert.Equal(t, len(probedFlags), strings.Count(string(data), "\n"))
+	})
+
+	t.Run("leaves out rejected flags", func(t *testing.T) {
+		w := &wrapper{
+			bi:       &data.BuildInfo{Name: "zlib", Version: "1.2.11", BuildDir: "/build", Hardening: DefaultHardening},
+			arg0:     "cc",
+			given:    []string{"-c", "a.c"},
+			mode:     "cc",
+			rejected: map[string]bool{"-ffile-prefix-map": true, "-fstack-clash-protection": true},
+		}
+
+		args := w.newArgs()
+
+		assert.Contains(t, args, "-fdebug-prefix-map=/build=/aperture/build/zlib-1.2.11")
+		assert.Contains(t, args, "-fstack-protector-strong")
+
+		for _, arg := range args {
+			assert.NotContains(t, arg, "-ffile-prefix-map")
+			assert.NotEqual(t, "-fstack-clash-protection", arg)
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package cc
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// CompileCommand is an entry of a JSON compilation database, the
+// compile_commands.json read by clangd and other tools.
+type CompileCommand struct {
+	Directory string   `json:"directory"`
+	Arguments []string `json:"arguments"`
+	File      string   `json:"file"`
+	Output    string   `json:"output,omitempty"`
+}
+
+// newCompileCommand returns the entry for running command in dir, or nil if
+// command doesn't compile a single source file.
+func newCompileCommand(dir string, command []string) *CompileCommand {
+	file := extractFile(command[1:])
+	if file == "" {
+		return nil
+	}
+
+	cmd := &CompileCommand{
+		Directory: dir,
+		Arguments: command,
+		File:      file,
+	}
+
+	ao, err := Analyze(command)
+	if err == nil && len(ao.Outputs) == 1 {
+		cmd.Output = ao.Outputs[0]
+	}
+
+	return cmd
+}
+
+// appendCompileCommand adds cmd to the database at path. The database is
+// written one entry per line, so that compiles running in parallel can all
+// append to it.
+func appendCompileCommand(path string, cmd *CompileCommand) error {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+
+	return err
+}
+
+// WriteCompilationDatabase writes the entries of the databases at paths to
+// dest as a compile_commands.json. Databases that don't exist are skipped,
+// and when a file was compiled more than once only the last command is
+// kept. It returns the number of entries written.
+func WriteCompilationDatabase(dest string, paths ...string) (int, error) {
+	type key struct {
+		dir, file, output string
+	}
+
+	var (
+		cmds []*CompileCommand
+		pos  = map[key]int{}
+	)
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return 0, err
+		}
+
+		dec := json.NewDecoder(f)
+
+		for {
+			var cmd CompileCommand
+
+			err = dec.Decode(&cmd)
+			if err != nil {
+				break
+			}
+
+			k := key{cmd.Directory, cmd.File, cmd.Output}
+
+			if idx, ok := pos[k]; ok {
+				cmds[idx] = &cmd
+			} else {
+				pos[k] = len(cmds)
+				cmds = append(cmds, &cmd)
+			}
+		}
+
+		f.Close()
+
+		if err != io.EOF {
+			return 0, errors.Wrapf(err, "reading compile commands from %s", path)
+		}
+	}
+
+	if cmds == nil {
+		cmds = []*CompileCommand{}
+	}
+
+	data, err := json.MarshalIndent(cmds, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+
+	return len(cmds), ioutil.WriteFile(dest, append(data, '\n'), 0644)
+}
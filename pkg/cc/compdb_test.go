@@ -0,0 +1,75 @@
+package cc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompDB(t *testing.T) {
+	top, err := ioutil.TempDir("", "compdb")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(top)
+
+	t.Run("finds the file being compiled", func(t *testing.T) {
+		assert.Equal(t, "qux.c", extractFile([]string{"-c", "qux.c", "-o", "qux.o"}))
+		assert.Equal(t, "qux.c", extractFile([]string{"-c", "-I", "inc", "qux.c"}))
+		assert.Equal(t, "", extractFile([]string{"qux.o", "-o", "qux"}))
+	})
+
+	t.Run("records compiles only", func(t *testing.T) {
+		cmd := newCompileCommand("/src", []string{"cc", "-DX=1", "-c", "-o", "build/qux.o", "qux.c"})
+		require.NotNil(t, cmd)
+
+		assert.Equal(t, &CompileCommand{
+			Directory: "/src",
+			Arguments: []string{"cc", "-DX=1", "-c", "-o", "build/qux.o", "qux.c"},
+			File:      "qux.c",
+			Output:    "build/qux.o",
+		}, cmd)
+
+		cmd = newCompileCommand("/src", []string{"cc", "-c", "lib/foo.c"})
+		require.NotNil(t, cmd)
+
+		assert.Equal(t, "lib/foo.o", cmd.Output)
+
+		assert.Nil(t, newCompileCommand("/src", []string{"cc", "-o", "qux", "qux.o"}))
+	})
+
+	t.Run("writes the last command of each file", func(t *testing.T) {
+		db := filepath.Join(top, "compdb.json")
+
+		for _, args := range [][]string{
+			{"cc", "-c", "a.c"},
+			{"cc", "-c", "b.c"},
+			{"cc", "-O2", "-c", "a.c"},
+		} {
+			require.NoError(t, appendCompileCommand(db, newCompileCommand("/src", args)))
+		}
+
+		dest := filepath.Join(top, "compile_commands.json")
+
+		count, err := WriteCompilationDatabase(dest, db, filepath.Join(top, "missing.json"))
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, count)
+
+		data, err := ioutil.ReadFile(dest)
+		require.NoError(t, err)
+
+		var cmds []*CompileCommand
+
+		require.NoError(t, json.Unmarshal(data, &cmds))
+
+		assert.Equal(t, []*CompileCommand{
+			{Directory: "/src", Arguments: []string{"cc", "-O2", "-c", "a.c"}, File: "a.c", Output: "a.o"},
+			{Directory: "/src", Arguments: []string{"cc", "-c", "b.c"}, File: "b.c", Output: "b.o"},
+		}, cmds)
+	})
+}
@@ -0,0 +1,214 @@
+package cc
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var ErrUnknownHardening = errors.New("unknown hardening feature")
+
+type hardening struct {
+	name string
+
+	// The flags given to the compiler driver. Each is only added in the
+	// modes OptionModes says it has an effect in.
+	flags []string
+
+	// The flags given when the linker is run directly
+	ld []string
+
+	// Supported by the macOS toolchain
+	mac bool
+
+	// Returns true when the feature can't be used with the given args
+	unless func(args []string) bool
+}
+
+var hardenings = []*hardening{
+	{
+		name:   "stackprotector",
+		flags:  []string{"-fstack-protector-strong"},
+		mac:    true,
+		unless: freestanding,
+	},
+	{
+		name:  "fortify",
+		flags: []string{"-D_FORTIFY_SOURCE=2"},
+		mac:   true,
+		unless: func(args []string) bool {
+			if !optimizing(args) {
+				return true
+			}
+
+			// Defining it twice is an error under -Werror
+			for _, a := range args {
+				if strings.Contains(a, "_FORTIFY_SOURCE") {
+					return true
+				}
+			}
+
+			return false
+		},
+	},
+	{
+		name:  "pie",
+		flags: []string{"-fPIE", "-pie"},
+		ld:    []string{"-pie"},
+		unless: func(args []string) bool {
+			return includesAny(args, "-shared", "-static", "-static-pie", "-r", "-nostdlib", "-nostartfiles")
+		},
+	},
+	{
+		name:   "relro",
+		flags:  []string{"-Wl,-z,relro"},
+		ld:     []string{"-z", "relro"},
+		unless: relocatable,
+	},
+	{
+		name:   "bindnow",
+		flags:  []string{"-Wl,-z,now"},
+		ld:     []string{"-z", "now"},
+		unless: relocatable,
+	},
+	{
+		name:   "stackclash",
+		flags:  []string{"-fstack-clash-protection"},
+		unless: freestanding,
+	},
+}
+
+// HardeningPolicy identifies the flags each hardening feature adds. It has
+// to change whenever the flags do, since changing it changes the signature
+// of every package and so has them rebuilt with the new flags.
+const HardeningPolicy = "1"
+
+// DefaultHardening are the hardening features applied to every package
+// that doesn't opt out of them.
+var DefaultHardening []string
+
+func init() {
+	for _, h := range hardenings {
+		DefaultHardening = append(DefaultHardening, h.name)
+	}
+}
+
+// ResolveHardening returns the hardening features of a package, given its
+// hardening settings. A setting of "-name" opts out of the feature name.
+func ResolveHardening(settings []string) ([]string, error) {
+	disabled := map[string]bool{}
+
+	for _, s := range settings {
+		name := strings.TrimPrefix(s, "-")
+
+		var known bool
+
+		for _, h := range hardenings {
+			if h.name == name {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			return nil, errors.Wrapf(ErrUnknownHardening, "%s", s)
+		}
+
+		disabled[name] = strings.HasPrefix(s, "-")
+	}
+
+	var features []string
+
+	for _, h := range hardenings {
+		if !disabled[h.name] {
+			features = append(features, h.name)
+		}
+	}
+
+	return features, nil
+}
+
+func includesAny(args []string, targets ...string) bool {
+	for _, t := range targets {
+		if includes(args, t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func freestanding(args []string) bool {
+	return includesAny(args, "-ffreestanding", "-nostdlib")
+}
+
+func relocatable(args []string) bool {
+	return includes(args, "-r")
+}
+
+// optimizing returns true if the last -O in args enables optimization,
+// which _FORTIFY_SOURCE needs.
+func optimizing(args []string) bool {
+	var on bool
+
+	for _, a := range args {
+		if strings.HasPrefix(a, "-O") {
+			on = a != "-O0"
+		}
+	}
+
+	return on
+}
+
+// modes returns the modes of the compiler the wrapper is running it in.
+func (w *wrapper) modes() CCOption {
+	switch w.mode {
+	case "cc", "cxx":
+		return ModePreprocess | ModeCompile
+	case "ccE", "cpp":
+		return ModePreprocess
+	case "ccld", "cxxld":
+		return allModes
+	default:
+		return 0
+	}
+}
+
+// hardenflags returns the flags for the hardening features of the package
+// being built that apply to the current mode.
+func (w *wrapper) hardenflags() []string {
+	var args []string
+
+	for _, h := range w.hardenings() {
+		if (w.mac && !h.mac) || (h.unless != nil && h.unless(w.given)) {
+			continue
+		}
+
+		if w.mode == "ld" {
+			args = append(args, h.ld...)
+			continue
+		}
+
+		for _, flag := range h.flags {
+			if OptionModes(flag)&w.modes() != 0 {
+				args = append(args, flag)
+			}
+		}
+	}
+
+	return w.supported(args)
+}
+
+func (w *wrapper) hardenings() []*hardening {
+	var out []*hardening
+
+	for _, h := range hardenings {
+		for _, name := range w.bi.Hardening {
+			if h.name == name {
+				out = append(out, h)
+			}
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,101 @@
+package cc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// probedFlags are the flags the wrapper adds that older compilers don't
+// know, mapped to the argument used to test for them. Compilers reject
+// unknown -f options, so each is only added once the compiler is found to
+// accept it.
+var probedFlags = map[string]string{
+	"-ffile-prefix-map":        "-ffile-prefix-map=/a=/b",
+	"-fstack-clash-protection": "-fstack-clash-protection",
+}
+
+// probeKey returns the name of flag without its value.
+func probeKey(flag string) string {
+	if idx := strings.IndexByte(flag, '='); idx != -1 {
+		return flag[:idx]
+	}
+
+	return flag
+}
+
+// probeCompiler returns the probed flags that compiler rejects. The results
+// are kept in the file at path, so each compiler is only probed once per
+// build.
+func probeCompiler(path, compiler, mode string, env []string) map[string]bool {
+	results := map[string]map[string]bool{}
+
+	if path != "" {
+		if data, err := ioutil.ReadFile(path); err == nil {
+			json.Unmarshal(data, &results)
+		}
+	}
+
+	if rejected, ok := results[compiler]; ok {
+		return rejected
+	}
+
+	// cpp only preprocesses, everything else is tested by compiling.
+	action := "-c"
+	if mode == "cpp" {
+		action = "-E"
+	}
+
+	rejected := map[string]bool{}
+
+	for key, flag := range probedFlags {
+		cmd := exec.Command(compiler, "-Werror", flag, "-x", "c", action, "-o", os.DevNull, os.DevNull)
+		cmd.Env = env
+
+		if cmd.Run() != nil {
+			rejected[key] = true
+		}
+	}
+
+	results[compiler] = rejected
+
+	if path != "" {
+		data, err := json.Marshal(results)
+		if err == nil {
+			// Compiles run in parallel, so the file is replaced rather than
+			// written in place. Results another compile recorded meanwhile
+			// may be lost, in which case the compiler is probed again.
+			tmp, err := ioutil.TempFile(filepath.Dir(path), ".ccprobe")
+			if err == nil {
+				tmp.Write(data)
+				tmp.Close()
+
+				if os.Rename(tmp.Name(), path) != nil {
+					os.Remove(tmp.Name())
+				}
+			}
+		}
+	}
+
+	return rejected
+}
+
+// supported returns args without the flags the compiler rejects.
+func (w *wrapper) supported(args []string) []string {
+	if len(w.rejected) == 0 {
+		return args
+	}
+
+	var out []string
+
+	for _, arg := range args {
+		if !w.rejected[probeKey(arg)] {
+			out = append(out, arg)
+		}
+	}
+
+	return out
+}
@@ -314,6 +314,20 @@ func (c *Config) BuildPath() string {
 	return filepath.Join(c.DataDir, "build")
 }
 
+func (c *Config) LogsPath() string {
+	return filepath.Join(c.DataDir, "logs")
+}
+
+// HookCachePath is where the shell hook caches the environment of projects.
+func (c *Config) HookCachePath() string {
+	return filepath.Join(c.DataDir, "hook")
+}
+
+// ImageLayersPath is where the image layers of store entries are cached.
+func (c *Config) ImageLayersPath() string {
+	return filepath.Join(c.DataDir, "image-layers")
+}
+
 func (c *Config) RootsPath() string {
 	return filepath.Join(c.DataDir, "roots")
 }
@@ -0,0 +1,231 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mr-tron/base58"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/blake2b"
+)
+
+// GitCache maintains bare copies of git repositories. It's shared between
+// PathMap, for package sources, and the git inputs of scripts so each
+// repository is only fetched once.
+type GitCache struct {
+	Dir string
+}
+
+func (c *Config) GitCache() *GitCache {
+	return &GitCache{Dir: filepath.Join(c.configDir, "paths")}
+}
+
+func (g *GitCache) path(url string) string {
+	// This effectively duplicates the functionality go uses for download
+	// modules. It's in cmd/go/internal/modfetch/codehost/git.go
+	key := "git:" + url
+
+	sum := blake2b.Sum256([]byte(key))
+
+	return filepath.Join(g.Dir, "cache/vcs", base58.Encode(sum[:]))
+}
+
+// Repo returns the path of the bare repository for url, creating it if
+// need be. It does not fetch anything.
+func (g *GitCache) Repo(ctx context.Context, url string) (string, error) {
+	cache := g.path(url)
+
+	if _, err := os.Stat(cache); err == nil {
+		return cache, nil
+	}
+
+	err := os.MkdirAll(cache, 0777)
+	if err != nil {
+		return "", err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(cache, ".info"), []byte("git:"+url), 0644)
+	if err != nil {
+		return "", err
+	}
+
+	err = run(ctx, cache, "git", "init", "--bare")
+	if err != nil {
+		os.RemoveAll(cache)
+		return "", err
+	}
+
+	err = run(ctx, cache, "git", "remote", "add", "origin", "--", url)
+	if err != nil {
+		os.RemoveAll(cache)
+		return "", err
+	}
+
+	return cache, nil
+}
+
+// Fetch updates the branches and tags of the bare repository for url,
+// returning its path.
+func (g *GitCache) Fetch(ctx context.Context, url string) (string, error) {
+	cache, err := g.Repo(ctx, url)
+	if err != nil {
+		return "", err
+	}
+
+	err = run(ctx, cache, "git", "fetch", "-f", url, "refs/heads/*:refs/heads/*", "refs/tags/*:refs/tags/*")
+	if err != nil {
+		os.RemoveAll(cache)
+		return "", err
+	}
+
+	return cache, nil
+}
+
+var commitRe = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// remoteHead is where the commit of the default branch of the repository
+// is kept in the cache. Fetch only copies branches and tags, and the HEAD
+// of the bare cache is whatever git init defaults to, not the default
+// branch of the repository.
+const remoteHead = "refs/remotes/origin/HEAD"
+
+// Resolve returns the commit hash that rev refers to in the repository at
+// url. A rev of HEAD refers to the default branch of url. Only a full
+// commit hash that's already in the cache is resolved without contacting
+// url, since branches, tags and HEAD can all move.
+func (g *GitCache) Resolve(ctx context.Context, url, rev string) (string, error) {
+	cache, err := g.Repo(ctx, url)
+	if err != nil {
+		return "", err
+	}
+
+	isCommit := commitRe.MatchString(rev)
+
+	if isCommit && g.hasCommit(ctx, cache, rev) {
+		return rev, nil
+	}
+
+	ref := rev
+
+	switch {
+	case rev == "HEAD":
+		ref = remoteHead
+
+		err = run(ctx, cache, "git", "fetch", "-f", url, "+HEAD:"+remoteHead)
+		if err != nil {
+			return "", errors.Wrapf(err, "fetching the default branch of %s", url)
+		}
+	default:
+		cache, err = g.Fetch(ctx, url)
+		if err != nil {
+			return "", err
+		}
+
+		// A commit that isn't reachable from any branch or tag must be
+		// fetched directly.
+		if isCommit && !g.hasCommit(ctx, cache, rev) {
+			err = run(ctx, cache, "git", "fetch", "-f", url, rev)
+			if err != nil {
+				return "", errors.Wrapf(err, "fetching commit %s from %s", rev, url)
+			}
+		}
+	}
+
+	commit, ok := g.lookup(ctx, cache, ref)
+	if !ok {
+		return "", fmt.Errorf("unable to resolve revision '%s' of %s", rev, url)
+	}
+
+	return commit, nil
+}
+
+// lookup returns the commit ref refers to in the bare repository at cache,
+// if it's there.
+func (g *GitCache) lookup(ctx context.Context, cache, ref string) (string, bool) {
+	out, err := capture(ctx, cache, "git", "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		return "", false
+	}
+
+	return strings.TrimSpace(string(out)), true
+}
+
+func (g *GitCache) hasCommit(ctx context.Context, cache, commit string) bool {
+	_, err := capture(ctx, cache, "git", "cat-file", "-e", commit+"^{commit}")
+	return err == nil
+}
+
+// Export writes the tree of commit, which must already be in the cache,
+// into dest. When submodules is set, the submodules are checked out as
+// well. dest contains no git metadata afterwards.
+func (g *GitCache) Export(ctx context.Context, url, commit, dest string, submodules bool) error {
+	cache, err := g.Repo(ctx, url)
+	if err != nil {
+		return err
+	}
+
+	err = run(ctx, "", "git", "clone", "--quiet", "--shared", "--no-checkout", cache, dest)
+	if err != nil {
+		return errors.Wrapf(err, "cloning %s", url)
+	}
+
+	err = run(ctx, dest, "git", "checkout", "--quiet", "--detach", commit)
+	if err != nil {
+		return errors.Wrapf(err, "checking out %s of %s", commit, url)
+	}
+
+	if submodules {
+		// The submodules are relative to the original url, not the cache.
+		err = run(ctx, dest, "git", "remote", "set-url", "origin", url)
+		if err != nil {
+			return err
+		}
+
+		err = run(ctx, dest, "git", "submodule", "update", "--init", "--recursive")
+		if err != nil {
+			return errors.Wrapf(err, "updating submodules of %s", url)
+		}
+	}
+
+	return removeGitDirs(dest)
+}
+
+// removeGitDirs removes the .git directory of dest along with those of any
+// submodules.
+func removeGitDirs(dest string) error {
+	var gitDirs []string
+
+	err := filepath.Walk(dest, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Name() == ".git" {
+			gitDirs = append(gitDirs, path)
+
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, path := range gitDirs {
+		err = os.RemoveAll(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -7,7 +7,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/url"
 	"os"
 	"os/exec"
@@ -15,8 +14,6 @@ import (
 	"strings"
 
 	"github.com/mitchellh/go-homedir"
-	"github.com/mr-tron/base58"
-	"golang.org/x/crypto/blake2b"
 	"golang.org/x/mod/module"
 	"lab47.dev/aperture/pkg/data"
 )
@@ -35,7 +32,7 @@ type PathMap struct {
 	Dir string
 }
 
-func (p *PathMap) run(ctx context.Context, dir string, cmds ...string) error {
+func run(ctx context.Context, dir string, cmds ...string) error {
 	cmd := exec.CommandContext(ctx, cmds[0], cmds[1:]...)
 	cmd.Dir = dir
 	cmd.Stdout = os.Stdout
@@ -44,7 +41,7 @@ func (p *PathMap) run(ctx context.Context, dir string, cmds ...string) error {
 	return cmd.Run()
 }
 
-func (p *PathMap) capture(ctx context.Context, dir string, cmds ...string) ([]byte, error) {
+func capture(ctx context.Context, dir string, cmds ...string) ([]byte, error) {
 	var buf bytes.Buffer
 
 	cmd := exec.CommandContext(ctx, cmds[0], cmds[1:]...)
@@ -110,15 +107,13 @@ func (p *PathMap) Map(ctx context.Context, pp *PackagePath) (string, error) {
 			resolvedVersion = pp.Version
 		}
 
-		// This effectively duplicates the functionality go uses for download
-		// modules. It's in cmd/go/internal/modfetch/codehost/git.go
-		key := pp.Type + ":" + pp.Location
+		gc := &GitCache{Dir: p.Dir}
 
-		cache := filepath.Join(p.Dir, "cache/vcs", p.hash(key))
+		cache := gc.path(pp.Location)
 
 		if _, err := os.Stat(destPath); err == nil {
 			// We still want to populate the resolved info
-			info, err := p.capture(ctx, cache, "git", "-c", "log.showsignature=false", "log", "-n1", "--format=format:%H %ct %D", resolvedVersion)
+			info, err := capture(ctx, cache, "git", "-c", "log.showsignature=false", "log", "-n1", "--format=format:%H %ct %D", resolvedVersion)
 			if err != nil {
 				return "", err
 			}
@@ -131,37 +126,12 @@ func (p *PathMap) Map(ctx context.Context, pp *PackagePath) (string, error) {
 			return destPath, nil
 		}
 
-		if _, err := os.Stat(cache); err != nil {
-			err = os.MkdirAll(cache, 0777)
-			if err != nil {
-				return "", err
-			}
-
-			err := ioutil.WriteFile(filepath.Join(cache, ".info"), []byte(key), 0644)
-			if err != nil {
-				return "", err
-			}
-
-			err = p.run(ctx, cache, "git", "init", "--bare")
-			if err != nil {
-				os.RemoveAll(cache)
-				return "", err
-			}
-
-			err = p.run(ctx, cache, "git", "remote", "add", "origin", "--", pp.Location)
-			if err != nil {
-				os.RemoveAll(cache)
-				return "", err
-			}
-		}
-
-		err = p.run(ctx, cache, "git", "fetch", "-f", pp.Location, "refs/heads/*:refs/heads/*", "refs/tags/*:refs/tags/*")
+		cache, err = gc.Fetch(ctx, pp.Location)
 		if err != nil {
-			os.RemoveAll(cache)
 			return "", err
 		}
 
-		info, err := p.capture(ctx, cache, "git", "-c", "log.showsignature=false", "log", "-n1", "--format=format:%H %ct %D", resolvedVersion)
+		info, err := capture(ctx, cache, "git", "-c", "log.showsignature=false", "log", "-n1", "--format=format:%H %ct %D", resolvedVersion)
 		if err != nil {
 			return "", err
 		}
@@ -170,7 +140,7 @@ func (p *PathMap) Map(ctx context.Context, pp *PackagePath) (string, error) {
 		f := strings.Fields(string(info))
 		pp.ResolvedVersion = f[0]
 
-		zipData, err := p.capture(ctx, cache, "git", "archive", "--format=zip", "--prefix=prefix/", resolvedVersion)
+		zipData, err := capture(ctx, cache, "git", "archive", "--format=zip", "--prefix=prefix/", resolvedVersion)
 		if err != nil {
 			return "", err
 		}
@@ -289,8 +259,3 @@ var repl = strings.NewReplacer(
 func (p *PathMap) composePath(pp *PackagePath) string {
 	return repl.Replace(pp.Location)
 }
-
-func (p *PathMap) hash(key string) string {
-	sum := blake2b.Sum256([]byte(key))
-	return base58.Encode(sum[:])
-}
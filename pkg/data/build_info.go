@@ -11,6 +11,16 @@ type BuildInfo struct {
 	Prefix   string `json:"prefix"`
 	BuildDir string `json:"build_dir"`
 
+	// Used as SOURCE_DATE_EPOCH, the time tools record instead of the
+	// current time
+	SourceDateEpoch int64 `json:"source_date_epoch,omitempty"`
+
+	// Fail compiles that use __DATE__, __TIME__ or __TIMESTAMP__
+	StrictRepro bool `json:"strict_repro,omitempty"`
+
+	// The hardening features compiles and links are done with
+	Hardening []string `json:"hardening,omitempty"`
+
 	Dependencies map[string]*BuildInfoDependency `json:"dependencies"`
 }
 
@@ -10,6 +10,12 @@ type CarPlatform struct {
 	OS        string `json:"os"`
 	OSVersion string `json:"os_version"`
 	Arch      string `json:"architecture"`
+
+	// The highest GLIBC_ and GLIBCXX_ symbol versions needed by the files
+	// in the car. A host needs at least these versions of its C and C++
+	// libraries to use the car.
+	Glibc   string `json:"glibc,omitempty"`
+	Glibcxx string `json:"glibcxx,omitempty"`
 }
 
 type CarInfo struct {
@@ -26,4 +32,12 @@ type CarInfo struct {
 	Platform *CarPlatform `json:"platform"`
 
 	Constraints map[string]string `json:"constraints"`
+
+	// Set when the car contains an output of another package
+	Output string `json:"output,omitempty"`
+	Main   string `json:"main,omitempty"`
+
+	// The store directory the package was built in. Paths to it in the
+	// package are rewritten when it's unpacked into a different store.
+	StoreDir string `json:"store_dir,omitempty"`
 }
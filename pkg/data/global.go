@@ -1,8 +1,9 @@
 package data
 
 type GlobalPackage struct {
-	Name string `json:"name"`
-	Id   string `json:"id"`
+	Name     string `json:"name"`
+	Id       string `json:"id"`
+	Priority int    `json:"priority,omitempty"`
 }
 
 type GlobalPackages struct {
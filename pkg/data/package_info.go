@@ -9,6 +9,93 @@ type PackageInput struct {
 	Id      string `json:"id,omitempty"`
 }
 
+// PackageEnv is an environment variable a package sets when it's part of a
+// profile. Either Value is set, replacing any existing value, or Paths are
+// prepended to the existing value. $prefix in either refers to the
+// package's directory in the store.
+type PackageEnv struct {
+	Name  string   `json:"name"`
+	Value string   `json:"value,omitempty"`
+	Paths []string `json:"paths,omitempty"`
+}
+
+// LinkProblem is a shared library needed by a file in a package that
+// doesn't resolve to the package itself, one of its dependencies, or an
+// allowed system library.
+type LinkProblem struct {
+	// The file that needs the library, relative to the package
+	File    string `json:"file"`
+	Library string `json:"library"`
+
+	// Where the library was found, empty if it wasn't
+	Path string `json:"path,omitempty"`
+}
+
+// LinkAudit is the result of checking the shared libraries needed by the
+// files of a package after it's built.
+type LinkAudit struct {
+	// Libraries that couldn't be found at all
+	Unresolved []*LinkProblem `json:"unresolved,omitempty"`
+
+	// Libraries found outside the package and its dependencies, typically
+	// on the host in /usr/lib
+	Undeclared []*LinkProblem `json:"undeclared,omitempty"`
+}
+
+// Clean returns true if the audit found no problems.
+func (l *LinkAudit) Clean() bool {
+	return len(l.Unresolved) == 0 && len(l.Undeclared) == 0
+}
+
+// PkgConfigProblem is a problem found in one of the pkg-config files a
+// package installs.
+type PkgConfigProblem struct {
+	// The .pc file, relative to the package
+	File    string `json:"file"`
+	Message string `json:"message"`
+}
+
+// PkgConfigCheck is the result of checking the pkg-config files of a
+// package after it's built.
+type PkgConfigCheck struct {
+	// Requirements that don't resolve within the package's dependencies,
+	// and paths into the build directory
+	Errors []*PkgConfigProblem `json:"errors,omitempty"`
+
+	// Paths outside of the store
+	Warnings []*PkgConfigProblem `json:"warnings,omitempty"`
+}
+
+// Clean returns true if the check found no problems.
+func (p *PkgConfigCheck) Clean() bool {
+	return len(p.Errors) == 0 && len(p.Warnings) == 0
+}
+
+// The ways a runtime dependency can be detected.
+const (
+	DepLinked      = "linked"
+	DepInterpreter = "interpreter"
+	DepPkgConfig   = "pkg-config"
+	DepScript      = "script"
+	DepSymlink     = "symlink"
+	DepReference   = "reference"
+	DepExplicit    = "explicit"
+)
+
+// RuntimeDepReason records why a package was found to depend on another
+// at runtime.
+type RuntimeDepReason struct {
+	// One of the Dep* kinds
+	Kind string `json:"kind"`
+
+	// The file in the package that refers to the dependency
+	File string `json:"file,omitempty"`
+
+	// What the file refers to: a library, interpreter, pkg-config name
+	// or path
+	Detail string `json:"detail,omitempty"`
+}
+
 type PackageInfo struct {
 	Id          string            `json:"id"`
 	Name        string            `json:"name"`
@@ -19,4 +106,23 @@ type PackageInfo struct {
 	BuildDeps   []string          `json:"build_deps"`
 	Constraints map[string]string `json:"constraints"`
 	Inputs      []*PackageInput   `json:"inputs"`
+
+	// Set when this package is an output of another package
+	Output string `json:"output,omitempty"`
+	Main   string `json:"main,omitempty"`
+
+	// Maps the names of outputs of this package to their ids
+	Outputs map[string]string `json:"outputs,omitempty"`
+
+	// The environment to set when the package is in a profile
+	Environment []*PackageEnv `json:"environment,omitempty"`
+
+	// The result of auditing the shared libraries the package links against
+	LinkAudit *LinkAudit `json:"link_audit,omitempty"`
+
+	// The result of checking the package's pkg-config files, if it has any
+	PkgConfigCheck *PkgConfigCheck `json:"pkgconfig_check,omitempty"`
+
+	// Why each of RuntimeDeps is a runtime dependency, keyed by id
+	RuntimeDepReasons map[string]*RuntimeDepReason `json:"runtime_dep_reasons,omitempty"`
 }
@@ -23,3 +23,27 @@ func Dump(obj map[string]string) string {
 
 	return base64.URLEncoding.EncodeToString(zlibData.Bytes())
 }
+
+// Load decodes a value produced by Dump.
+func Load(data string) (map[string]string, error) {
+	zlibData, err := base64.URLEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("base64 decoding: %w", err)
+	}
+
+	r, err := zlib.NewReader(bytes.NewReader(zlibData))
+	if err != nil {
+		return nil, fmt.Errorf("zlib opening: %w", err)
+	}
+
+	defer r.Close()
+
+	var obj map[string]string
+
+	err = json.NewDecoder(r).Decode(&obj)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal(): %w", err)
+	}
+
+	return obj, nil
+}
@@ -266,9 +266,18 @@ func (c *Collector) SweepUnmarked(ctx context.Context, marked []string) ([]strin
 				continue
 			}
 
-			if _, ok := inUse[name]; !ok {
-				notInUse = append(notInUse, name)
+			if _, ok := inUse[name]; ok {
+				continue
+			}
+
+			// Debug info stays as long as its package does.
+			if id := strings.TrimSuffix(name, ".debug"); id != name {
+				if _, ok := inUse[id]; ok {
+					continue
+				}
 			}
+
+			notInUse = append(notInUse, name)
 		}
 	}
 
@@ -0,0 +1,65 @@
+package hook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"lab47.dev/aperture/pkg/profile"
+)
+
+// Cache stores the environment computed for a project, keyed by the hash
+// of its project file, so the hook only has to install packages and
+// update the profile when the project file changes.
+type Cache struct {
+	Dir string
+}
+
+func (c *Cache) path(hash string) string {
+	return filepath.Join(c.Dir, hash+".json")
+}
+
+// Get returns the cached environment for hash, or nil if there is none.
+func (c *Cache) Get(hash string) (*profile.Env, error) {
+	data, err := ioutil.ReadFile(c.path(hash))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var env profile.Env
+
+	err = json.Unmarshal(data, &env)
+	if err != nil {
+		// A corrupt entry is treated as missing, it'll be rewritten.
+		return nil, nil
+	}
+
+	return &env, nil
+}
+
+// Put caches env for hash.
+func (c *Cache) Put(hash string, env *profile.Env) error {
+	err := os.MkdirAll(c.Dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	tmp := c.path(hash) + ".tmp"
+
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, c.path(hash))
+}
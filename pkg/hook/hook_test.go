@@ -0,0 +1,128 @@
+package hook
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"lab47.dev/aperture/pkg/profile"
+)
+
+func TestHook(t *testing.T) {
+	t.Run("round trips the state", func(t *testing.T) {
+		s := &State{
+			Dir:   "/proj",
+			Hash:  "abcd",
+			Prev:  map[string]string{"PATH": "/bin", "EMPTY": ""},
+			Added: []string{"JAVA_HOME"},
+		}
+
+		s2, err := DecodeState(s.Encode())
+		require.NoError(t, err)
+
+		assert.Equal(t, s, s2)
+	})
+
+	t.Run("restores the environment from before the project", func(t *testing.T) {
+		base := map[string]string{"PATH": "/bin", "HOME": "/home/test"}
+		updates := map[string]string{"PATH": "/proj/bin:/bin", "JAVA_HOME": "/jdk"}
+
+		s := Record("/proj", "abcd", base, updates)
+
+		cur := map[string]string{"PATH": "/proj/bin:/bin", "JAVA_HOME": "/jdk", "HOME": "/home/test"}
+
+		assert.Equal(t, base, s.Restore(cur))
+	})
+
+	t.Run("computes the changes to make", func(t *testing.T) {
+		cur := map[string]string{"PATH": "/bin", "OLD": "x", "SAME": "y"}
+		next := map[string]string{"PATH": "/proj/bin:/bin", "NEW": "", "SAME": "y"}
+
+		changes := Changes(cur, next)
+
+		require.Len(t, changes, 3)
+		assert.Nil(t, changes["OLD"])
+		assert.Equal(t, "", *changes["NEW"])
+		assert.Equal(t, "/proj/bin:/bin", *changes["PATH"])
+	})
+
+	t.Run("writes changes for each shell", func(t *testing.T) {
+		value := "it's"
+		path := "/a:/b"
+		changes := map[string]*string{"NAME": &value, "PATH": &path, "OLD": nil}
+
+		expected := map[string]string{
+			"bash": "export NAME='it'\\''s';\nunset OLD;\nexport PATH='/a:/b';\n",
+			"zsh":  "export NAME='it'\\''s';\nunset OLD;\nexport PATH='/a:/b';\n",
+			"fish": "set -gx NAME 'it\\'s';\nset -e OLD;\nset -gx PATH '/a' '/b';\n",
+		}
+
+		for name, out := range expected {
+			sh, err := Lookup(name)
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			WriteChanges(&buf, sh, changes)
+
+			assert.Equal(t, out, buf.String(), name)
+		}
+
+		_, err := Lookup("csh")
+		assert.ErrorIs(t, err, ErrUnknownShell)
+	})
+
+	t.Run("finds the project and hashes it", func(t *testing.T) {
+		top, err := ioutil.TempDir("", "hook")
+		require.NoError(t, err)
+
+		defer os.RemoveAll(top)
+
+		sub := filepath.Join(top, "proj", "a", "b")
+		require.NoError(t, os.MkdirAll(sub, 0755))
+
+		pf := filepath.Join(top, "proj", ProjectFile)
+		require.NoError(t, ioutil.WriteFile(pf, []byte("install(\"jq\")\n"), 0644))
+
+		dir := FindProject(sub)
+		assert.Equal(t, filepath.Join(top, "proj"), dir)
+
+		h1, err := ProjectHash(dir)
+		require.NoError(t, err)
+
+		require.NoError(t, ioutil.WriteFile(pf, []byte("install(\"yq\")\n"), 0644))
+
+		h2, err := ProjectHash(dir)
+		require.NoError(t, err)
+
+		assert.NotEqual(t, h1, h2)
+	})
+
+	t.Run("caches environments by hash", func(t *testing.T) {
+		top, err := ioutil.TempDir("", "hook")
+		require.NoError(t, err)
+
+		defer os.RemoveAll(top)
+
+		cache := &Cache{Dir: filepath.Join(top, "cache")}
+
+		env, err := cache.Get("abcd")
+		require.NoError(t, err)
+		assert.Nil(t, env)
+
+		env = &profile.Env{
+			Set:   map[string]string{"JAVA_HOME": "/jdk"},
+			Paths: map[string][]string{"PATH": {"/proj/bin"}},
+		}
+
+		require.NoError(t, cache.Put("abcd", env))
+
+		env2, err := cache.Get("abcd")
+		require.NoError(t, err)
+
+		assert.Equal(t, env, env2)
+	})
+}
@@ -0,0 +1,153 @@
+package hook
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var ErrUnknownShell = errors.New("unknown shell")
+
+// Shell generates the code for a particular shell to install the hook and
+// to apply changes to the environment.
+type Shell interface {
+	// Hook returns the code that installs the hook. It's evaluated from the
+	// shell's rc file.
+	Hook() string
+
+	Export(w io.Writer, name, value string)
+	Unset(w io.Writer, name string)
+}
+
+// Lookup returns the Shell for name.
+func Lookup(name string) (Shell, error) {
+	switch name {
+	case "bash":
+		return bash{}, nil
+	case "zsh":
+		return zsh{}, nil
+	case "fish":
+		return fish{}, nil
+	default:
+		return nil, errors.Wrapf(ErrUnknownShell, "%s, expected bash, zsh or fish", name)
+	}
+}
+
+// WriteChanges writes the code for sh that applies changes, where a nil
+// value unsets the variable. The variables are written in sorted order so
+// the output is stable.
+func WriteChanges(w io.Writer, sh Shell, changes map[string]*string) {
+	var names []string
+
+	for name := range changes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if v := changes[name]; v == nil {
+			sh.Unset(w, name)
+		} else {
+			sh.Export(w, name, *v)
+		}
+	}
+}
+
+// Quote quotes s for use as a single word in a posix shell.
+func Quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+type bash struct{}
+
+const bashHook = `_iris_hook() {
+  local previous_exit_status=$?
+  trap -- '' SIGINT
+  eval "$(iris hook export bash)"
+  trap - SIGINT
+  return $previous_exit_status
+}
+
+if [[ ";${PROMPT_COMMAND[*]:-};" != *";_iris_hook;"* ]]; then
+  PROMPT_COMMAND="_iris_hook${PROMPT_COMMAND:+;$PROMPT_COMMAND}"
+fi
+`
+
+func (bash) Hook() string {
+	return bashHook
+}
+
+func (bash) Export(w io.Writer, name, value string) {
+	fmt.Fprintf(w, "export %s=%s;\n", name, Quote(value))
+}
+
+func (bash) Unset(w io.Writer, name string) {
+	fmt.Fprintf(w, "unset %s;\n", name)
+}
+
+type zsh struct {
+	bash
+}
+
+const zshHook = `_iris_hook() {
+  trap -- '' SIGINT
+  eval "$(iris hook export zsh)"
+  trap - SIGINT
+}
+
+typeset -ag precmd_functions
+if (( ! ${precmd_functions[(I)_iris_hook]} )); then
+  precmd_functions=(_iris_hook $precmd_functions)
+fi
+
+typeset -ag chpwd_functions
+if (( ! ${chpwd_functions[(I)_iris_hook]} )); then
+  chpwd_functions=(_iris_hook $chpwd_functions)
+fi
+`
+
+func (zsh) Hook() string {
+	return zshHook
+}
+
+type fish struct{}
+
+const fishHook = `function __iris_hook --on-event fish_prompt --on-variable PWD
+  iris hook export fish | source
+end
+`
+
+func (fish) Hook() string {
+	return fishHook
+}
+
+// fishQuote quotes s for fish, which only treats \ and ' specially inside
+// single quotes.
+func fishQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return "'" + strings.Replace(s, "'", `\'`, -1) + "'"
+}
+
+func (fish) Export(w io.Writer, name, value string) {
+	// fish stores PATH as a list, so give it each element separately.
+	if name == "PATH" {
+		var parts []string
+
+		for _, p := range strings.Split(value, ":") {
+			parts = append(parts, fishQuote(p))
+		}
+
+		fmt.Fprintf(w, "set -gx %s %s;\n", name, strings.Join(parts, " "))
+		return
+	}
+
+	fmt.Fprintf(w, "set -gx %s %s;\n", name, fishQuote(value))
+}
+
+func (fish) Unset(w io.Writer, name string) {
+	fmt.Fprintf(w, "set -e %s;\n", name)
+}
@@ -0,0 +1,174 @@
+package hook
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mr-tron/base58"
+	"golang.org/x/crypto/blake2b"
+	"lab47.dev/aperture/pkg/direnv"
+)
+
+// StateVar is the environment variable the hook keeps its state in.
+const StateVar = "IRIS_HOOK"
+
+// ProjectFile is the file that marks the root of a project.
+const ProjectFile = "project.xcr"
+
+// State records the project whose environment is applied and what it
+// changed, so that the changes can be undone when leaving the project.
+type State struct {
+	// The directory of the project and the hash of its project file
+	Dir  string
+	Hash string
+
+	// The values of variables before the project's environment was applied
+	Prev map[string]string
+
+	// The variables that were unset before the project's environment was
+	// applied
+	Added []string
+}
+
+// Encode returns the state in the direnv dump format. Variable names can't
+// contain '=', so the prefixes used for them can't collide with each other
+// or with the other keys.
+func (s *State) Encode() string {
+	m := map[string]string{
+		"dir":  s.Dir,
+		"hash": s.Hash,
+	}
+
+	for k, v := range s.Prev {
+		m["="+k] = v
+	}
+
+	for _, k := range s.Added {
+		m["+"+k] = ""
+	}
+
+	return direnv.Dump(m)
+}
+
+// DecodeState parses a value produced by State.Encode.
+func DecodeState(data string) (*State, error) {
+	m, err := direnv.Load(data)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &State{
+		Dir:  m["dir"],
+		Hash: m["hash"],
+		Prev: map[string]string{},
+	}
+
+	for k, v := range m {
+		if len(k) < 2 {
+			continue
+		}
+
+		switch k[0] {
+		case '=':
+			s.Prev[k[1:]] = v
+		case '+':
+			s.Added = append(s.Added, k[1:])
+		}
+	}
+
+	return s, nil
+}
+
+// Restore returns env with the changes recorded in s undone.
+func (s *State) Restore(env map[string]string) map[string]string {
+	out := map[string]string{}
+
+	for k, v := range env {
+		out[k] = v
+	}
+
+	for k, v := range s.Prev {
+		out[k] = v
+	}
+
+	for _, k := range s.Added {
+		delete(out, k)
+	}
+
+	return out
+}
+
+// Record returns the state for applying updates to base in the project at
+// dir, whose project file has the given hash.
+func Record(dir, hash string, base, updates map[string]string) *State {
+	s := &State{
+		Dir:  dir,
+		Hash: hash,
+		Prev: map[string]string{},
+	}
+
+	for k := range updates {
+		if v, ok := base[k]; ok {
+			s.Prev[k] = v
+		} else {
+			s.Added = append(s.Added, k)
+		}
+	}
+
+	return s
+}
+
+// Changes returns the variables that differ between cur and next. A nil
+// value means the variable should be unset.
+func Changes(cur, next map[string]string) map[string]*string {
+	changes := map[string]*string{}
+
+	for k, v := range next {
+		if cv, ok := cur[k]; !ok || cv != v {
+			v := v
+			changes[k] = &v
+		}
+	}
+
+	for k := range cur {
+		if _, ok := next[k]; !ok {
+			changes[k] = nil
+		}
+	}
+
+	return changes
+}
+
+// FindProject returns the closest directory at or above dir that contains
+// a project file, or "" if there is none.
+func FindProject(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ProjectFile)); err == nil {
+			return dir
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+
+		dir = parent
+	}
+}
+
+// ProjectHash returns the hash of the project file in dir. The directory
+// is included since the environment refers to the project's profile.
+func ProjectHash(dir string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, ProjectFile))
+	if err != nil {
+		return "", err
+	}
+
+	h, _ := blake2b.New256(nil)
+	h.Write([]byte(dir))
+	h.Write([]byte{0})
+	h.Write(data)
+
+	return base58.Encode(h.Sum(nil)), nil
+}
@@ -0,0 +1,276 @@
+package ops
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	buildLogExt      = ".log"
+	buildLogDoneExt  = ".log.gz"
+	buildLogFailMark = "!!!"
+)
+
+// BuildLog records the output of every command run while installing a
+// package. While the build is running the log is written uncompressed so
+// that it can be followed, and on Close it is compressed into place.
+//
+// Logs are kept at <dir>/<name>/<id>.log.gz so they can be found by either
+// the package name or id.
+type BuildLog struct {
+	mu sync.Mutex
+
+	path string
+	f    *os.File
+
+	cur string
+}
+
+// OpenBuildLog creates the log for the package identified by name and id,
+// replacing any existing log for the same id.
+func OpenBuildLog(dir, name, id string) (*BuildLog, error) {
+	pkgDir := filepath.Join(dir, name)
+
+	err := os.MkdirAll(pkgDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(pkgDir, id)
+
+	f, err := os.Create(path + buildLogExt)
+	if err != nil {
+		return nil, err
+	}
+
+	os.Remove(path + buildLogDoneExt)
+
+	bl := &BuildLog{path: path, f: f}
+
+	fmt.Fprintf(f, "# %s build started at %s\n", id, time.Now().Format(time.RFC3339))
+
+	return bl, nil
+}
+
+// Path returns the location the log will be available at once closed.
+func (b *BuildLog) Path() string {
+	return b.path + buildLogDoneExt
+}
+
+// Begin marks the start of a new command in the log.
+func (b *BuildLog) Begin(desc string) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.cur = desc
+
+	fmt.Fprintf(b.f, "\n==> %s\n", desc)
+}
+
+// Line adds one line of command output to the log.
+func (b *BuildLog) Line(line string) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	fmt.Fprintln(b.f, strings.TrimRight(line, " \n\t"))
+}
+
+// Failed records that the current command failed with err. The failure is
+// marked so it stands out when reading the log.
+func (b *BuildLog) Failed(err error) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	fmt.Fprintf(b.f, "%s FAILED: %s\n", buildLogFailMark, b.cur)
+	fmt.Fprintf(b.f, "%s error: %s\n", buildLogFailMark, err)
+}
+
+// Close finishes the log and compresses it into its final location.
+func (b *BuildLog) Close() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	fmt.Fprintf(b.f, "\n# build finished at %s\n", time.Now().Format(time.RFC3339))
+
+	_, err := b.f.Seek(0, io.SeekStart)
+	if err != nil {
+		b.f.Close()
+		return err
+	}
+
+	defer os.Remove(b.path + buildLogExt)
+	defer b.f.Close()
+
+	tmp := b.path + buildLogDoneExt + ".tmp"
+
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp)
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+
+	_, err = io.Copy(gz, b.f)
+	if err != nil {
+		return err
+	}
+
+	err = gz.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, b.path+buildLogDoneExt)
+}
+
+// FindBuildLog returns the path to the most recent log for the package
+// named or identified by target. The returned path refers to the
+// uncompressed log if the build is still running.
+func FindBuildLog(dir, target string) (string, error) {
+	var candidates []string
+
+	for _, ext := range []string{buildLogExt, buildLogDoneExt} {
+		// target as a name
+		matches, err := filepath.Glob(filepath.Join(dir, target, "*"+ext))
+		if err != nil {
+			return "", err
+		}
+
+		candidates = append(candidates, matches...)
+
+		// target as an id
+		matches, err = filepath.Glob(filepath.Join(dir, "*", target+ext))
+		if err != nil {
+			return "", err
+		}
+
+		candidates = append(candidates, matches...)
+	}
+
+	var (
+		best    string
+		bestMod time.Time
+	)
+
+	for _, path := range candidates {
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		if best == "" || fi.ModTime().After(bestMod) {
+			best = path
+			bestMod = fi.ModTime()
+		}
+	}
+
+	if best == "" {
+		return "", errors.Wrapf(ErrNotFound, "no build log for '%s'", target)
+	}
+
+	return best, nil
+}
+
+// ListBuildLogs returns the ids of all packages with a log for name.
+func ListBuildLogs(dir, name string) ([]string, error) {
+	ents, err := os.ReadDir(filepath.Join(dir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var ids []string
+
+	for _, ent := range ents {
+		n := ent.Name()
+
+		switch {
+		case strings.HasSuffix(n, buildLogDoneExt):
+			ids = append(ids, strings.TrimSuffix(n, buildLogDoneExt))
+		case strings.HasSuffix(n, buildLogExt):
+			ids = append(ids, strings.TrimSuffix(n, buildLogExt))
+		}
+	}
+
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// CopyBuildLog writes the log at path to w. If follow is set and the build
+// is still running, new output is copied as it's written until the build
+// finishes or ctx is canceled.
+func CopyBuildLog(ctx context.Context, w io.Writer, path string, follow bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	if strings.HasSuffix(path, buildLogDoneExt) {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return errors.Wrapf(err, "reading build log")
+		}
+
+		_, err = io.Copy(w, gz)
+		return err
+	}
+
+	for {
+		_, err = io.Copy(w, f)
+		if err != nil {
+			return err
+		}
+
+		if !follow {
+			return nil
+		}
+
+		// Once the uncompressed log is removed, the build is done and
+		// anything left has already been copied.
+		if _, err := os.Stat(path); err != nil {
+			_, err = io.Copy(w, f)
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package ops
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "buildlog")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+
+	t.Run("compresses the log on close", func(t *testing.T) {
+		bl, err := OpenBuildLog(dir, "foo", "abc-foo-1.0")
+		require.NoError(t, err)
+
+		bl.Begin("system: make")
+		bl.Line("compiling\n")
+
+		require.NoError(t, bl.Close())
+
+		_, err = os.Stat(filepath.Join(dir, "foo", "abc-foo-1.0.log"))
+		assert.True(t, os.IsNotExist(err))
+
+		var buf bytes.Buffer
+
+		err = CopyBuildLog(ctx, &buf, bl.Path(), false)
+		require.NoError(t, err)
+
+		assert.Contains(t, buf.String(), "==> system: make\ncompiling\n")
+	})
+
+	t.Run("highlights the failing command", func(t *testing.T) {
+		bl, err := OpenBuildLog(dir, "bar", "abc-bar-1.0")
+		require.NoError(t, err)
+
+		bl.Begin("system: make install")
+		bl.Failed(errors.New("exit status 2"))
+
+		require.NoError(t, bl.Close())
+
+		var buf bytes.Buffer
+
+		err = CopyBuildLog(ctx, &buf, bl.Path(), false)
+		require.NoError(t, err)
+
+		assert.Contains(t, buf.String(), "!!! FAILED: system: make install\n!!! error: exit status 2\n")
+	})
+
+	t.Run("finds logs by name or id", func(t *testing.T) {
+		path, err := FindBuildLog(dir, "foo")
+		require.NoError(t, err)
+
+		assert.Equal(t, filepath.Join(dir, "foo", "abc-foo-1.0.log.gz"), path)
+
+		path, err = FindBuildLog(dir, "abc-bar-1.0")
+		require.NoError(t, err)
+
+		assert.Equal(t, filepath.Join(dir, "bar", "abc-bar-1.0.log.gz"), path)
+
+		_, err = FindBuildLog(dir, "qux")
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+
+	t.Run("can read a log of a running build", func(t *testing.T) {
+		bl, err := OpenBuildLog(dir, "baz", "abc-baz-1.0")
+		require.NoError(t, err)
+
+		defer bl.Close()
+
+		bl.Begin("shell: ./configure")
+
+		path, err := FindBuildLog(dir, "baz")
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = CopyBuildLog(ctx, &buf, path, false)
+		require.NoError(t, err)
+
+		assert.Contains(t, buf.String(), "==> shell: ./configure\n")
+	})
+}
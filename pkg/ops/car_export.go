@@ -33,7 +33,10 @@ func (c *CarExport) Export(pkg *ScriptPackage, path, dest string) (*ExportedCar,
 		})
 	}
 
-	osName, osVer, arch := config.Platform()
+	platform, err := carPlatform(path)
+	if err != nil {
+		return nil, err
+	}
 
 	ci := &data.CarInfo{
 		ID:           pkg.ID(),
@@ -42,15 +45,50 @@ func (c *CarExport) Export(pkg *ScriptPackage, path, dest string) (*ExportedCar,
 		Repo:         pkg.Repo(),
 		Constraints:  c.constraints,
 		Dependencies: deps,
-		Platform: &data.CarPlatform{
-			OS:        osName,
-			OSVersion: osVer,
-			Arch:      arch,
-		},
+		StoreDir:     filepath.Dir(path),
+		Platform:     platform,
+	}
+
+	if pkg.Output() != "" {
+		ci.Output = pkg.Output()
+		ci.Main = pkg.Main().ID()
+	}
+
+	return c.writeCar(pkg, ci, path, filepath.Join(dest, pkg.ID()+".car"))
+}
+
+// ExportDebug writes a car of the debug info split out of pkg, which is
+// installed at pkgPath, into dest. It returns nil if the package has no
+// debug info.
+func (c *CarExport) ExportDebug(pkg *ScriptPackage, pkgPath, dest string) (*ExportedCar, error) {
+	id := DebugID(pkg.ID())
+
+	path := filepath.Join(filepath.Dir(pkgPath), id)
+
+	if _, err := os.Stat(path); err != nil {
+		return nil, nil
+	}
+
+	platform, err := carPlatform(path)
+	if err != nil {
+		return nil, err
 	}
 
-	carPath := filepath.Join(dest, pkg.ID()+".car")
+	ci := &data.CarInfo{
+		ID:          id,
+		Name:        pkg.Name(),
+		Version:     pkg.Version(),
+		Repo:        pkg.Repo(),
+		Constraints: c.constraints,
+		StoreDir:    filepath.Dir(path),
+		Platform:    platform,
+	}
 
+	return c.writeCar(pkg, ci, path, filepath.Join(dest, id+".car"))
+}
+
+// writeCar packs the files at path into a car at carPath.
+func (c *CarExport) writeCar(pkg *ScriptPackage, ci *data.CarInfo, path, carPath string) (*ExportedCar, error) {
 	f, err := os.Create(carPath)
 	if err != nil {
 		return nil, err
@@ -89,3 +127,22 @@ func (c *CarExport) Export(pkg *ScriptPackage, path, dest string) (*ExportedCar,
 
 	return exported, nil
 }
+
+// carPlatform returns the platform of the car for the package at dir,
+// including the C library versions it needs.
+func carPlatform(dir string) (*data.CarPlatform, error) {
+	osName, osVer, arch := config.Platform()
+
+	libc, err := requiredLibc(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.CarPlatform{
+		OS:        osName,
+		OSVersion: osVer,
+		Arch:      arch,
+		Glibc:     libc.Glibc,
+		Glibcxx:   libc.Glibcxx,
+	}, nil
+}
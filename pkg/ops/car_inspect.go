@@ -101,6 +101,18 @@ top:
 	fmt.Fprintf(show, "Version:\t%s\n", r.Info.Version)
 	fmt.Fprintf(show, "ID:\t%s\n", r.Info.ID)
 
+	if r.Info.StoreDir != "" {
+		fmt.Fprintf(show, "Store:\t%s\n", r.Info.StoreDir)
+	}
+
+	if p := r.Info.Platform; p != nil && p.Glibc != "" {
+		fmt.Fprintf(show, "Glibc:\t%s\n", p.Glibc)
+	}
+
+	if p := r.Info.Platform; p != nil && p.Glibcxx != "" {
+		fmt.Fprintf(show, "Glibcxx:\t%s\n", p.Glibcxx)
+	}
+
 	var deps []string
 	for _, d := range r.Info.Dependencies {
 		deps = append(deps, d.ID)
@@ -0,0 +1,123 @@
+package ops
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"lab47.dev/aperture/pkg/data"
+	"lab47.dev/aperture/pkg/rpath"
+)
+
+var ErrCarLibc = errors.New("car needs a newer C library than the host has")
+
+// libcVersions are the highest glibc and libstdc++ symbol versions, either
+// needed by the files of a package or provided by the host. Empty values
+// mean none are needed or provided.
+type libcVersions struct {
+	Glibc   string
+	Glibcxx string
+}
+
+func (l *libcVersions) add(versions []string) {
+	if v := rpath.MaxVersion(versions, "GLIBC_"); v != "" && rpath.CompareVersions(v, l.Glibc) > 0 {
+		l.Glibc = v
+	}
+
+	if v := rpath.MaxVersion(versions, "GLIBCXX_"); v != "" && rpath.CompareVersions(v, l.Glibcxx) > 0 {
+		l.Glibcxx = v
+	}
+}
+
+// requiredLibc returns the highest versions needed by the ELF files in dir.
+func requiredLibc(dir string) (*libcVersions, error) {
+	var req libcVersions
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() || !isELF(path) {
+			return nil
+		}
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		// Static executables and object files don't need anything.
+		e, err := rpath.NewEditor(b)
+		if err != nil {
+			return nil
+		}
+
+		reqs, err := e.VersionRequirements()
+		if err != nil {
+			return errors.Wrapf(err, "reading symbol versions of %s", path)
+		}
+
+		for _, versions := range reqs {
+			req.add(versions)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &req, nil
+}
+
+// hostLibc returns the highest versions defined by the C and C++ libraries
+// found in dirs.
+func hostLibc(dirs []string) *libcVersions {
+	var host libcVersions
+
+	for _, lib := range []string{"libc.so.6", "libstdc++.so.6"} {
+		path := findLibrary(lib, dirs)
+		if path == "" {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		e, err := rpath.NewEditor(b)
+		if err != nil {
+			continue
+		}
+
+		defs, err := e.VersionDefinitions()
+		if err != nil {
+			continue
+		}
+
+		host.add(defs)
+	}
+
+	return &host
+}
+
+// check returns an error if a car for platform needs newer versions than
+// l provides.
+func (l *libcVersions) check(platform *data.CarPlatform) error {
+	if platform == nil {
+		return nil
+	}
+
+	if platform.Glibc != "" && (l.Glibc == "" || rpath.CompareVersions(platform.Glibc, l.Glibc) > 0) {
+		return errors.Wrapf(ErrCarLibc, "needs glibc %s, host has %q", platform.Glibc, l.Glibc)
+	}
+
+	if platform.Glibcxx != "" && (l.Glibcxx == "" || rpath.CompareVersions(platform.Glibcxx, l.Glibcxx) > 0) {
+		return errors.Wrapf(ErrCarLibc, "needs libstdc++ %s, host has %q", platform.Glibcxx, l.Glibcxx)
+	}
+
+	return nil
+}
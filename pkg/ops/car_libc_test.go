@@ -0,0 +1,107 @@
+package ops
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"lab47.dev/aperture/pkg/data"
+)
+
+func TestCarLibc(t *testing.T) {
+	top := fixtureDir(t, "car-libc")
+
+	t.Run("finds the highest versions needed by a package", func(t *testing.T) {
+		dir := filepath.Join(top, "pkg")
+
+		copyFixture(t, "hello", filepath.Join(dir, "bin", "hello"))
+		copyFixture(t, "ver32", filepath.Join(dir, "bin", "ver32"))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README"), []byte("GLIBC_9.9"), 0644))
+
+		req, err := requiredLibc(dir)
+		require.NoError(t, err)
+
+		assert.Equal(t, &libcVersions{Glibc: "2.34"}, req)
+
+		platform, err := carPlatform(dir)
+		require.NoError(t, err)
+
+		assert.Equal(t, "2.34", platform.Glibc)
+		assert.Equal(t, "", platform.Glibcxx)
+	})
+
+	t.Run("finds the versions the host provides", func(t *testing.T) {
+		lib := filepath.Join(top, "lib")
+
+		// Defines both GLIBC_ and GLIBCXX_ versions.
+		copyFixture(t, "libver.so", filepath.Join(lib, "libc.so.6"))
+
+		host := hostLibc([]string{filepath.Join(top, "nope"), lib})
+		assert.Equal(t, &libcVersions{Glibc: "2.28", Glibcxx: "3.4.21"}, host)
+
+		assert.Equal(t, &libcVersions{}, hostLibc([]string{filepath.Join(top, "nope")}))
+	})
+
+	t.Run("rejects cars that need a newer libc", func(t *testing.T) {
+		cl := &CarLookup{host: &libcVersions{Glibc: "2.28", Glibcxx: "3.4.21"}}
+
+		for _, platform := range []*data.CarPlatform{
+			nil,
+			{},
+			{Glibc: "2.17"},
+			{Glibc: "2.28", Glibcxx: "3.4.9"},
+		} {
+			assert.NoError(t, cl.checkPlatform(&data.CarInfo{Platform: platform}))
+		}
+
+		for _, platform := range []*data.CarPlatform{
+			{Glibc: "2.34"},
+			{Glibc: "2.17", Glibcxx: "3.4.29"},
+		} {
+			assert.ErrorIs(t, cl.checkPlatform(&data.CarInfo{Platform: platform}), ErrCarLibc)
+		}
+
+		cl = &CarLookup{host: &libcVersions{}}
+		assert.ErrorIs(t, cl.checkPlatform(&data.CarInfo{Platform: &data.CarPlatform{Glibc: "2.2.5"}}), ErrCarLibc)
+	})
+	t.Run("rejects cached cars that need a newer libc", func(t *testing.T) {
+		cache := filepath.Join(top, "cars")
+		require.NoError(t, os.MkdirAll(cache, 0755))
+
+		src := filepath.Join(top, "src")
+		require.NoError(t, os.MkdirAll(src, 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(src, "README"), []byte("hello"), 0644))
+
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		cp := CarPack{PrivateKey: priv, PublicKey: pub}
+
+		for id, glibc := range map[string]string{"aaaa-old-1.0": "2.17", "bbbb-new-1.0": "2.34"} {
+			f, err := os.Create(filepath.Join(cache, id+".car"))
+			require.NoError(t, err)
+
+			info := &data.CarInfo{ID: id, Platform: &data.CarPlatform{Glibc: glibc}}
+			require.NoError(t, cp.Pack(info, src, f))
+			require.NoError(t, f.Close())
+		}
+
+		pci := &PackageCalcInstall{
+			CarCache:  []string{cache},
+			carLookup: &CarLookup{host: &libcVersions{Glibc: "2.28"}},
+		}
+
+		car, err := pci.checkCarCache("aaaa-old-1.0")
+		require.NoError(t, err)
+		assert.NotNil(t, car)
+
+		car, err = pci.checkCarCache("bbbb-new-1.0")
+		assert.ErrorIs(t, err, ErrCarLibc)
+		assert.Nil(t, car)
+	})
+}
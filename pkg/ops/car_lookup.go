@@ -12,6 +12,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
@@ -32,6 +33,10 @@ type CarReader interface {
 type CarLookup struct {
 	overrides map[string]CarReader
 	client    httpDo
+
+	// The C library versions of the host, detected on first use
+	hostOnce sync.Once
+	host     *libcVersions
 }
 
 type CarData struct {
@@ -53,6 +58,37 @@ func (r *CarData) Info() (*data.CarInfo, error) {
 }
 
 func (r *CarData) Unpack(ctx context.Context, dir string) error {
+	var reloc *CarRelocate
+
+	if r.info != nil {
+		reloc = carRelocation(r.info, dir)
+	}
+
+	// Refuse before unpacking anything if the car can't be relocated.
+	if reloc != nil {
+		err := reloc.Check()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := r.unpack(dir)
+	if err != nil {
+		return err
+	}
+
+	if reloc != nil {
+		err = reloc.Relocate(dir)
+		if err != nil {
+			os.RemoveAll(dir)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *CarData) unpack(dir string) error {
 	if r.localPath != "" {
 		var cu CarUnpack
 
@@ -79,6 +115,12 @@ func (r *CarData) Unpack(ctx context.Context, dir string) error {
 }
 
 func (c *CarLookup) Lookup(pkg *ScriptPackage) (*CarData, error) {
+	return c.LookupID(pkg, pkg.ID())
+}
+
+// LookupID looks up the car with the given id in the repo of pkg, such as
+// the car with the debug info of pkg.
+func (c *CarLookup) LookupID(pkg *ScriptPackage, id string) (*CarData, error) {
 	repo := pkg.RepoConfig()
 	if repo == nil {
 		return nil, nil
@@ -89,8 +131,6 @@ func (c *CarLookup) Lookup(pkg *ScriptPackage) (*CarData, error) {
 		return nil, err
 	}
 
-	id := pkg.ID()
-
 	target := fmt.Sprintf("%s:%s", cfg.OCIRoot, OCICarTag(id))
 
 	ref, err := name.ParseReference(target)
@@ -120,6 +160,13 @@ func (c *CarLookup) Lookup(pkg *ScriptPackage) (*CarData, error) {
 		return nil, err
 	}
 
+	// A car that needs a newer libc than the host's won't run, so it's
+	// built from source instead.
+	err = c.checkPlatform(&info)
+	if err != nil {
+		return nil, errors.Wrapf(err, "car %s", id)
+	}
+
 	img, err := desc.Image()
 	if err != nil {
 		return nil, err
@@ -131,6 +178,18 @@ func (c *CarLookup) Lookup(pkg *ScriptPackage) (*CarData, error) {
 	}, nil
 }
 
+// checkPlatform returns an error if the car described by info can't be
+// used on the host.
+func (c *CarLookup) checkPlatform(info *data.CarInfo) error {
+	c.hostOnce.Do(func() {
+		if c.host == nil {
+			c.host = hostLibc(hostLibDirs())
+		}
+	})
+
+	return c.host.check(info.Platform)
+}
+
 func (c *CarLookup) LookupByName(repo, name string) (*CarData, error) {
 	cr, ok := c.overrides[repo]
 	if ok {
@@ -0,0 +1,123 @@
+package ops
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"lab47.dev/aperture/pkg/config"
+	"lab47.dev/aperture/pkg/data"
+	"lab47.dev/aperture/pkg/rpath"
+)
+
+var ErrRelocate = errors.New("unable to relocate car")
+
+// CarRelocate rewrites the store directory a car was built in to the one
+// it's unpacked into. Text files can change size, but in binaries the paths
+// have to be replaced in place, so the new directory can't be longer than
+// the old one. Only ELF files are relocated in place, and only their null
+// terminated strings; cars with the store path in any other binary data are
+// refused.
+type CarRelocate struct {
+	From string
+	To   string
+}
+
+// carRelocation returns the relocation needed to unpack the car described
+// by info into dir, or nil if it was built for the same store. Cars that
+// don't record their store were built in the default one.
+func carRelocation(info *data.CarInfo, dir string) *CarRelocate {
+	from := info.StoreDir
+	if from == "" {
+		from = filepath.Join(config.DefaultDataDir, "store")
+	}
+
+	to := filepath.Dir(dir)
+
+	if from == to {
+		return nil
+	}
+
+	return &CarRelocate{From: from, To: to}
+}
+
+// Check returns an error if the car can't be relocated.
+func (c *CarRelocate) Check() error {
+	if len(c.To) > len(c.From) {
+		return errors.Wrapf(ErrRelocate,
+			"the store %s is longer than %s, which the car was built for. Use a store path of at most %d characters",
+			c.To, c.From, len(c.From))
+	}
+
+	return nil
+}
+
+// Relocate rewrites the files of the package unpacked at dir.
+func (c *CarRelocate) Relocate(dir string) error {
+	err := c.Check()
+	if err != nil {
+		return err
+	}
+
+	// Match whole path components so a store at /a/store doesn't match
+	// /a/store2.
+	from := c.From + "/"
+	to := c.To + "/"
+
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		if !bytes.Contains(data, []byte(from)) {
+			return nil
+		}
+
+		switch {
+		case bytes.IndexByte(data, 0) == -1:
+			// Text, so the paths can just be replaced.
+			data = bytes.Replace(data, []byte(from), []byte(to), -1)
+		case bytes.HasPrefix(data, []byte("\x7fELF")):
+			_, err = rpath.Relocate(data, from, to)
+			if errors.Is(err, rpath.ErrUnsafeString) {
+				return errors.Wrapf(ErrRelocate, "relocating rpath of %s: %s", path, err)
+			} else if err != nil {
+				return errors.Wrapf(err, "relocating rpath of %s", path)
+			}
+
+			_, err = rpath.RelocateStrings(data, from, to)
+			if err != nil {
+				return errors.Wrapf(ErrRelocate, "relocating %s: %s", path, err)
+			}
+		default:
+			// Without knowing the format, there's no telling whether the path
+			// is in a null terminated string, so it can't be padded safely.
+			return errors.Wrapf(ErrRelocate, "%s contains the store path in binary data", path)
+		}
+
+		perm := info.Mode().Perm()
+
+		err = os.Chmod(path, perm|0200)
+		if err != nil {
+			return err
+		}
+
+		err = ioutil.WriteFile(path, data, perm)
+		if err != nil {
+			return err
+		}
+
+		return os.Chmod(path, perm)
+	})
+}
@@ -0,0 +1,123 @@
+package ops
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"lab47.dev/aperture/pkg/data"
+)
+
+func TestCarRelocate(t *testing.T) {
+	top, err := ioutil.TempDir("", "car-relocate")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(top)
+
+	// The store the car was built in, long enough that the test stores are
+	// shorter.
+	oldStore := "/opt/iris/" + strings.Repeat("x", 100) + "/store"
+
+	id := "abcd-tool-1.0"
+
+	script := "#!/bin/sh\nexec " + oldStore + "/" + id + "/libexec/tool \"$@\"\n"
+	other := "#!/bin/sh\nexec " + oldStore + "2/other\n"
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	cp := CarPack{PrivateKey: priv, PublicKey: pub}
+
+	pack := func(t *testing.T, id string, files map[string]string) (*data.CarInfo, string) {
+		src := filepath.Join(top, "src-"+id)
+
+		for name, content := range files {
+			path := filepath.Join(src, name)
+
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+			require.NoError(t, ioutil.WriteFile(path, []byte(content), 0555))
+		}
+
+		carPath := filepath.Join(top, id+".car")
+
+		f, err := os.Create(carPath)
+		require.NoError(t, err)
+
+		info := &data.CarInfo{ID: id, StoreDir: oldStore}
+		require.NoError(t, cp.Pack(info, src, f))
+		require.NoError(t, f.Close())
+
+		return info, carPath
+	}
+
+	info, carPath := pack(t, id, map[string]string{
+		"bin/tool":  script,
+		"bin/other": other,
+	})
+
+	t.Run("rewrites the store path in text files", func(t *testing.T) {
+		store := filepath.Join(top, "s")
+		dir := filepath.Join(store, id)
+
+		cd := &CarData{name: id, info: info, localPath: carPath}
+		require.NoError(t, cd.Unpack(context.Background(), dir))
+
+		out, err := ioutil.ReadFile(filepath.Join(dir, "bin", "tool"))
+		require.NoError(t, err)
+
+		assert.Equal(t, "#!/bin/sh\nexec "+dir+"/libexec/tool \"$@\"\n", string(out))
+
+		st, err := os.Stat(filepath.Join(dir, "bin", "tool"))
+		require.NoError(t, err)
+
+		assert.Equal(t, os.FileMode(0555), st.Mode().Perm())
+
+		// A path that only shares a prefix with the store is left alone.
+		out, err = ioutil.ReadFile(filepath.Join(dir, "bin", "other"))
+		require.NoError(t, err)
+
+		assert.Equal(t, other, string(out))
+	})
+
+	t.Run("refuses to pad paths in unknown binary data", func(t *testing.T) {
+		blobID := "efgh-blob-1.0"
+
+		blobInfo, blobPath := pack(t, blobID, map[string]string{
+			"lib/data.bin": "\x01\x02" + oldStore + "/" + blobID + "/share\x00",
+		})
+
+		dir := filepath.Join(top, "s", blobID)
+
+		cd := &CarData{name: blobID, info: blobInfo, localPath: blobPath}
+
+		err := cd.Unpack(context.Background(), dir)
+		assert.ErrorIs(t, err, ErrRelocate)
+
+		_, err = os.Stat(dir)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("refuses to unpack into a longer store path", func(t *testing.T) {
+		store := filepath.Join(top, strings.Repeat("y", 200))
+		dir := filepath.Join(store, id)
+
+		cd := &CarData{name: id, info: info, localPath: carPath}
+
+		err := cd.Unpack(context.Background(), dir)
+		assert.ErrorIs(t, err, ErrRelocate)
+
+		_, err = os.Stat(dir)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("leaves cars for the same store alone", func(t *testing.T) {
+		assert.Nil(t, carRelocation(info, filepath.Join(oldStore, id)))
+	})
+}
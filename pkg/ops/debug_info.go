@@ -0,0 +1,73 @@
+package ops
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"lab47.dev/aperture/pkg/config"
+)
+
+var ErrNoDebugInfo = errors.New("no debug info available for package")
+
+// DebugInfoInstall installs the debug info of a package, which is split
+// out of the package when it's built, from a car.
+type DebugInfoInstall struct {
+	common
+
+	Store *config.Store
+
+	// Directories checked for debug .car files before the package's repo
+	CarCache []string
+
+	carLookup *CarLookup
+}
+
+// Install makes sure the debug info of pkg is in the store and returns its
+// path. Debuggers find the debug info of a file in the .build-id directory
+// in it.
+func (d *DebugInfoInstall) Install(ctx context.Context, pkg *ScriptPackage) (string, error) {
+	id := DebugID(pkg.ID())
+
+	path, err := d.Store.Locate(id)
+	if err == nil {
+		return path, nil
+	}
+
+	car, err := findCachedCar(d.CarCache, id)
+	if err != nil {
+		return "", err
+	}
+
+	if car == nil {
+		cl := d.carLookup
+		if cl == nil {
+			cl = &CarLookup{}
+		}
+
+		car, err = cl.LookupID(pkg, id)
+		if err != nil {
+			d.L().Debug("error attempting to lookup debug car", "error", err, "id", id)
+		}
+	}
+
+	if car == nil {
+		return "", errors.Wrapf(ErrNoDebugInfo, "%s", pkg.ID())
+	}
+
+	path = d.Store.ExpectedPath(id)
+
+	err = car.Unpack(ctx, path)
+	if err != nil {
+		return "", errors.Wrapf(err, "unpacking debug info of %s", pkg.ID())
+	}
+
+	var sf StoreFreeze
+	sf.store = d.Store
+
+	err = sf.Freeze(id)
+	if err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
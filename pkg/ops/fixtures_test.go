@@ -0,0 +1,52 @@
+package ops
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fixtureDir returns a temporary directory for the fixtures of a test,
+// removed when the test finishes.
+func fixtureDir(t *testing.T, prefix string) string {
+	dir, err := ioutil.TempDir("", prefix)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	return dir
+}
+
+// copyFixture copies the ELF file name from the fixtures of the rpath
+// tests to dest.
+func copyFixture(t *testing.T, name, dest string) {
+	data, err := ioutil.ReadFile(filepath.Join("..", "rpath", "testdata", name))
+	require.NoError(t, err)
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(dest), 0755))
+	require.NoError(t, ioutil.WriteFile(dest, data, 0755))
+}
+
+// writeScripts creates a script repo in dir containing scripts, which maps
+// file names to their contents.
+func writeScripts(t *testing.T, dir string, scripts map[string]string) {
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".repo-info.json"), []byte(`{"Id": "test"}`), 0644))
+
+	for file, script := range scripts {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, file), []byte(script), 0644))
+	}
+}
+
+// loadScript loads the package name from the script repo in dir.
+func loadScript(dir, name string) (*ScriptPackage, error) {
+	var sl ScriptLoad
+	sl.lookup = &ScriptLookup{Path: []string{dir}}
+
+	return sl.Load(name)
+}
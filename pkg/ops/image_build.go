@@ -0,0 +1,447 @@
+package ops
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/pkg/errors"
+	"lab47.dev/aperture/pkg/config"
+	"lab47.dev/aperture/pkg/data"
+	"lab47.dev/aperture/pkg/profile"
+)
+
+// ImageProfile is where the profile of the project is placed in images.
+const ImageProfile = "/iris/profile"
+
+// ImageBuild creates a container image containing the runtime closure of
+// the packages in a profile. Each store entry gets its own layer, so images
+// that share packages share layers.
+type ImageBuild struct {
+	Store *config.Store
+
+	// Where the layers of store entries are cached, by id
+	LayerCache string
+
+	// Directories to look for cars in. The contents of a car are reused for
+	// the layer of its package, otherwise the store entry is packed as a car.
+	CarCache []string
+
+	// Used to sign the cars packed from store entries
+	PrivateKey ed25519.PrivateKey
+
+	// The ids of the store entries in the image, in layer order
+	Closure []string
+}
+
+// Build returns the image for prof.
+func (b *ImageBuild) Build(prof *profile.Profile) (v1.Image, error) {
+	pkgs, err := prof.Packages()
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+
+	for id := range pkgs {
+		ids = append(ids, id)
+	}
+
+	b.Closure, err = b.closure(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var layers []v1.Layer
+
+	for _, id := range b.Closure {
+		layer, err := b.storeLayer(id)
+		if err != nil {
+			return nil, errors.Wrapf(err, "creating layer for %s", id)
+		}
+
+		layers = append(layers, layer)
+	}
+
+	layer, err := profileLayer(prof.Path())
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating profile layer")
+	}
+
+	layers = append(layers, layer)
+
+	img, err := mutate.AppendLayers(empty.Image, layers...)
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := prof.PackageEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	env.Paths["PATH"] = append([]string{ImageProfile + "/bin"}, env.Paths["PATH"]...)
+
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg = cfg.DeepCopy()
+	cfg.OS = runtime.GOOS
+	cfg.Architecture = runtime.GOARCH
+
+	for k, v := range env.Updates(map[string]string{"PATH": profile.SystemPath}) {
+		cfg.Config.Env = append(cfg.Config.Env, k+"="+v)
+	}
+
+	sort.Strings(cfg.Config.Env)
+
+	return mutate.ConfigFile(img, cfg)
+}
+
+// closure returns ids and all their runtime dependencies, sorted so that
+// the layer order is stable.
+func (b *ImageBuild) closure(ids []string) ([]string, error) {
+	seen := map[string]struct{}{}
+
+	for len(ids) > 0 {
+		id := ids[0]
+		ids = ids[1:]
+
+		if _, ok := seen[id]; ok {
+			continue
+		}
+
+		seen[id] = struct{}{}
+
+		dir, err := b.Store.Locate(id)
+		if err != nil {
+			return nil, err
+		}
+
+		f, err := os.Open(filepath.Join(dir, ".pkg-info.json"))
+		if err != nil {
+			return nil, err
+		}
+
+		var pi data.PackageInfo
+		err = json.NewDecoder(f).Decode(&pi)
+
+		f.Close()
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading package info of %s", id)
+		}
+
+		ids = append(ids, pi.RuntimeDeps...)
+	}
+
+	var closure []string
+
+	for id := range seen {
+		closure = append(closure, id)
+	}
+
+	sort.Strings(closure)
+
+	return closure, nil
+}
+
+// storeLayer returns the layer for the store entry id, creating and
+// caching it if need be.
+func (b *ImageBuild) storeLayer(id string) (v1.Layer, error) {
+	path := filepath.Join(b.LayerCache, id+".tar.gz")
+
+	if _, err := os.Stat(path); err == nil {
+		return tarball.LayerFromFile(path)
+	}
+
+	dir, err := b.Store.Locate(id)
+	if err != nil {
+		return nil, err
+	}
+
+	car, err := b.openCar(id, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	defer car.Close()
+
+	err = os.MkdirAll(b.LayerCache, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := ioutil.TempFile(b.LayerCache, id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+
+	err = carLayer(car, dir, gz)
+	if err != nil {
+		return nil, err
+	}
+
+	err = gz.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return nil, err
+	}
+
+	return tarball.LayerFromFile(path)
+}
+
+// openCar returns the car of id from the car cache, or the car of the
+// store entry at dir if there is none.
+func (b *ImageBuild) openCar(id, dir string) (io.ReadCloser, error) {
+	for _, root := range b.CarCache {
+		f, err := os.Open(filepath.Join(root, id+".car"))
+		if err == nil {
+			return f, nil
+		}
+	}
+
+	if b.PrivateKey == nil {
+		return nil, errors.Wrapf(NoCarData, "no car of %s and no key to create one", id)
+	}
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		cp := CarPack{
+			PrivateKey: b.PrivateKey,
+			PublicKey:  b.PrivateKey.Public().(ed25519.PublicKey),
+		}
+
+		pw.CloseWithError(cp.Pack(&data.CarInfo{ID: id}, dir, pw))
+	}()
+
+	return pr, nil
+}
+
+// imageHeader returns a tar header with the fields that vary between
+// machines cleared, so that layers are reproducible.
+func imageHeader(name string, typ byte, mode int64) *tar.Header {
+	return &tar.Header{
+		Name:     name,
+		Typeflag: typ,
+		Mode:     mode,
+		ModTime:  time.Time{},
+		Format:   tar.FormatPAX,
+	}
+}
+
+// parentDirs writes entries for the directories containing name that
+// haven't been written yet.
+func parentDirs(tw *tar.Writer, name string, seen map[string]struct{}) error {
+	var dirs []string
+
+	for dir := filepath.Dir(name); dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+		if _, ok := seen[dir]; ok {
+			break
+		}
+
+		seen[dir] = struct{}{}
+		dirs = append(dirs, dir)
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		err := tw.WriteHeader(imageHeader(dirs[i]+"/", tar.TypeDir, 0755))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// carLayer writes the contents of car to w as a layer, placing them at
+// dir. The car's metadata is left out.
+func carLayer(car io.Reader, dir string, w io.Writer) error {
+	gz, err := gzip.NewReader(car)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(gz)
+	tw := tar.NewWriter(w)
+
+	prefix := strings.TrimPrefix(dir, "/")
+
+	seen := map[string]struct{}{}
+
+	err = parentDirs(tw, filepath.Join(prefix, "x"), seen)
+	if err != nil {
+		return err
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			return err
+		}
+
+		if hdr.Name == CarInfoJson || hdr.Name == SignatureEntry {
+			continue
+		}
+
+		hdr.Name = filepath.Join(prefix, hdr.Name)
+
+		err = parentDirs(tw, hdr.Name, seen)
+		if err != nil {
+			return err
+		}
+
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(tw, tr)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// imageLinkTarget returns the target in the image of the symlink at path,
+// within the profile at dir, that points to target. Store entries are at
+// the same path in the image, so links to them are made absolute. Links
+// within the profile are made relative, since it's at ImageProfile instead.
+func imageLinkTarget(dir, path, target string) string {
+	abs := target
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(filepath.Dir(path), target)
+	}
+
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return abs
+	}
+
+	rel, err = filepath.Rel(filepath.Dir(path), abs)
+	if err != nil {
+		return abs
+	}
+
+	return rel
+}
+
+// profileLayer returns a layer that places the profile at dir in
+// ImageProfile. The profile links to packages relative to its location, so
+// the links are made absolute to point to the store entries in the image.
+func profileLayer(dir string) (v1.Layer, error) {
+	var buf bytes.Buffer
+
+	tw := tar.NewWriter(&buf)
+
+	seen := map[string]struct{}{}
+
+	prefix := strings.TrimPrefix(ImageProfile, "/")
+
+	err := parentDirs(tw, filepath.Join(prefix, "x"), seen)
+	if err != nil {
+		return nil, err
+	}
+
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		if rel == "." {
+			return nil
+		}
+
+		name := filepath.Join(prefix, rel)
+
+		switch {
+		case info.IsDir():
+			return tw.WriteHeader(imageHeader(name+"/", tar.TypeDir, 0755))
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+
+			hdr := imageHeader(name, tar.TypeSymlink, 0777)
+			hdr.Linkname = imageLinkTarget(dir, path, target)
+
+			return tw.WriteHeader(hdr)
+		case info.Mode().IsRegular():
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			hdr := imageHeader(name, tar.TypeReg, int64(info.Mode().Perm()))
+			hdr.Size = int64(len(data))
+
+			err = tw.WriteHeader(hdr)
+			if err != nil {
+				return err
+			}
+
+			_, err = tw.Write(data)
+			return err
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	layer := buf.Bytes()
+
+	return tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(layer)), nil
+	})
+}
@@ -0,0 +1,249 @@
+package ops
+
+import (
+	"archive/tar"
+	"crypto/ed25519"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"lab47.dev/aperture/pkg/config"
+	"lab47.dev/aperture/pkg/data"
+	"lab47.dev/aperture/pkg/profile"
+)
+
+func TestImageBuild(t *testing.T) {
+	top, err := ioutil.TempDir("", "image-build")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(top)
+
+	store := filepath.Join(top, "store")
+
+	addPkg := func(id string, files map[string]string, pi *data.PackageInfo) string {
+		root := filepath.Join(store, id)
+
+		for path, content := range files {
+			path = filepath.Join(root, path)
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+			require.NoError(t, ioutil.WriteFile(path, []byte(content), 0755))
+		}
+
+		pi.Id = id
+
+		buf, err := json.Marshal(pi)
+		require.NoError(t, err)
+
+		require.NoError(t, ioutil.WriteFile(filepath.Join(root, ".pkg-info.json"), buf, 0644))
+
+		return root
+	}
+
+	addPkg("b-lib-1.0", map[string]string{"lib/libb.so": "lib"}, &data.PackageInfo{})
+
+	toolRoot := addPkg("a-tool-1.0", map[string]string{"bin/tool": "#!/bin/sh\n"}, &data.PackageInfo{
+		RuntimeDeps: []string{"b-lib-1.0"},
+		Environment: []*data.PackageEnv{
+			{Name: "TOOL_HOME", Value: "$prefix"},
+		},
+	})
+
+	cfg := &config.Config{DataDir: top}
+
+	prof, err := profile.OpenProfile(cfg, filepath.Join(top, "profile"))
+	require.NoError(t, err)
+
+	require.NoError(t, prof.Link("a-tool-1.0", toolRoot))
+	require.NoError(t, prof.Commit())
+
+	_, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	newBuild := func(name string) *ImageBuild {
+		return &ImageBuild{
+			Store:      &config.Store{Paths: []string{store}, Default: store},
+			LayerCache: filepath.Join(top, name),
+			PrivateKey: priv,
+		}
+	}
+
+	// layerFiles returns the entries of layer, with the link target of
+	// symlinks.
+	layerFiles := func(t *testing.T, layer v1.Layer) map[string]string {
+		r, err := layer.Uncompressed()
+		require.NoError(t, err)
+
+		defer r.Close()
+
+		files := map[string]string{}
+
+		tr := tar.NewReader(r)
+
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+
+			require.NoError(t, err)
+
+			files[hdr.Name] = hdr.Linkname
+		}
+
+		return files
+	}
+
+	t.Run("creates a layer per store entry and one for the profile", func(t *testing.T) {
+		ib := newBuild("layers")
+
+		img, err := ib.Build(prof)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"a-tool-1.0", "b-lib-1.0"}, ib.Closure)
+
+		layers, err := img.Layers()
+		require.NoError(t, err)
+
+		require.Len(t, layers, 3)
+
+		storeDir := strings.TrimPrefix(store, "/")
+
+		assert.Contains(t, layerFiles(t, layers[0]), filepath.Join(storeDir, "a-tool-1.0", "bin", "tool"))
+		assert.Contains(t, layerFiles(t, layers[1]), filepath.Join(storeDir, "b-lib-1.0", "lib", "libb.so"))
+		assert.NotContains(t, layerFiles(t, layers[1]), filepath.Join(storeDir, "b-lib-1.0", CarInfoJson))
+
+		// The only package providing bin, so the directory itself is linked.
+		pfiles := layerFiles(t, layers[2])
+		assert.Equal(t, filepath.Join(toolRoot, "bin"), pfiles["iris/profile/bin"])
+
+		cf, err := img.ConfigFile()
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{
+			"PATH=" + ImageProfile + "/bin:" + profile.SystemPath,
+			"TOOL_HOME=" + toolRoot,
+		}, cf.Config.Env)
+	})
+
+	t.Run("reuses cached layers and cars", func(t *testing.T) {
+		ib := newBuild("cache")
+
+		img, err := ib.Build(prof)
+		require.NoError(t, err)
+
+		_, err = os.Stat(filepath.Join(top, "cache", "b-lib-1.0.tar.gz"))
+		require.NoError(t, err)
+
+		img2, err := ib.Build(prof)
+		require.NoError(t, err)
+
+		d1, err := img.Digest()
+		require.NoError(t, err)
+
+		d2, err := img2.Digest()
+		require.NoError(t, err)
+
+		assert.Equal(t, d1, d2)
+
+		// A car of the package gives the same layer as packing the store
+		// entry.
+		cars := filepath.Join(top, "cars")
+		require.NoError(t, os.MkdirAll(cars, 0755))
+
+		f, err := os.Create(filepath.Join(cars, "b-lib-1.0.car"))
+		require.NoError(t, err)
+
+		cp := CarPack{PrivateKey: priv}
+		require.NoError(t, cp.Pack(&data.CarInfo{ID: "b-lib-1.0", Name: "lib"}, filepath.Join(store, "b-lib-1.0"), f))
+		require.NoError(t, f.Close())
+
+		ib3 := newBuild("cars-cache")
+		ib3.CarCache = []string{cars}
+
+		img3, err := ib3.Build(prof)
+		require.NoError(t, err)
+
+		d3, err := img3.Digest()
+		require.NoError(t, err)
+
+		assert.Equal(t, d1, d3)
+	})
+
+	t.Run("keeps profile symlinks working in the image", func(t *testing.T) {
+		dir := filepath.Join(top, "links")
+
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "bin"), 0755))
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "lib"), 0755))
+
+		libb := filepath.Join(store, "b-lib-1.0", "lib", "libb.so")
+
+		require.NoError(t, os.Symlink(filepath.Join(toolRoot, "bin", "tool"), filepath.Join(dir, "bin", "tool")))
+		require.NoError(t, os.Symlink("tool", filepath.Join(dir, "bin", "t")))
+		require.NoError(t, os.Symlink(filepath.Join(dir, "bin", "tool"), filepath.Join(dir, "lib", "tool")))
+
+		rel, err := filepath.Rel(filepath.Join(dir, "lib"), libb)
+		require.NoError(t, err)
+
+		require.NoError(t, os.Symlink(rel, filepath.Join(dir, "lib", "libb.so")))
+
+		layer, err := profileLayer(dir)
+		require.NoError(t, err)
+
+		files := layerFiles(t, layer)
+
+		assert.Equal(t, filepath.Join(toolRoot, "bin", "tool"), files["iris/profile/bin/tool"])
+		assert.Equal(t, "tool", files["iris/profile/bin/t"])
+		assert.Equal(t, "../bin/tool", files["iris/profile/lib/tool"])
+		assert.Equal(t, libb, files["iris/profile/lib/libb.so"])
+	})
+
+	t.Run("writes a tarball and pushes to a registry", func(t *testing.T) {
+		img, err := newBuild("output").Build(prof)
+		require.NoError(t, err)
+
+		digest, err := img.Digest()
+		require.NoError(t, err)
+
+		ref, err := name.ParseReference("test/tool:latest")
+		require.NoError(t, err)
+
+		path := filepath.Join(top, "image.tar")
+		require.NoError(t, tarball.WriteToFile(path, ref, img))
+
+		loaded, err := tarball.ImageFromPath(path, nil)
+		require.NoError(t, err)
+
+		layers, err := loaded.Layers()
+		require.NoError(t, err)
+		assert.Len(t, layers, 3)
+
+		s := httptest.NewServer(registry.New(registry.Logger(log.New(ioutil.Discard, "", 0))))
+		defer s.Close()
+
+		ref, err = name.ParseReference(strings.TrimPrefix(s.URL, "http://") + "/test/tool:latest")
+		require.NoError(t, err)
+
+		require.NoError(t, remote.Write(ref, img))
+
+		pulled, err := remote.Image(ref)
+		require.NoError(t, err)
+
+		pd, err := pulled.Digest()
+		require.NoError(t, err)
+
+		assert.Equal(t, digest, pd)
+	})
+}
@@ -9,10 +9,21 @@ type InstallEnv struct {
 	// Directory that contains installed packages
 	Store *config.Store
 
+	// Directory to keep per-package build logs in. If empty, no logs
+	// are written.
+	LogDir string
+
 	// Directory that packages can use to store data such as gems, config files,
 	// etc.
 	StateDir string
 
+	// Additional environment variables to set when running scripts
+	ExtraEnv []string
+
+	// The number of jobs build tools run in parallel. If zero, the number
+	// of CPUs is used.
+	Jobs int
+
 	// Start a shell
 	StartShell bool
 
@@ -32,6 +43,29 @@ type InstallEnv struct {
 	// installing a .car and allow the package to adjust it into place.
 	OnlyPostInstall bool
 
+	// RunCheck indicates that the check function of packages being built
+	// should be run after install. A failing check fails the install, so
+	// no car is exported for it.
+	RunCheck bool
+
+	// StrictLinks fails the build of a package whose files need shared
+	// libraries that are unresolved or come from outside the package and
+	// its dependencies.
+	StrictLinks bool
+
+	// Libraries the link audit allows from the host, in addition to
+	// SystemLibraries.
+	AllowLibraries []string
+
+	// StrictRepro fails compiles that use __DATE__, __TIME__ or
+	// __TIMESTAMP__, which make a package differ each time it's built.
+	StrictRepro bool
+
+	// StrictPkgConfig fails the build of a package whose pkg-config files
+	// require packages that aren't among its dependencies or point into
+	// the build directory.
+	StrictPkgConfig bool
+
 	// If set, install will generate a .car file for the packages install into
 	// ExportPath. It performs the export before running post_install so the packages
 	// are sealed properly.
@@ -107,7 +107,7 @@ func (p *PackageCalcInstall) isInstalled(id string) (string, error) {
 // runtimeDeps returns the packages that the given package needs at runtime. If pkg
 // is not installed, then we return all dependencies.
 func (p *PackageCalcInstall) runtimeDeps(pkg *ScriptPackage) ([]*ScriptPackage, error) {
-	runtimeDeps := pkg.Dependencies()
+	runtimeDeps := append(pkg.Dependencies(), pkg.siblings()...)
 
 	installPath, err := p.isInstalled(pkg.ID())
 	if err != nil || installPath == "" {
@@ -360,6 +360,17 @@ func (p *PackageCalcInstall) calcSet(
 		}
 
 		for _, d := range deps {
+			// Packages from the same build are installed together, so they
+			// are part of the set but don't impose an install order.
+			if sameBuild(pkg, d) {
+				if _, ok := seen[d.ID()]; !ok {
+					seen[d.ID()] = 0
+					toProcess = append(toProcess, d)
+				}
+
+				continue
+			}
+
 			pti.Dependencies[pkg.ID()] = append(pti.Dependencies[pkg.ID()], d.ID())
 
 			if _, ok := seen[d.ID()]; ok {
@@ -377,7 +388,30 @@ func (p *PackageCalcInstall) calcSet(
 }
 
 func (p *PackageCalcInstall) checkCarCache(id string) (*CarData, error) {
-	for _, root := range p.CarCache {
+	car, err := findCachedCar(p.CarCache, id)
+	if err != nil || car == nil {
+		return nil, err
+	}
+
+	// Cached cars are held to the same libc requirements as the ones
+	// looked up remotely.
+	cl := p.carLookup
+	if cl == nil {
+		cl = &CarLookup{}
+	}
+
+	err = cl.checkPlatform(car.info)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cached car %s", id)
+	}
+
+	return car, nil
+}
+
+// findCachedCar returns the car for id in one of the roots, or nil if none
+// of them have it.
+func findCachedCar(roots []string, id string) (*CarData, error) {
+	for _, root := range roots {
 		path := filepath.Join(root, id+".car")
 		f, err := os.Open(path)
 		if err != nil {
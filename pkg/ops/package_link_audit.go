@@ -0,0 +1,244 @@
+package ops
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+	"lab47.dev/aperture/pkg/config"
+	"lab47.dev/aperture/pkg/data"
+	"lab47.dev/aperture/pkg/rpath"
+)
+
+var ErrLinkAudit = errors.New("package links against unresolved or undeclared libraries")
+
+// SystemLibraries are the libraries packages may always link against from
+// the host, because they come with the C library and compiler.
+var SystemLibraries = []string{
+	"ld-linux-aarch64.so.1",
+	"ld-linux-x86-64.so.2",
+	"ld-linux.so.2",
+	"libanl.so.1",
+	"libc.so.6",
+	"libcrypt.so.1",
+	"libdl.so.2",
+	"libgcc_s.so.1",
+	"libm.so.6",
+	"libnsl.so.1",
+	"libpthread.so.0",
+	"libresolv.so.2",
+	"librt.so.1",
+	"libstdc++.so.6",
+	"libutil.so.1",
+}
+
+// The directories the dynamic linker searches when a library isn't found
+// in the RUNPATH.
+var systemLibDirs = []string{
+	"/lib",
+	"/lib64",
+	"/usr/lib",
+	"/usr/lib64",
+	"/usr/local/lib",
+}
+
+var multiarchTriples = map[string]string{
+	"386":   "i386-linux-gnu",
+	"amd64": "x86_64-linux-gnu",
+	"arm64": "aarch64-linux-gnu",
+}
+
+// hostLibDirs returns the directories the host's libraries are in.
+func hostLibDirs() []string {
+	dirs := append([]string(nil), systemLibDirs...)
+
+	if triple, ok := multiarchTriples[runtime.GOARCH]; ok {
+		dirs = append(dirs, "/lib/"+triple, "/usr/lib/"+triple)
+	}
+
+	return dirs
+}
+
+// PackageLinkAudit checks that every library needed by the ELF files of a
+// package resolves, through the file's RUNPATH, to the package itself, one
+// of its dependencies, or one of the allowed system libraries.
+type PackageLinkAudit struct {
+	common
+
+	store *config.Store
+
+	// Libraries allowed to come from the host in addition to SystemLibraries
+	Allow []string
+
+	// The directories searched after the RUNPATH. Defaults to the host's.
+	systemDirs []string
+}
+
+// Audit checks the package pkg installed at dir.
+func (p *PackageLinkAudit) Audit(pkg *ScriptPackage, dir string) (*data.LinkAudit, error) {
+	var d ScriptCalcDeps
+	d.store = p.store
+
+	deps, err := d.BuildDeps(pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	var depDirs []string
+
+	for _, dep := range append(deps, pkg.siblings()...) {
+		path, err := p.store.Locate(dep.ID())
+		if err != nil {
+			continue
+		}
+
+		depDirs = append(depDirs, path)
+	}
+
+	return p.AuditDir(dir, depDirs)
+}
+
+// AuditDir checks the files in dir, allowing libraries from the package
+// directories in deps.
+func (p *PackageLinkAudit) AuditDir(dir string, deps []string) (*data.LinkAudit, error) {
+	allowed := map[string]bool{}
+
+	for _, lib := range SystemLibraries {
+		allowed[lib] = true
+	}
+
+	for _, lib := range p.Allow {
+		allowed[lib] = true
+	}
+
+	sysDirs := p.systemDirs
+	if sysDirs == nil {
+		sysDirs = hostLibDirs()
+	}
+
+	declared := append([]string{dir}, deps...)
+
+	var audit data.LinkAudit
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() || !isELF(path) {
+			return nil
+		}
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		e, err := rpath.NewEditor(b)
+		if err != nil {
+			if !errors.Is(err, rpath.ErrNoDynamic) {
+				p.L().Debug("unable to read ELF file for link audit", "path", path, "error", err)
+			}
+
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		var search []string
+
+		for _, ent := range e.RunPath() {
+			ent = strings.Replace(ent, "${ORIGIN}", filepath.Dir(path), -1)
+			ent = strings.Replace(ent, "$ORIGIN", filepath.Dir(path), -1)
+			search = append(search, ent)
+		}
+
+		for _, lib := range e.Needed() {
+			if allowed[lib] {
+				continue
+			}
+
+			found := findLibrary(lib, search)
+			if found == "" {
+				found = findLibrary(lib, sysDirs)
+			}
+
+			prob := &data.LinkProblem{
+				File:    rel,
+				Library: lib,
+				Path:    found,
+			}
+
+			switch {
+			case found == "":
+				audit.Unresolved = append(audit.Unresolved, prob)
+			case !underAny(found, declared):
+				audit.Undeclared = append(audit.Undeclared, prob)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "auditing libraries in %s", dir)
+	}
+
+	return &audit, nil
+}
+
+// isELF returns true if the file at path starts with the ELF magic.
+func isELF(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+
+	defer f.Close()
+
+	var magic [4]byte
+
+	if _, err := f.ReadAt(magic[:], 0); err != nil {
+		return false
+	}
+
+	return string(magic[:]) == "\x7fELF"
+}
+
+// findLibrary returns the path lib is found at, searching dirs in order the
+// way the dynamic linker does. Libraries named with a path aren't searched
+// for.
+func findLibrary(lib string, dirs []string) string {
+	if strings.Contains(lib, "/") {
+		if _, err := os.Stat(lib); err == nil {
+			return lib
+		}
+
+		return ""
+	}
+
+	for _, dir := range dirs {
+		path := filepath.Join(dir, lib)
+
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
+
+func underAny(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if path == dir || strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,119 @@
+package ops
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"lab47.dev/aperture/pkg/data"
+	"lab47.dev/aperture/pkg/rpath"
+)
+
+func TestPackageLinkAudit(t *testing.T) {
+	top := fixtureDir(t, "link-audit")
+
+	// prog32 needs libfix32.so, and libc.so.6 is needed by hello.
+	setup := func(t *testing.T, name string, runpath ...string) string {
+		dir, err := ioutil.TempDir(top, "pkg")
+		require.NoError(t, err)
+
+		copyFixture(t, name, filepath.Join(dir, "bin", name))
+
+		if runpath != nil {
+			err = rpath.EditFile(filepath.Join(dir, "bin", name), func(e *rpath.Editor) error {
+				e.SetRunPath(runpath)
+				return nil
+			})
+			require.NoError(t, err)
+		}
+
+		return dir
+	}
+
+	sysDir := filepath.Join(top, "usr", "lib")
+	require.NoError(t, os.MkdirAll(sysDir, 0755))
+
+	depDir := filepath.Join(top, "abcd-fix-1.0")
+	copyFixture(t, "libfix32.so", filepath.Join(depDir, "lib", "libfix32.so"))
+
+	t.Run("allows system libraries", func(t *testing.T) {
+		dir := setup(t, "hello")
+
+		pla := PackageLinkAudit{systemDirs: []string{sysDir}}
+
+		audit, err := pla.AuditDir(dir, nil)
+		require.NoError(t, err)
+
+		assert.True(t, audit.Clean())
+	})
+
+	t.Run("reports unresolved libraries", func(t *testing.T) {
+		dir := setup(t, "prog32")
+
+		pla := PackageLinkAudit{systemDirs: []string{sysDir}}
+
+		audit, err := pla.AuditDir(dir, nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, []*data.LinkProblem{
+			{File: "bin/prog32", Library: "libfix32.so"},
+		}, audit.Unresolved)
+		assert.Empty(t, audit.Undeclared)
+	})
+
+	t.Run("resolves through the runpath to dependencies and the package", func(t *testing.T) {
+		dir := setup(t, "prog32", filepath.Join(depDir, "lib"))
+
+		pla := PackageLinkAudit{systemDirs: []string{sysDir}}
+
+		audit, err := pla.AuditDir(dir, []string{depDir})
+		require.NoError(t, err)
+
+		assert.True(t, audit.Clean())
+
+		dir = setup(t, "prog32", "$ORIGIN/../lib")
+		copyFixture(t, "libfix32.so", filepath.Join(dir, "lib", "libfix32.so"))
+
+		audit, err = pla.AuditDir(dir, nil)
+		require.NoError(t, err)
+
+		assert.True(t, audit.Clean())
+	})
+
+	t.Run("reports libraries from undeclared paths", func(t *testing.T) {
+		dir := setup(t, "prog32", filepath.Join(depDir, "lib"))
+
+		pla := PackageLinkAudit{systemDirs: []string{sysDir}}
+
+		audit, err := pla.AuditDir(dir, nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, []*data.LinkProblem{
+			{File: "bin/prog32", Library: "libfix32.so", Path: filepath.Join(depDir, "lib", "libfix32.so")},
+		}, audit.Undeclared)
+
+		host := filepath.Join(sysDir, "libfix32.so")
+		copyFixture(t, "libfix32.so", host)
+
+		defer os.Remove(host)
+
+		dir = setup(t, "prog32")
+
+		audit, err = pla.AuditDir(dir, nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, []*data.LinkProblem{
+			{File: "bin/prog32", Library: "libfix32.so", Path: host},
+		}, audit.Undeclared)
+
+		pla.Allow = []string{"libfix32.so"}
+
+		audit, err = pla.AuditDir(dir, nil)
+		require.NoError(t, err)
+
+		assert.True(t, audit.Clean())
+	})
+}
@@ -0,0 +1,196 @@
+package ops
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"lab47.dev/aperture/pkg/config"
+	"lab47.dev/aperture/pkg/data"
+	"lab47.dev/aperture/pkg/pkgconfig"
+)
+
+var ErrPkgConfigCheck = errors.New("package has invalid pkg-config files")
+
+// PackagePkgConfigCheck checks the pkg-config files of a package: every
+// package they require has to be provided by the package or its
+// dependencies, and the paths in them have to point into the store.
+type PackagePkgConfigCheck struct {
+	common
+
+	store *config.Store
+
+	// The directory packages are built in. Paths into it are errors.
+	buildDir string
+}
+
+// Check checks the package pkg installed at dir. It returns nil if the
+// package has no pkg-config files.
+func (p *PackagePkgConfigCheck) Check(pkg *ScriptPackage, dir string) (*data.PkgConfigCheck, error) {
+	var d ScriptCalcDeps
+	d.store = p.store
+
+	deps, err := d.BuildDeps(pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	var depDirs []string
+
+	for _, dep := range append(deps, pkg.siblings()...) {
+		path, err := p.store.Locate(dep.ID())
+		if err != nil {
+			continue
+		}
+
+		depDirs = append(depDirs, path)
+	}
+
+	return p.CheckDir(dir, depDirs)
+}
+
+// CheckDir checks the pkg-config files in dir, resolving the packages they
+// require in dir and the package directories in deps.
+func (p *PackagePkgConfigCheck) CheckDir(dir string, deps []string) (*data.PkgConfigCheck, error) {
+	configs, err := pkgconfig.LoadAll(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "loading pkg-config files in %s", dir)
+	}
+
+	if len(configs) == 0 {
+		return nil, nil
+	}
+
+	var search []string
+
+	for _, root := range append([]string{dir}, deps...) {
+		search = append(search,
+			filepath.Join(root, "lib", "pkgconfig"),
+			filepath.Join(root, "share", "pkgconfig"),
+		)
+	}
+
+	r := &pkgconfig.Resolver{Path: search}
+
+	var stored []string
+
+	if p.store != nil {
+		stored = p.store.Paths
+	}
+
+	stored = append([]string{dir}, stored...)
+
+	var check data.PkgConfigCheck
+
+	for _, cfg := range configs {
+		rel, err := filepath.Rel(dir, cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		problem := func(format string, args ...interface{}) *data.PkgConfigProblem {
+			return &data.PkgConfigProblem{File: rel, Message: fmt.Sprintf(format, args...)}
+		}
+
+		reqs, err := pkgconfig.ParseRequires(append(cfg.Requires, cfg.Private...))
+		if err != nil {
+			check.Errors = append(check.Errors, problem("invalid Requires: %s", err))
+		}
+
+		for _, req := range reqs {
+			found, err := r.Find(req.Name)
+			if err != nil {
+				check.Errors = append(check.Errors, problem("requires %s, which the package and its dependencies don't provide", req.Name))
+				continue
+			}
+
+			if !req.Satisfied(found.Version) {
+				check.Errors = append(check.Errors, problem("requires %s, but the version provided is %s", req, found.Version))
+			}
+		}
+
+		for _, ref := range pcPaths(cfg) {
+			switch {
+			case underAny(ref.path, stored):
+			case p.buildDir != "" && underAny(ref.path, []string{p.buildDir}):
+				check.Errors = append(check.Errors, problem("%s points into the build directory", ref.label))
+			default:
+				check.Warnings = append(check.Warnings, problem("%s points outside the store", ref.label))
+			}
+		}
+	}
+
+	return &check, nil
+}
+
+type pcPath struct {
+	// The variable or flag the path is in
+	label string
+	path  string
+}
+
+// pcPaths returns the absolute paths in the variables and flags of cfg.
+// Paths inside one already returned, typically ${prefix}, are skipped.
+func pcPaths(cfg *pkgconfig.Config) []pcPath {
+	var (
+		paths []pcPath
+		seen  []string
+	)
+
+	add := func(label, path string) {
+		path = filepath.Clean(path)
+
+		if underAny(path, seen) {
+			return
+		}
+
+		seen = append(seen, path)
+		paths = append(paths, pcPath{label: label, path: path})
+	}
+
+	var names []string
+
+	for name := range cfg.Variables {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := cfg.Variables[name]
+
+		if strings.HasPrefix(value, "/") {
+			add(name+"="+value, strings.Fields(value)[0])
+		}
+	}
+
+	flags := pkgconfig.SplitFlags(cfg.Cflags + " " + cfg.Libs + " " + cfg.PrivLibs)
+
+	for i, flag := range flags {
+		var path string
+
+		switch {
+		case flag == "-isystem" || flag == "-idirafter":
+			if i+1 < len(flags) {
+				path = flags[i+1]
+				flag += " " + path
+			}
+		case strings.HasPrefix(flag, "-I"), strings.HasPrefix(flag, "-L"):
+			path = flag[2:]
+		case strings.HasPrefix(flag, "-Wl,-rpath,"):
+			path = flag[len("-Wl,-rpath,"):]
+		case strings.HasPrefix(flag, "-Wl,-rpath="):
+			path = flag[len("-Wl,-rpath="):]
+		case strings.HasPrefix(flag, "/"):
+			path = flag
+		}
+
+		if strings.HasPrefix(path, "/") {
+			add(flag, path)
+		}
+	}
+
+	return paths
+}
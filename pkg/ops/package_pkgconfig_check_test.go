@@ -0,0 +1,80 @@
+package ops
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"lab47.dev/aperture/pkg/config"
+	"lab47.dev/aperture/pkg/data"
+)
+
+func TestPackagePkgConfigCheck(t *testing.T) {
+	top, err := ioutil.TempDir("", "pkgconfig-check")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(top)
+
+	storeDir := filepath.Join(top, "store")
+	buildDir := filepath.Join(top, "build")
+
+	ppc := PackagePkgConfigCheck{
+		store:    &config.Store{Paths: []string{storeDir}, Default: storeDir},
+		buildDir: buildDir,
+	}
+
+	writePC := func(t *testing.T, dir, name, content string) {
+		path := filepath.Join(dir, "lib", "pkgconfig", name+".pc")
+
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	zlib := filepath.Join(storeDir, "aaaa-zlib-1.2.11")
+	writePC(t, zlib, "zlib", "Name: zlib\nVersion: 1.2.11\n")
+
+	t.Run("accepts requirements from dependencies", func(t *testing.T) {
+		dir := filepath.Join(storeDir, "bbbb-png-1.6")
+		writePC(t, dir, "libpng", "prefix="+dir+"\nName: libpng\nVersion: 1.6\nRequires: zlib >= 1.2\nCflags: -I${prefix}/include\nLibs: -L${prefix}/lib -lpng\n")
+		writePC(t, dir, "png", "Name: png\nVersion: 1.6\nRequires: libpng\n")
+
+		check, err := ppc.CheckDir(dir, []string{zlib})
+		require.NoError(t, err)
+
+		assert.True(t, check.Clean())
+	})
+
+	t.Run("reports unresolved requirements and bad paths", func(t *testing.T) {
+		dir := filepath.Join(storeDir, "cccc-freetype-2.10")
+		writePC(t, dir, "freetype2", ""+
+			"prefix="+filepath.Join(buildDir, "tmp", "install")+"\n"+
+			"Name: freetype2\nVersion: 2.10\n"+
+			"Requires: zlib >= 1.3, harfbuzz\n"+
+			"Cflags: -I${prefix}/include -I/usr/local/include\n")
+
+		check, err := ppc.CheckDir(dir, []string{zlib})
+		require.NoError(t, err)
+
+		file := filepath.Join("lib", "pkgconfig", "freetype2.pc")
+
+		assert.Equal(t, []*data.PkgConfigProblem{
+			{File: file, Message: "requires zlib >= 1.3, but the version provided is 1.2.11"},
+			{File: file, Message: "requires harfbuzz, which the package and its dependencies don't provide"},
+			{File: file, Message: "prefix=" + filepath.Join(buildDir, "tmp", "install") + " points into the build directory"},
+		}, check.Errors)
+
+		assert.Equal(t, []*data.PkgConfigProblem{
+			{File: file, Message: "-I/usr/local/include points outside the store"},
+		}, check.Warnings)
+	})
+
+	t.Run("skips packages without pkg-config files", func(t *testing.T) {
+		check, err := ppc.CheckDir(filepath.Join(storeDir, "dddd-empty-1.0"), nil)
+		require.NoError(t, err)
+
+		assert.Nil(t, check)
+	})
+}
@@ -0,0 +1,112 @@
+package ops
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"lab47.dev/aperture/pkg/config"
+	"lab47.dev/aperture/pkg/rpath"
+)
+
+// DebugID returns the id of the store entry that holds the debug info
+// split out of the package with the given id.
+func DebugID(id string) string {
+	return id + ".debug"
+}
+
+// PackageSplitDebug moves the DWARF sections of the ELF files in a package
+// into the package's debug store entry. Debuggers find them there by build
+// id, in .build-id/xx/yyyy.debug.
+type PackageSplitDebug struct {
+	common
+
+	store *config.Store
+}
+
+// Split splits the debug info of the package id installed at dir. It
+// returns the number of files split, files without a build id keep their
+// debug info.
+func (p *PackageSplitDebug) Split(id, dir string) (int, error) {
+	debugDir := p.store.ExpectedPath(DebugID(id))
+
+	// Left frozen by a previous build of the package
+	filepath.Walk(debugDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() {
+			os.Chmod(path, 0755)
+		}
+
+		return nil
+	})
+
+	var count int
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() || !isELF(path) {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		stripped, di, err := rpath.SplitDebug(data)
+		if err != nil {
+			if !errors.Is(err, rpath.ErrNoDebugInfo) {
+				p.L().Debug("not splitting debug info", "path", path, "error", err)
+			}
+
+			return nil
+		}
+
+		target := filepath.Join(debugDir, di.Path())
+
+		err = os.MkdirAll(filepath.Dir(target), 0755)
+		if err != nil {
+			return err
+		}
+
+		// The same build id means the same debug info, so one left by a
+		// previous build is kept.
+		if _, err := os.Stat(target); err != nil {
+			err = ioutil.WriteFile(target, di.Data, 0444)
+			if err != nil {
+				return err
+			}
+		}
+
+		perm := info.Mode().Perm()
+
+		err = os.Chmod(path, perm|0200)
+		if err != nil {
+			return err
+		}
+
+		err = ioutil.WriteFile(path, stripped, perm)
+		if err != nil {
+			return err
+		}
+
+		count++
+
+		return os.Chmod(path, perm)
+	})
+	if err != nil {
+		return count, errors.Wrapf(err, "splitting debug info of %s", id)
+	}
+
+	if count == 0 {
+		return 0, nil
+	}
+
+	var sf StoreFreeze
+	sf.store = p.store
+
+	return count, sf.Freeze(DebugID(id))
+}
@@ -0,0 +1,127 @@
+package ops
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"debug/elf"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"lab47.dev/aperture/pkg/config"
+	"lab47.dev/aperture/pkg/data"
+)
+
+func TestPackageSplitDebug(t *testing.T) {
+	top, err := ioutil.TempDir("", "split-debug")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(top)
+
+	storeDir := filepath.Join(top, "store")
+	store := &config.Store{Paths: []string{storeDir}, Default: storeDir}
+
+	id := "abcd-hello-1.0"
+	dir := filepath.Join(storeDir, id)
+
+	for _, name := range []string{"hellodbg", "hello"} {
+		b, err := ioutil.ReadFile(filepath.Join("..", "rpath", "testdata", name))
+		require.NoError(t, err)
+
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "bin"), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "bin", name), b, 0555))
+	}
+
+	psd := PackageSplitDebug{store: store}
+
+	var debugFile string
+
+	t.Run("moves debug info into the debug entry", func(t *testing.T) {
+		count, err := psd.Split(id, dir)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, count)
+
+		ef, err := elf.Open(filepath.Join(dir, "bin", "hellodbg"))
+		require.NoError(t, err)
+
+		defer ef.Close()
+
+		assert.Nil(t, ef.Section(".debug_info"))
+
+		st, err := os.Stat(filepath.Join(dir, "bin", "hellodbg"))
+		require.NoError(t, err)
+
+		assert.Equal(t, os.FileMode(0555), st.Mode().Perm())
+
+		matches, err := filepath.Glob(filepath.Join(storeDir, DebugID(id), ".build-id", "*", "*.debug"))
+		require.NoError(t, err)
+		require.Len(t, matches, 1)
+
+		debugFile, err = filepath.Rel(filepath.Join(storeDir, DebugID(id)), matches[0])
+		require.NoError(t, err)
+
+		df, err := elf.Open(matches[0])
+		require.NoError(t, err)
+
+		defer df.Close()
+
+		assert.NotNil(t, df.Section(".debug_info"))
+
+		// Nothing is left to split the second time.
+		count, err = psd.Split(id, dir)
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, count)
+	})
+
+	t.Run("installs debug info from a car", func(t *testing.T) {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		cars := filepath.Join(top, "cars")
+		require.NoError(t, os.MkdirAll(cars, 0755))
+
+		var buf bytes.Buffer
+
+		cp := CarPack{PrivateKey: priv, PublicKey: pub}
+		require.NoError(t, cp.Pack(&data.CarInfo{ID: DebugID(id), StoreDir: storeDir}, filepath.Join(storeDir, DebugID(id)), &buf))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(cars, DebugID(id)+".car"), buf.Bytes(), 0644))
+
+		other := filepath.Join(top, "other")
+		require.NoError(t, os.MkdirAll(other, 0755))
+
+		di := DebugInfoInstall{
+			Store:    &config.Store{Paths: []string{other}, Default: other},
+			CarCache: []string{cars},
+		}
+
+		pkg := &ScriptPackage{id: id}
+
+		path, err := di.Install(context.Background(), pkg)
+		require.NoError(t, err)
+
+		assert.Equal(t, filepath.Join(other, DebugID(id)), path)
+
+		_, err = os.Stat(filepath.Join(path, debugFile))
+		assert.NoError(t, err)
+
+		// Without a car there's nothing to install.
+		_, err = di.Install(context.Background(), &ScriptPackage{id: "efgh-other-1.0"})
+		assert.ErrorIs(t, err, ErrNoDebugInfo)
+	})
+
+	t.Run("only exports debug cars for packages with debug info", func(t *testing.T) {
+		var ce CarExport
+
+		exported, err := ce.ExportDebug(&ScriptPackage{id: "efgh-other-1.0"}, filepath.Join(storeDir, "efgh-other-1.0"), top)
+		require.NoError(t, err)
+
+		assert.Nil(t, exported)
+	})
+}
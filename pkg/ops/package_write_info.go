@@ -12,6 +12,12 @@ import (
 
 type PackageWriteInfo struct {
 	store *config.Store
+
+	// The result of the package's link audit, if it was run
+	linkAudit *data.LinkAudit
+
+	// The result of checking the package's pkg-config files, if it was run
+	pkgConfigCheck *data.PkgConfigCheck
 }
 
 func (p *PackageWriteInfo) Write(pkg *ScriptPackage) (*data.PackageInfo, error) {
@@ -40,7 +46,10 @@ func (p *PackageWriteInfo) Write(pkg *ScriptPackage) (*data.PackageInfo, error)
 		return nil, err
 	}
 
-	runtimeDeps, err := sfd.PruneDeps(pkg.ID(), allDeps)
+	// Packages from the same build may refer to each other as well.
+	candidates := append(allDeps[:len(allDeps):len(allDeps)], pkg.siblings()...)
+
+	runtimeDeps, reasons, err := sfd.PruneDeps(pkg.ID(), candidates)
 	if err != nil {
 		return nil, errors.Wrapf(err, "unable to prune deps")
 	}
@@ -54,6 +63,7 @@ func (p *PackageWriteInfo) Write(pkg *ScriptPackage) (*data.PackageInfo, error)
 				}
 			}
 			runtimeDeps = append(runtimeDeps, pkg)
+			reasons[pkg.ID()] = &data.RuntimeDepReason{Kind: data.DepExplicit}
 		}
 	}
 
@@ -102,6 +112,23 @@ func (p *PackageWriteInfo) Write(pkg *ScriptPackage) (*data.PackageInfo, error)
 		BuildDeps:   buildDeps,
 		Constraints: pkg.Constraints(),
 		Inputs:      inputs,
+		Environment: pkg.cs.Environment,
+		LinkAudit:   p.linkAudit,
+
+		PkgConfigCheck: p.pkgConfigCheck,
+
+		RuntimeDepReasons: reasons,
+	}
+
+	if pkg.Output() != "" {
+		pi.Output = pkg.Output()
+		pi.Main = pkg.Main().ID()
+	} else if names := pkg.OutputNames(); len(names) > 0 {
+		pi.Outputs = map[string]string{}
+
+		for _, name := range names {
+			pi.Outputs[name] = pkg.OutputPackage(name).ID()
+		}
 	}
 
 	err = json.NewEncoder(f).Encode(&pi)
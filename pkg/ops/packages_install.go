@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/morikuni/aec"
@@ -64,6 +65,14 @@ func (p *PackagesInstall) Install(
 
 		ienv.PackagePaths[id] = storeDir
 
+		// Outputs of a package are created by the same build, so one of
+		// it's siblings might already have installed it.
+		if _, err := os.Stat(filepath.Join(storeDir, ".pkg-info.json")); err == nil {
+			p.L().Debug("package installed by sibling", "id", id)
+			p.Installed = append(p.Installed, id)
+			continue
+		}
+
 		fn, ok := toInstall.Installers[id]
 		if !ok {
 			continue
@@ -211,7 +211,7 @@ func (c *ProjectLoad) Load(ctx context.Context, cfg *config.Config) (*Project, e
 	var proj Project
 	proj.Constraints = c.constraints
 	proj.Cellar = homebrew.DefaultCellar()
-	proj.Install = c.toInstall
+	proj.Install = withProfileOutputs(c.toInstall)
 	proj.homebrewPackages = c.homebrewPackages
 
 	return &proj, nil
@@ -231,7 +231,7 @@ func (c *ProjectLoad) Single(ctx context.Context, cfg *config.Config, name strin
 	var proj Project
 	proj.Constraints = c.constraints
 	proj.Cellar = homebrew.DefaultCellar()
-	proj.Install = []*ScriptPackage{pkg}
+	proj.Install = withProfileOutputs([]*ScriptPackage{pkg})
 
 	return &proj, nil
 }
@@ -304,26 +304,54 @@ func (l *ProjectLoad) sourceFn(thread *exprcore.Thread, b *exprcore.Builtin, arg
 }
 
 func (l *ProjectLoad) installFn(thread *exprcore.Thread, b *exprcore.Builtin, args exprcore.Tuple, kwargs []exprcore.Tuple) (exprcore.Value, error) {
+	var priority *int
+
+	for _, item := range kwargs {
+		name, arg := item[0].(exprcore.String), item[1]
+
+		switch name {
+		case "priority":
+			p, err := exprcore.AsInt32(arg)
+			if err != nil {
+				return nil, errors.Wrapf(err, "priority must be an integer")
+			}
+
+			priority = &p
+		default:
+			// Unknown arguments have always been ignored, so only warn about
+			// them rather than failing projects that pass them.
+			l.L().Warn("install: ignoring unknown keyword argument", "name", string(name))
+		}
+	}
+
+	add := func(sp *ScriptPackage) {
+		if priority != nil {
+			sp.SetPriority(*priority)
+		}
+
+		l.toInstall = append(l.toInstall, sp)
+	}
+
 	for _, arg := range args {
 		switch i := arg.(type) {
 		case *PackageSelector:
 			l.homebrewPackages = append(l.homebrewPackages, i.Name)
 		case *ScriptPackage:
-			l.toInstall = append(l.toInstall, i)
+			add(i)
 		case exprcore.String:
 			sp, err := l.loadScript(context.Background(), "", string(i))
 			if err != nil {
 				return nil, err
 			}
 
-			l.toInstall = append(l.toInstall, sp)
+			add(sp)
 		default:
 			sp, err := ProcessPrototype(arg, l.constraints)
 			if err != nil {
 				return nil, err
 			}
 
-			l.toInstall = append(l.toInstall, sp)
+			add(sp)
 		}
 	}
 
@@ -432,6 +460,7 @@ func (l *ProjectLoad) Search(ctx context.Context, code SearchCond) ([]*data.Repo
 		var rri RepoReadIndex
 		rri.common = l.common
 		rri.path = path
+		rri.gitCache = l.cfg.GitCache()
 
 		idx, err := rri.Read()
 		if err != nil {
@@ -466,6 +495,7 @@ func (l *ProjectLoad) loadScript(ctx context.Context, ns, name string) (*ScriptP
 	}
 
 	sl.Store = l.store
+	sl.GitCache = l.cfg.GitCache()
 
 	data, err := sl.Load(name, WithConstraints(l.constraints))
 	if err != nil {
@@ -571,7 +601,12 @@ func (p *Project) Explain(ctx context.Context, ienv *InstallEnv) error {
 			continue
 		}
 
-		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", script.ID()[:8], script.Name(), script.Version(), flag, deps)
+		name := script.Name()
+		if script.Output() != "" {
+			name += "." + script.Output()
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", script.ID()[:8], name, script.Version(), flag, deps)
 	}
 
 	return nil
@@ -779,7 +814,15 @@ func (p *Project) Export(ctx context.Context, cfg *config.Config, dest string) (
 			})
 		}
 
-		osName, osVer, arch := config.Platform()
+		path, err := pri.Store.Locate(pkg.ID())
+		if err != nil {
+			return nil, err
+		}
+
+		platform, err := carPlatform(path)
+		if err != nil {
+			return nil, err
+		}
 
 		ci := &data.CarInfo{
 			ID:           pkg.ID(),
@@ -788,11 +831,13 @@ func (p *Project) Export(ctx context.Context, cfg *config.Config, dest string) (
 			Repo:         pkg.Repo(),
 			Constraints:  p.Constraints,
 			Dependencies: deps,
-			Platform: &data.CarPlatform{
-				OS:        osName,
-				OSVersion: osVer,
-				Arch:      arch,
-			},
+			StoreDir:     filepath.Dir(path),
+			Platform:     platform,
+		}
+
+		if pkg.Output() != "" {
+			ci.Output = pkg.Output()
+			ci.Main = pkg.Main().ID()
 		}
 
 		f, err := os.Create(carPath)
@@ -806,11 +851,6 @@ func (p *Project) Export(ctx context.Context, cfg *config.Config, dest string) (
 		cp.PrivateKey = cfg.Private()
 		cp.PublicKey = cfg.Public()
 
-		path, err := pri.Store.Locate(pkg.ID())
-		if err != nil {
-			return nil, err
-		}
-
 		err = cp.Pack(ci, path, f)
 		if err != nil {
 			return nil, err
@@ -5,12 +5,15 @@ import (
 	"os"
 	"path/filepath"
 
+	"lab47.dev/aperture/pkg/config"
 	"lab47.dev/aperture/pkg/data"
 )
 
 type RepoReadIndex struct {
 	common
 	path string
+
+	gitCache *config.GitCache
 }
 
 func (r *RepoReadIndex) Read() (*data.RepoIndex, error) {
@@ -21,6 +24,7 @@ func (r *RepoReadIndex) Read() (*data.RepoIndex, error) {
 
 		var rwi RepoWriteIndex
 		rwi.path = r.path
+		rwi.gitCache = r.gitCache
 
 		err = rwi.Write()
 		if err != nil {
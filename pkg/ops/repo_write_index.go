@@ -13,6 +13,8 @@ import (
 type RepoWriteIndex struct {
 	common
 	path string
+
+	gitCache *config.GitCache
 }
 
 func (r *RepoWriteIndex) Write() error {
@@ -25,6 +27,7 @@ func (r *RepoWriteIndex) Write() error {
 	}
 
 	sl.Store = &config.Store{}
+	sl.GitCache = r.gitCache
 
 	scripts, err := sl.Search("")
 	if err != nil {
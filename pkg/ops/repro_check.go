@@ -0,0 +1,406 @@
+package ops
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"lab47.dev/aperture/pkg/config"
+	"lab47.dev/aperture/pkg/rpath"
+)
+
+// ReproCheck builds a package twice, in separate stores and with a varied
+// environment, and compares the results to find anything that isn't
+// reproducible.
+type ReproCheck struct {
+	common
+
+	Config *config.Config
+
+	// Directory to perform the builds in. The stores of each build are
+	// kept here so they can be inspected.
+	Dir string
+}
+
+// ReproDiff describes one file that differed between the builds.
+type ReproDiff struct {
+	Path   string
+	Reason string
+
+	// For ELF files, the names of the sections whose contents differ.
+	Sections []string
+}
+
+type ReproReport struct {
+	ID    string
+	Dirs  [2]string
+	Diffs []*ReproDiff
+}
+
+func (r *ReproReport) Reproducible() bool {
+	return len(r.Diffs) == 0
+}
+
+// Write outputs a human readable version of the report to w.
+func (r *ReproReport) Write(w io.Writer) {
+	if r.Reproducible() {
+		fmt.Fprintf(w, "%s is reproducible\n", r.ID)
+		return
+	}
+
+	fmt.Fprintf(w, "%s is not reproducible, %d differences:\n", r.ID, len(r.Diffs))
+
+	for _, d := range r.Diffs {
+		if len(d.Sections) > 0 {
+			fmt.Fprintf(w, "  %s: %s (sections: %s)\n", d.Path, d.Reason, strings.Join(d.Sections, ", "))
+		} else {
+			fmt.Fprintf(w, "  %s: %s\n", d.Path, d.Reason)
+		}
+	}
+
+	fmt.Fprintf(w, "\nbuilds retained in:\n  %s\n  %s\n", r.Dirs[0], r.Dirs[1])
+}
+
+// The environment variations applied to each build. The run names are the
+// same length so that the store paths embedded in files can be
+// normalized without changing any file offsets.
+type reproRun struct {
+	name     string
+	buildDir string
+	umask    int
+	env      []string
+}
+
+var reproRuns = [2]reproRun{
+	{
+		name:     "run-a",
+		buildDir: "build",
+		umask:    022,
+		env:      []string{"TZ=UTC"},
+	},
+	{
+		name:     "run-b",
+		buildDir: "build-repro-check",
+		umask:    002,
+		env:      []string{"TZ=Etc/GMT-14"},
+	},
+}
+
+func (r *ReproCheck) Check(ctx context.Context, pkg *ScriptPackage) (*ReproReport, error) {
+	var stores [2]*config.Store
+
+	for i, run := range reproRuns {
+		// Be sure the wall clock has moved on so timestamps differ.
+		if i > 0 {
+			time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+		}
+
+		store, err := r.build(ctx, pkg, run)
+		if err != nil {
+			return nil, err
+		}
+
+		stores[i] = store
+	}
+
+	report := &ReproReport{ID: pkg.ID()}
+
+	for _, sp := range append([]*ScriptPackage{pkg}, pkg.siblings()...) {
+		a := stores[0].ExpectedPath(sp.ID())
+		b := stores[1].ExpectedPath(sp.ID())
+
+		diffs, err := DiffStoreDirs(a, b, stores[0].Default, stores[1].Default)
+		if err != nil {
+			return nil, err
+		}
+
+		if sp.Output() != "" {
+			for _, d := range diffs {
+				d.Path = sp.Output() + ":" + d.Path
+			}
+		}
+
+		report.Diffs = append(report.Diffs, diffs...)
+	}
+
+	report.Dirs = [2]string{
+		stores[0].ExpectedPath(pkg.ID()),
+		stores[1].ExpectedPath(pkg.ID()),
+	}
+
+	return report, nil
+}
+
+func (r *ReproCheck) build(ctx context.Context, pkg *ScriptPackage, run reproRun) (*config.Store, error) {
+	root := filepath.Join(r.Dir, run.name)
+
+	// Dependencies are used from the normal store, only pkg is built into
+	// the temporary one.
+	store := &config.Store{}
+	store.Pivot(filepath.Join(root, "store"))
+	store.Paths = append(store.Paths, r.Config.StorePath())
+
+	ienv := &InstallEnv{
+		Store:    store,
+		BuildDir: filepath.Join(root, run.buildDir),
+		LogDir:   filepath.Join(root, "logs"),
+		StateDir: filepath.Join(root, "state"),
+		Config:   r.Config,
+		ExtraEnv: run.env,
+	}
+
+	for _, dir := range []string{ienv.BuildDir, store.Default, ienv.StateDir} {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var pci PackageCalcInstall
+	pci.common = r.common
+	pci.Store = store
+	pci.carLookup = &CarLookup{}
+
+	pti, err := pci.Calculate(pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Force pkg to be built from its script, even if it's already installed
+	// or available as a car.
+	for _, sp := range append([]*ScriptPackage{pkg}, pkg.siblings()...) {
+		if _, ok := pti.Scripts[sp.ID()]; !ok {
+			continue
+		}
+
+		pti.Installed[sp.ID()] = false
+		pti.Installers[sp.ID()] = &ScriptInstall{common: r.common, pkg: sp}
+		delete(pti.InstallDirs, sp.ID())
+	}
+
+	old := unix.Umask(run.umask)
+	defer unix.Umask(old)
+
+	var pi PackagesInstall
+	pi.common = r.common
+
+	_, err = pi.Install(ctx, ienv, pti)
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// DiffStoreDirs compares the files in the store directories a and b,
+// which live in the stores storeA and storeB respectively. References to
+// storeB within b are considered equal to those of storeA in a.
+func DiffStoreDirs(a, b, storeA, storeB string) ([]*ReproDiff, error) {
+	filesA, err := listTree(a)
+	if err != nil {
+		return nil, err
+	}
+
+	filesB, err := listTree(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+
+	for name := range filesA {
+		names = append(names, name)
+	}
+
+	for name := range filesB {
+		if _, ok := filesA[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	var diffs []*ReproDiff
+
+	for _, name := range names {
+		fa, inA := filesA[name]
+		fb, inB := filesB[name]
+
+		switch {
+		case !inB:
+			diffs = append(diffs, &ReproDiff{Path: name, Reason: "only in first build"})
+			continue
+		case !inA:
+			diffs = append(diffs, &ReproDiff{Path: name, Reason: "only in second build"})
+			continue
+		case fa.Mode() != fb.Mode():
+			diffs = append(diffs, &ReproDiff{
+				Path:   name,
+				Reason: fmt.Sprintf("mode differs: %s vs %s", fa.Mode(), fb.Mode()),
+			})
+			continue
+		}
+
+		switch {
+		case fa.Mode()&os.ModeSymlink != 0:
+			ta, err := os.Readlink(filepath.Join(a, name))
+			if err != nil {
+				return nil, err
+			}
+
+			tb, err := os.Readlink(filepath.Join(b, name))
+			if err != nil {
+				return nil, err
+			}
+
+			if ta != strings.Replace(tb, storeB, storeA, -1) {
+				diffs = append(diffs, &ReproDiff{
+					Path:   name,
+					Reason: fmt.Sprintf("symlink differs: %s vs %s", ta, tb),
+				})
+			}
+		case fa.Mode().IsRegular():
+			diff, err := diffFiles(filepath.Join(a, name), filepath.Join(b, name), storeA, storeB)
+			if err != nil {
+				return nil, err
+			}
+
+			if diff != nil {
+				diff.Path = name
+				diffs = append(diffs, diff)
+			}
+		}
+	}
+
+	return diffs, nil
+}
+
+func listTree(root string) (map[string]os.FileInfo, error) {
+	files := map[string]os.FileInfo{}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path == root {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		files[rel] = info
+
+		return nil
+	})
+
+	return files, err
+}
+
+func diffFiles(a, b, storeA, storeB string) (*ReproDiff, error) {
+	da, err := ioutil.ReadFile(a)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := ioutil.ReadFile(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(storeA) == len(storeB) {
+		db = bytes.Replace(db, []byte(storeB), []byte(storeA), -1)
+	}
+
+	if bytes.Equal(da, db) {
+		return nil, nil
+	}
+
+	diff := &ReproDiff{Reason: "content differs"}
+
+	if len(da) != len(db) {
+		diff.Reason = fmt.Sprintf("content differs, size %d vs %d", len(da), len(db))
+	}
+
+	if bytes.HasPrefix(da, elfMagic) && bytes.HasPrefix(db, elfMagic) {
+		diff.Sections = diffELFSections(da, db)
+	}
+
+	return diff, nil
+}
+
+var elfMagic = []byte("\x7fELF")
+
+// diffELFSections returns the names of the sections that differ between
+// the ELF files a and b. A section present in only one is reported with a
+// +/- prefix.
+func diffELFSections(a, b []byte) []string {
+	ea, err := rpath.ParseELFFile(a)
+	if err != nil {
+		return nil
+	}
+
+	eb, err := rpath.ParseELFFile(b)
+	if err != nil {
+		return nil
+	}
+
+	sectionsOf := func(ef rpath.ELFFile) (map[string][]byte, []string) {
+		contents := map[string][]byte{}
+
+		var order []string
+
+		for i := uint16(0); i < ef.GetSectionCount(); i++ {
+			name, err := ef.GetSectionName(i)
+			if err != nil || name == "" {
+				continue
+			}
+
+			data, err := ef.GetSectionContent(i)
+			if err != nil {
+				data = nil
+			}
+
+			contents[name] = data
+			order = append(order, name)
+		}
+
+		return contents, order
+	}
+
+	sa, order := sectionsOf(ea)
+	sb, orderB := sectionsOf(eb)
+
+	var out []string
+
+	for _, name := range order {
+		cb, ok := sb[name]
+		if !ok {
+			out = append(out, "-"+name)
+			continue
+		}
+
+		if !bytes.Equal(sa[name], cb) {
+			out = append(out, name)
+		}
+	}
+
+	for _, name := range orderB {
+		if _, ok := sa[name]; !ok {
+			out = append(out, "+"+name)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,104 @@
+package ops
+
+import (
+	"bytes"
+	"debug/elf"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffStoreDirs(t *testing.T) {
+	top, err := ioutil.TempDir("", "repro")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(top)
+
+	storeA := filepath.Join(top, "run-a", "store")
+	storeB := filepath.Join(top, "run-b", "store")
+
+	a := filepath.Join(storeA, "abc-foo-1.0")
+	b := filepath.Join(storeB, "abc-foo-1.0")
+
+	writeFile := func(t *testing.T, path, data string, mode os.FileMode) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(data), mode))
+		require.NoError(t, os.Chmod(path, mode))
+	}
+
+	t.Run("ignores references to the store", func(t *testing.T) {
+		writeFile(t, filepath.Join(a, "bin", "foo"), "#!"+storeA+"/sh\n", 0755)
+		writeFile(t, filepath.Join(b, "bin", "foo"), "#!"+storeB+"/sh\n", 0755)
+
+		diffs, err := DiffStoreDirs(a, b, storeA, storeB)
+		require.NoError(t, err)
+
+		assert.Empty(t, diffs)
+	})
+
+	t.Run("reports differing files", func(t *testing.T) {
+		writeFile(t, filepath.Join(a, "share", "date"), "Mon Jan 1", 0644)
+		writeFile(t, filepath.Join(b, "share", "date"), "Tue Jan 2", 0644)
+
+		writeFile(t, filepath.Join(a, "share", "mode"), "x", 0644)
+		writeFile(t, filepath.Join(b, "share", "mode"), "x", 0664)
+
+		writeFile(t, filepath.Join(a, "share", "extra"), "x", 0644)
+
+		diffs, err := DiffStoreDirs(a, b, storeA, storeB)
+		require.NoError(t, err)
+
+		require.Len(t, diffs, 3)
+
+		assert.Equal(t, "share/date", diffs[0].Path)
+		assert.Equal(t, "content differs", diffs[0].Reason)
+
+		assert.Equal(t, "share/extra", diffs[1].Path)
+		assert.Equal(t, "only in first build", diffs[1].Reason)
+
+		assert.Equal(t, "share/mode", diffs[2].Path)
+		assert.Contains(t, diffs[2].Reason, "mode differs")
+	})
+
+	t.Run("reports the ELF sections that differ", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("requires an ELF test binary")
+		}
+
+		exe, err := os.Executable()
+		require.NoError(t, err)
+
+		data, err := ioutil.ReadFile(exe)
+		require.NoError(t, err)
+
+		ef, err := elf.NewFile(bytes.NewReader(data))
+		require.NoError(t, err)
+
+		sec := ef.Section(".rodata")
+		require.NotNil(t, sec)
+
+		diffs, err := DiffStoreDirs(a, b, storeA, storeB)
+		require.NoError(t, err)
+
+		before := len(diffs)
+
+		writeFile(t, filepath.Join(a, "bin", "prog"), string(data), 0755)
+
+		data[sec.Offset] ^= 0xff
+
+		writeFile(t, filepath.Join(b, "bin", "prog"), string(data), 0755)
+
+		diffs, err = DiffStoreDirs(a, b, storeA, storeB)
+		require.NoError(t, err)
+
+		require.Len(t, diffs, before+1)
+
+		assert.Equal(t, "bin/prog", diffs[0].Path)
+		assert.Equal(t, []string{".rodata"}, diffs[0].Sections)
+	})
+}
@@ -0,0 +1,340 @@
+package ops
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lab47/exprcore/exprcore"
+)
+
+// The RunCtx builtins for common build systems. Each configures the build to
+// install into the package prefix, finds dependencies in the store, and
+// runs as many jobs as the install environment allows.
+//
+// The number of jobs is deliberately not part of the signature unless the
+// script requests a specific number, since it doesn't change the output.
+
+// listStrings returns the elements of l as strings, l may be nil.
+func listStrings(l *exprcore.List) []string {
+	if l == nil {
+		return nil
+	}
+
+	var out []string
+
+	for i := 0; i < l.Len(); i++ {
+		out = append(out, exprString(l.Index(i)))
+	}
+
+	return out
+}
+
+// jobArgs returns the value to pass to -j. jobs is the number requested by
+// the script, if any.
+func (e *RunCtx) jobArgs(jobs int) string {
+	if jobs <= 0 {
+		jobs = e.jobs
+	}
+
+	if jobs <= 0 {
+		jobs = 1
+	}
+
+	return strconv.Itoa(jobs)
+}
+
+// runTool runs the command given by segments in dir, which is relative to
+// the build dir.
+func (e *RunCtx) runTool(dir string, segments ...string) error {
+	exe, err := lookPath(segments[0], e.path)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(e.ctx, exe, segments[1:]...)
+	cmd.Env = e.extraEnv
+	cmd.Dir = e.workPath(dir)
+
+	e.L.Debug("running build tool", "args", segments, "dir", cmd.Dir)
+
+	e.buildLog.Begin(strings.Join(segments, " ") + " (in " + cmd.Dir + ")")
+
+	return runCmd(e, cmd)
+}
+
+func autotoolsFn(thread *exprcore.Thread, b *exprcore.Builtin, args exprcore.Tuple, kwargs []exprcore.Tuple) (exprcore.Value, error) {
+	env, ok := b.Receiver().(*RunCtx)
+	if !ok {
+		return noRunRC(b.Receiver())
+	}
+
+	var (
+		configureArgs, makeArgs *exprcore.List
+		dir                     string
+		jobs                    int
+		install                 = true
+	)
+
+	if err := exprcore.UnpackArgs(
+		"autotools", args, kwargs,
+		"configure_args?", &configureArgs,
+		"make_args?", &makeArgs,
+		"dir?", &dir,
+		"jobs?", &jobs,
+		"install?", &install,
+	); err != nil {
+		return nil, err
+	}
+
+	cargs := listStrings(configureArgs)
+	margs := listStrings(makeArgs)
+
+	if env.h != nil {
+		return addHash(env, "autotools",
+			"dir", dir,
+			"configure-args", joinQuote(cargs, " "),
+			"make-args", joinQuote(margs, " "),
+			"jobs", jobs,
+			"install", install,
+		)
+	}
+
+	configure := append([]string{
+		filepath.Join(env.workPath(dir), "configure"),
+		"--prefix=" + env.installDir,
+	}, cargs...)
+
+	err := env.runTool(dir, configure...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = env.runTool(dir, append([]string{"make", "-j" + env.jobArgs(jobs)}, margs...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if install {
+		err = env.runTool(dir, append([]string{"make", "install"}, margs...)...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return exprcore.None, nil
+}
+
+func cmakeFn(thread *exprcore.Thread, b *exprcore.Builtin, args exprcore.Tuple, kwargs []exprcore.Tuple) (exprcore.Value, error) {
+	env, ok := b.Receiver().(*RunCtx)
+	if !ok {
+		return noRunRC(b.Receiver())
+	}
+
+	var (
+		cmakeArgs *exprcore.List
+		source    = "."
+		buildDir  = "_build"
+		buildType = "Release"
+		generator string
+		jobs      int
+		install   = true
+	)
+
+	if err := exprcore.UnpackArgs(
+		"cmake", args, kwargs,
+		"args?", &cmakeArgs,
+		"source?", &source,
+		"build_dir?", &buildDir,
+		"build_type?", &buildType,
+		"generator?", &generator,
+		"jobs?", &jobs,
+		"install?", &install,
+	); err != nil {
+		return nil, err
+	}
+
+	cargs := listStrings(cmakeArgs)
+
+	if env.h != nil {
+		return addHash(env, "cmake",
+			"source", source,
+			"build-dir", buildDir,
+			"build-type", buildType,
+			"generator", generator,
+			"args", joinQuote(cargs, " "),
+			"jobs", jobs,
+			"install", install,
+		)
+	}
+
+	configure := []string{
+		"cmake",
+		"-S", env.workPath(source),
+		"-B", env.workPath(buildDir),
+		"-DCMAKE_INSTALL_PREFIX=" + env.installDir,
+		"-DCMAKE_INSTALL_LIBDIR=lib",
+		"-DCMAKE_BUILD_TYPE=" + buildType,
+	}
+
+	if len(env.cmakePrefix) > 0 {
+		configure = append(configure, "-DCMAKE_PREFIX_PATH="+strings.Join(env.cmakePrefix, ";"))
+	}
+
+	if generator != "" {
+		configure = append(configure, "-G", generator)
+	}
+
+	err := env.runTool("", append(configure, cargs...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = env.runTool("", "cmake", "--build", env.workPath(buildDir), "--parallel", env.jobArgs(jobs))
+	if err != nil {
+		return nil, err
+	}
+
+	if install {
+		err = env.runTool("", "cmake", "--install", env.workPath(buildDir))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return exprcore.None, nil
+}
+
+func mesonFn(thread *exprcore.Thread, b *exprcore.Builtin, args exprcore.Tuple, kwargs []exprcore.Tuple) (exprcore.Value, error) {
+	env, ok := b.Receiver().(*RunCtx)
+	if !ok {
+		return noRunRC(b.Receiver())
+	}
+
+	var (
+		mesonArgs *exprcore.List
+		source    = "."
+		buildDir  = "_build"
+		buildType = "release"
+		jobs      int
+		install   = true
+	)
+
+	if err := exprcore.UnpackArgs(
+		"meson", args, kwargs,
+		"args?", &mesonArgs,
+		"source?", &source,
+		"build_dir?", &buildDir,
+		"build_type?", &buildType,
+		"jobs?", &jobs,
+		"install?", &install,
+	); err != nil {
+		return nil, err
+	}
+
+	margs := listStrings(mesonArgs)
+
+	if env.h != nil {
+		return addHash(env, "meson",
+			"source", source,
+			"build-dir", buildDir,
+			"build-type", buildType,
+			"args", joinQuote(margs, " "),
+			"jobs", jobs,
+			"install", install,
+		)
+	}
+
+	setup := []string{
+		"meson", "setup",
+		"--prefix=" + env.installDir,
+		"--libdir=lib",
+		"--buildtype=" + buildType,
+	}
+
+	if len(env.cmakePrefix) > 0 {
+		setup = append(setup, "-Dcmake_prefix_path="+strings.Join(env.cmakePrefix, ","))
+	}
+
+	setup = append(setup, margs...)
+	setup = append(setup, env.workPath(buildDir), env.workPath(source))
+
+	err := env.runTool("", setup...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = env.runTool("", "meson", "compile", "-C", env.workPath(buildDir), "-j", env.jobArgs(jobs))
+	if err != nil {
+		return nil, err
+	}
+
+	if install {
+		err = env.runTool("", "meson", "install", "-C", env.workPath(buildDir))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return exprcore.None, nil
+}
+
+func makeFn(thread *exprcore.Thread, b *exprcore.Builtin, args exprcore.Tuple, kwargs []exprcore.Tuple) (exprcore.Value, error) {
+	env, ok := b.Receiver().(*RunCtx)
+	if !ok {
+		return noRunRC(b.Receiver())
+	}
+
+	var (
+		target   string
+		makeArgs *exprcore.List
+		dir      string
+		jobs     int
+	)
+
+	if err := exprcore.UnpackArgs(
+		"make", args, kwargs,
+		"target?", &target,
+		"args?", &makeArgs,
+		"dir?", &dir,
+		"jobs?", &jobs,
+	); err != nil {
+		return nil, err
+	}
+
+	margs := listStrings(makeArgs)
+
+	if env.h != nil {
+		return addHash(env, "make",
+			"target", target,
+			"dir", dir,
+			"args", joinQuote(margs, " "),
+			"jobs", jobs,
+		)
+	}
+
+	segments := []string{"make", "-j" + env.jobArgs(jobs)}
+
+	// Targets such as install are commonly not safe to run in parallel.
+	if target != "" && target != "all" {
+		segments = []string{"make"}
+
+		if jobs > 0 {
+			segments = append(segments, "-j"+env.jobArgs(jobs))
+		}
+	}
+
+	segments = append(segments, margs...)
+
+	if target != "" {
+		segments = append(segments, target)
+	}
+
+	err := env.runTool(dir, segments...)
+	if err != nil {
+		return nil, err
+	}
+
+	return exprcore.None, nil
+}
@@ -0,0 +1,104 @@
+package ops
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/lab47/exprcore/exprcore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildToolHelpers(t *testing.T) {
+	top, err := ioutil.TempDir("", "buildtools")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(top)
+
+	load := func(t *testing.T, rev, body string) *ScriptPackage {
+		dir := filepath.Join(top, rev)
+
+		script := `
+def install(rc) {
+  ` + body + `
+}
+
+pkg(name: "tool", version: "1.0", install: install)
+`
+
+		writeScripts(t, dir, map[string]string{"tool" + Extension: script})
+
+		pkg, err := loadScript(dir, "tool")
+		require.NoError(t, err)
+
+		return pkg
+	}
+
+	t.Run("hashes the arguments into the signature", func(t *testing.T) {
+		a := load(t, "a", `rc.cmake(args: ["-DFOO=1"])`)
+		b := load(t, "b", `rc.cmake(args: ["-DFOO=2"])`)
+
+		assert.NotEqual(t, a.ID(), b.ID())
+
+		require.Len(t, a.cs.installPlan, 1)
+		assert.Contains(t, a.cs.installPlan[0], `"cmake"`)
+		assert.Contains(t, a.cs.installPlan[0], `"args" "-DFOO=1"`)
+	})
+
+	t.Run("only hashes the jobs when requested", func(t *testing.T) {
+		a := load(t, "a", `rc.make(target: "install")`)
+		b := load(t, "b", `rc.make(target: "install")`)
+		c := load(t, "c", `rc.make(target: "install", jobs: 1)`)
+
+		assert.Equal(t, a.ID(), b.ID())
+		assert.NotEqual(t, a.ID(), c.ID())
+	})
+
+	t.Run("runs configure and make with the prefix and jobs", func(t *testing.T) {
+		build := filepath.Join(top, "build")
+		bin := filepath.Join(top, "bin")
+		record := filepath.Join(top, "record")
+
+		require.NoError(t, os.MkdirAll(build, 0755))
+		require.NoError(t, os.MkdirAll(bin, 0755))
+
+		tool := "#!/bin/sh\necho \"$(basename $0) $*\" >> " + record + "\n"
+
+		require.NoError(t, ioutil.WriteFile(filepath.Join(build, "configure"), []byte(tool), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(bin, "make"), []byte(tool), 0755))
+
+		rc := &RunCtx{
+			L:          hclog.NewNullLogger(),
+			ctx:        context.Background(),
+			installDir: "/store/tool",
+			buildDir:   build,
+			path:       bin + ":/bin:/usr/bin",
+			attrs:      RunCtxFunctions,
+			jobs:       3,
+		}
+
+		fn, err := rc.Attr("autotools")
+		require.NoError(t, err)
+
+		var thread exprcore.Thread
+
+		_, err = exprcore.Call(&thread, fn, nil, []exprcore.Tuple{
+			{exprcore.String("configure_args"), exprcore.NewList([]exprcore.Value{exprcore.String("--disable-nls")})},
+		})
+		require.NoError(t, err)
+
+		data, err := ioutil.ReadFile(record)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{
+			"configure --prefix=/store/tool --disable-nls",
+			"make -j3",
+			"make install",
+		}, strings.Split(strings.TrimSpace(string(data)), "\n"))
+	})
+}
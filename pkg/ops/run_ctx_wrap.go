@@ -0,0 +1,172 @@
+package ops
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lab47/exprcore/exprcore"
+	"github.com/pkg/errors"
+	"lab47.dev/aperture/pkg/hook"
+)
+
+// wrapVar is an environment variable set by a program wrapper.
+type wrapVar struct {
+	name  string
+	value string
+}
+
+// wrapVars converts the entries of d into a sorted list. Values may be a
+// string or a list of strings, which are joined with ':'.
+func wrapVars(op string, d *exprcore.Dict) ([]wrapVar, error) {
+	if d == nil {
+		return nil, nil
+	}
+
+	var vars []wrapVar
+
+	for _, item := range d.Items() {
+		name, ok := item[0].(exprcore.String)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected string name, got %s", op, item[0].Type())
+		}
+
+		var value string
+
+		switch v := item[1].(type) {
+		case exprcore.String:
+			value = string(v)
+		case *exprcore.List:
+			value = strings.Join(listStrings(v), ":")
+		default:
+			return nil, fmt.Errorf("%s: expected string or list value for %s, got %s", op, name, v.Type())
+		}
+
+		vars = append(vars, wrapVar{name: string(name), value: value})
+	}
+
+	sort.Slice(vars, func(i, j int) bool {
+		return vars[i].name < vars[j].name
+	})
+
+	return vars, nil
+}
+
+func wrapVarsHash(vars []wrapVar) string {
+	var parts []string
+
+	for _, v := range vars {
+		parts = append(parts, v.name+"="+v.value)
+	}
+
+	return joinQuote(parts, " ")
+}
+
+// wrappedPath returns the path the real program at path is moved to.
+func wrappedPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+"-wrapped")
+}
+
+// programWrapper returns a shell script that sets up the environment given
+// and then runs target. The values are written out verbatim, so any store
+// paths they contain are visible to the runtime dependency detection.
+func programWrapper(target string, set, prefix, suffix []wrapVar) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString("#!/bin/sh\n")
+
+	for _, v := range set {
+		fmt.Fprintf(&buf, "export %s=%s\n", v.name, hook.Quote(v.value))
+	}
+
+	for _, v := range prefix {
+		fmt.Fprintf(&buf, "export %s=%s\"${%s:+:$%s}\"\n", v.name, hook.Quote(v.value), v.name, v.name)
+	}
+
+	for _, v := range suffix {
+		fmt.Fprintf(&buf, "export %s=\"${%s:+$%s:}\"%s\n", v.name, v.name, v.name, hook.Quote(v.value))
+	}
+
+	fmt.Fprintf(&buf, "exec %s \"$@\"\n", hook.Quote(target))
+
+	return buf.Bytes()
+}
+
+func wrapProgramFn(thread *exprcore.Thread, b *exprcore.Builtin, args exprcore.Tuple, kwargs []exprcore.Tuple) (exprcore.Value, error) {
+	env, ok := b.Receiver().(*RunCtx)
+	if !ok {
+		return noRunRC(b.Receiver())
+	}
+
+	var (
+		path                   string
+		setD, prefixD, suffixD *exprcore.Dict
+	)
+
+	if err := exprcore.UnpackArgs(
+		"wrap_program", args, kwargs,
+		"path", &path,
+		"set?", &setD,
+		"prefix?", &prefixD,
+		"suffix?", &suffixD,
+	); err != nil {
+		return nil, err
+	}
+
+	set, err := wrapVars("wrap_program", setD)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix, err := wrapVars("wrap_program", prefixD)
+	if err != nil {
+		return nil, err
+	}
+
+	suffix, err := wrapVars("wrap_program", suffixD)
+	if err != nil {
+		return nil, err
+	}
+
+	if env.h != nil {
+		return addHash(env, "wrap-program",
+			"path", path,
+			"set", wrapVarsHash(set),
+			"prefix", wrapVarsHash(prefix),
+			"suffix", wrapVarsHash(suffix),
+		)
+	}
+
+	target := env.outPath(path)
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return nil, errors.Wrapf(err, "wrapping program %s", path)
+	}
+
+	wrapped := wrappedPath(target)
+
+	if _, err := os.Lstat(wrapped); err == nil {
+		return nil, fmt.Errorf("program %s has already been wrapped", path)
+	}
+
+	env.L.Debug("wrapping program", "path", target, "wrapped", wrapped)
+
+	err = os.Rename(target, wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	data := programWrapper(wrapped, set, prefix, suffix)
+
+	err = ioutil.WriteFile(target, data, info.Mode().Perm()|0111)
+	if err != nil {
+		return nil, errors.Wrapf(err, "writing wrapper for %s", path)
+	}
+
+	return exprcore.None, nil
+}
@@ -0,0 +1,99 @@
+package ops
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/lab47/exprcore/exprcore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrapProgram(t *testing.T) {
+	top, err := ioutil.TempDir("", "wrap")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(top)
+
+	store := filepath.Join(top, "store")
+	depDir := filepath.Join(store, "3NJz8BKn3gectrsXSJS1Ljjb8ejDsKjxhVv6n3p3YFLA-gems-1.0")
+	installDir := filepath.Join(store, "8nYxuyMgeefwTQ6WwD7eyneDY8fDksGNuRoKMZLJ1h4F-tool-1.0")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(installDir, "bin"), 0755))
+
+	prog := "#!/bin/sh\necho \"$GEM_PATH|$PATH|$MODE|$*\"\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(installDir, "bin", "tool"), []byte(prog), 0755))
+
+	rc := &RunCtx{
+		L:          hclog.NewNullLogger(),
+		ctx:        context.Background(),
+		installDir: installDir,
+		attrs:      RunCtxFunctions,
+	}
+
+	fn, err := rc.Attr("wrap_program")
+	require.NoError(t, err)
+
+	dict := func(k string, v exprcore.Value) *exprcore.Dict {
+		d := exprcore.NewDict(1)
+		require.NoError(t, d.SetKey(exprcore.String(k), v))
+		return d
+	}
+
+	var thread exprcore.Thread
+
+	_, err = exprcore.Call(&thread, fn, exprcore.Tuple{exprcore.String("bin/tool")}, []exprcore.Tuple{
+		{exprcore.String("set"), dict("MODE", exprcore.String("it's set"))},
+		{exprcore.String("prefix"), dict("GEM_PATH", exprcore.NewList([]exprcore.Value{
+			exprcore.String(depDir + "/gems"),
+			exprcore.String(depDir + "/vendor"),
+		}))},
+		{exprcore.String("suffix"), dict("PATH", exprcore.String(depDir+"/bin"))},
+	})
+	require.NoError(t, err)
+
+	t.Run("runs the program with the environment", func(t *testing.T) {
+		cmd := exec.Command(filepath.Join(installDir, "bin", "tool"), "a", "b")
+		cmd.Env = []string{"GEM_PATH=/other", "PATH=/bin:/usr/bin"}
+
+		out, err := cmd.Output()
+		require.NoError(t, err)
+
+		assert.Equal(t,
+			depDir+"/gems:"+depDir+"/vendor:/other|/bin:/usr/bin:"+depDir+"/bin|it's set|a b",
+			strings.TrimSpace(string(out)))
+	})
+
+	t.Run("keeps the real program aside", func(t *testing.T) {
+		data, err := ioutil.ReadFile(filepath.Join(installDir, "bin", ".tool-wrapped"))
+		require.NoError(t, err)
+
+		assert.Equal(t, prog, string(data))
+	})
+
+	t.Run("refuses to wrap a program twice", func(t *testing.T) {
+		_, err := exprcore.Call(&thread, fn, exprcore.Tuple{exprcore.String("bin/tool")}, nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("exposes the store paths to dependency detection", func(t *testing.T) {
+		data, err := ioutil.ReadFile(filepath.Join(installDir, "bin", "tool"))
+		require.NoError(t, err)
+
+		var dr depDetect
+		dr.deps = map[string]struct{}{}
+		dr.prefix = []byte(store + "/")
+		dr.buf = new(bytes.Buffer)
+
+		dr.Write(data)
+
+		assert.Contains(t, dr.deps, "3NJz8BKn3gectrsXSJS1Ljjb8ejDsKjxhVv6n3p3YFLA")
+	})
+}
@@ -9,7 +9,7 @@ type ScriptCalcDeps struct {
 }
 
 func (i *ScriptCalcDeps) pkgRuntimeDeps(pkg *ScriptPackage) ([]*ScriptPackage, error) {
-	runtimeDeps := pkg.Dependencies()
+	runtimeDeps := append(pkg.Dependencies(), pkg.siblings()...)
 
 	var pri PackageReadInfo
 	pri.Store = i.store
@@ -66,14 +66,20 @@ func (i *ScriptCalcDeps) EvalDeps(pkgs []*ScriptPackage) ([]*ScriptPackage, erro
 			return nil, err
 		}
 
-		deps[pkg.ID()] = rd
-
 		for _, dep := range rd {
 			if _, ok := seen[dep.ID()]; !ok {
 				seen[dep.ID()] = dep
+				usage[dep.ID()] = 0
 				pkgs = append(pkgs, dep)
 			}
 
+			// Packages from the same build can refer to each other, but
+			// they're all created at once so there is no order between them.
+			if sameBuild(pkg, dep) {
+				continue
+			}
+
+			deps[pkg.ID()] = append(deps[pkg.ID()], dep)
 			usage[dep.ID()]++
 		}
 	}
@@ -1,20 +1,25 @@
 package ops
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 	"hash"
 	"io"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/hashicorp/go-hclog"
 	"github.com/lab47/exprcore/exprcore"
 	"github.com/mr-tron/base58"
+	"github.com/pkg/errors"
 	"golang.org/x/crypto/blake2b"
+	"lab47.dev/aperture/pkg/data"
 	"lab47.dev/aperture/pkg/evt"
 	"lab47.dev/aperture/pkg/lang"
 )
@@ -23,6 +28,7 @@ type ScriptInput struct {
 	Name     string
 	Data     *ScriptFile
 	Instance *Instance
+	Git      *GitInput
 }
 
 type ScriptCalcSig struct {
@@ -36,10 +42,22 @@ type ScriptCalcSig struct {
 	Install      *exprcore.Function
 	Hook         *exprcore.Function
 	PostInstall  *exprcore.Function
+	Check        *exprcore.Function
+	Outputs      map[string][]string
+	Environment  []*data.PackageEnv
+	Hardening    []string
+	Priority     int
 	Inputs       []ScriptInput
 	Dependencies []*ScriptPackage
 	ExplicitDeps []*ScriptPackage
 	Instances    []*Instance
+
+	// The data the signature was calculated from along with the
+	// statements that make up the install and post_install functions.
+	// These are retained so that SigDiff can explain a change in signature.
+	sigData     *sigData
+	installPlan []string
+	postPlan    []string
 }
 
 func exprString(val exprcore.Value) string {
@@ -94,6 +112,48 @@ func (s *ScriptCalcSig) extract(proto *exprcore.Prototype) error {
 		}
 	}
 
+	val, err = proto.Attr("environment")
+	if err != nil {
+		if _, ok := err.(exprcore.NoSuchAttrError); ok {
+			val = nil
+		} else {
+			return err
+		}
+	}
+
+	if val != nil && val != exprcore.None {
+		err = s.extractEnvironment(val)
+		if err != nil {
+			return err
+		}
+	}
+
+	val, err = proto.Attr("hardening")
+	if err != nil {
+		if _, ok := err.(exprcore.NoSuchAttrError); ok {
+			val = nil
+		} else {
+			return err
+		}
+	}
+
+	if val != nil && val != exprcore.None {
+		err = s.extractHardening(val)
+		if err != nil {
+			return err
+		}
+	}
+
+	// priority only affects how the package is linked into profiles, so
+	// it's not part of the signature either.
+	val, err = proto.Attr("priority")
+	if err == nil && val != exprcore.None {
+		s.Priority, err = exprcore.AsInt32(val)
+		if err != nil {
+			return errors.Wrapf(err, "priority must be an integer")
+		}
+	}
+
 	val, err = proto.Attr("input")
 	if err != nil {
 		if _, ok := err.(exprcore.NoSuchAttrError); ok {
@@ -110,6 +170,22 @@ func (s *ScriptCalcSig) extract(proto *exprcore.Prototype) error {
 		}
 	}
 
+	val, err = proto.Attr("outputs")
+	if err != nil {
+		if _, ok := err.(exprcore.NoSuchAttrError); ok {
+			val = nil
+		} else {
+			return err
+		}
+	}
+
+	if val != nil && val != exprcore.None {
+		err = s.extractOutputs(val)
+		if err != nil {
+			return err
+		}
+	}
+
 	install, err := lang.FuncValue(proto.Attr("install"))
 	if err != nil {
 		return err
@@ -131,6 +207,15 @@ func (s *ScriptCalcSig) extract(proto *exprcore.Prototype) error {
 
 	s.PostInstall = post
 
+	// check is deliberately not part of the signature, whether or not it
+	// runs has no effect on the installed package.
+	check, err := lang.FuncValue(proto.Attr("check"))
+	if err != nil {
+		return err
+	}
+
+	s.Check = check
+
 	depSet := map[string]struct{}{}
 
 	deps, err := lang.ListValue(proto.Attr("dependencies"))
@@ -198,6 +283,11 @@ func (s *ScriptCalcSig) processInput(val exprcore.Value) error {
 			Name:     "source",
 			Instance: v,
 		})
+	case *GitInput:
+		inputs = append(inputs, ScriptInput{
+			Name: "source",
+			Git:  v,
+		})
 	case *exprcore.Dict:
 		for _, i := range v.Items() {
 			key, ok := i.Index(0).(exprcore.String)
@@ -218,6 +308,11 @@ func (s *ScriptCalcSig) processInput(val exprcore.Value) error {
 					Name:     string(key),
 					Instance: f,
 				})
+			case *GitInput:
+				inputs = append(inputs, ScriptInput{
+					Name: string(key),
+					Git:  f,
+				})
 			default:
 				return fmt.Errorf("unsupported type in inputs: %T", dv)
 			}
@@ -274,11 +369,27 @@ type sigData struct {
 	FuncSig      string
 	PostSig      string
 	Dependencies map[string]struct{}
+	Outputs      map[string]string
+
+	// The commits of any git inputs, by input name.
+	Sources map[string]string
+
+	// The environment the package sets in profiles, by variable name.
+	Environment map[string]string
+
+	// The hardening policy and the features the package is built with.
+	Hardening string
+
+	// The helpers from .export.xcr files that were called by install or
+	// post_install, mapped to the signature of their code. This is only
+	// set when helpers are called, so the ids of packages that don't use
+	// helpers are unchanged by its introduction. Packages that do call
+	// helpers received a new id once, when it was introduced.
+	Helpers map[string]string
 }
 
 func (s *ScriptCalcSig) calcSig(
 	proto *exprcore.Prototype,
-	helperSum []byte,
 	constraints map[string]string,
 ) (string, error) {
 	if s.Name == "" {
@@ -288,10 +399,18 @@ func (s *ScriptCalcSig) calcSig(
 		}
 	}
 
+	hardening, err := s.hardeningSigData()
+	if err != nil {
+		return "", err
+	}
+
 	sd := sigData{
 		Name:        s.Name,
 		Version:     s.Version,
 		Constraints: constraints,
+		Outputs:     s.outputSigData(),
+		Environment: s.environmentSigData(),
+		Hardening:   hardening,
 	}
 
 	if s.Inputs != nil {
@@ -308,24 +427,60 @@ func (s *ScriptCalcSig) calcSig(
 			val := fmt.Sprintf("%s-%s-%s", i.Name, i.Version, i.Signature)
 			sd.Instances[val] = struct{}{}
 		}
+
+		for _, i := range s.Inputs {
+			if i.Git == nil {
+				continue
+			}
+
+			err := i.Git.resolve(context.Background())
+			if err != nil {
+				return "", err
+			}
+
+			if sd.Sources == nil {
+				sd.Sources = map[string]string{}
+			}
+
+			sd.Sources[i.Name] = i.Git.sigValue()
+		}
 	}
 
+	// Only the helpers that install and post_install actually call are
+	// included in the signature, so that editing an unrelated helper doesn't
+	// change the id of every package that imports it.
+	helpers := map[string]string{}
+
 	if s.Install != nil {
-		funcSig, err := s.calcFuncSig(s.Install)
+		plan, err := s.planFuncSig(s.Install)
 		if err != nil {
 			return "", err
 		}
 
-		sd.FuncSig = funcSig
+		sd.FuncSig = plan.sig
+		s.installPlan = plan.stmts
+
+		for k, v := range plan.helpers {
+			helpers[k] = v
+		}
 	}
 
 	if s.PostInstall != nil {
-		funcSig, err := s.calcFuncSig(s.PostInstall)
+		plan, err := s.planFuncSig(s.PostInstall)
 		if err != nil {
 			return "", err
 		}
 
-		sd.PostSig = funcSig
+		sd.PostSig = plan.sig
+		s.postPlan = plan.stmts
+
+		for k, v := range plan.helpers {
+			helpers[k] = v
+		}
+	}
+
+	if len(helpers) > 0 {
+		sd.Helpers = helpers
 	}
 
 	sd.Dependencies = make(map[string]struct{})
@@ -344,15 +499,39 @@ func (s *ScriptCalcSig) calcSig(
 
 	h := &calcLogger{logger: s.L(), h: hb}
 
-	err := evt.HashInto(&sd, h)
+	err = evt.HashInto(&sd, h)
 	if err != nil {
 		return "", err
 	}
 
+	s.sigData = &sd
+
 	return base58.Encode(hb.Sum(nil)), nil
 }
 
 func (s *ScriptCalcSig) calcFuncSig(fn exprcore.Value) (string, error) {
+	plan, err := s.planFuncSig(fn)
+	if err != nil {
+		return "", err
+	}
+
+	return plan.sig, nil
+}
+
+type funcPlan struct {
+	sig string
+
+	// Each of the statements that went into sig, in the order they were run.
+	stmts []string
+
+	// The helper functions that were called, mapped to the signature of
+	// their code.
+	helpers map[string]string
+}
+
+// planFuncSig calculates the signature of fn, along with the details of
+// what went into it.
+func (s *ScriptCalcSig) planFuncSig(fn exprcore.Value) (*funcPlan, error) {
 	var rc RunCtx
 	rc.attrs = RunCtxFunctions
 	rc.topDir = "$top"
@@ -362,18 +541,71 @@ func (s *ScriptCalcSig) calcFuncSig(fn exprcore.Value) (string, error) {
 
 	h, _ := blake2b.New256(nil)
 
-	rc.h = h
+	var plan strings.Builder
+
+	rc.h = io.MultiWriter(h, &plan)
 
 	args := exprcore.Tuple{&rc}
 
+	helpers := map[string]string{}
+
 	var thread exprcore.Thread
 
+	thread.CallTrace = func(thread *exprcore.Thread, c exprcore.Callable, args exprcore.Tuple, kwargs []exprcore.Tuple) (exprcore.Value, error) {
+		if fn, ok := c.(*exprcore.Function); ok {
+			if name, ok := helperName(fn); ok {
+				code, err := fn.HashCode()
+				if err != nil {
+					return nil, err
+				}
+
+				helpers[name] = base58.Encode(code)
+			}
+		}
+
+		return nil, exprcore.CallContinue
+	}
+
 	_, err := exprcore.Call(&thread, fn, args, nil)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return base58.Encode(h.Sum(nil)), nil
+	// addHash terminates each statement with a blank line, and quotes all
+	// strings, so a blank line can only appear between statements.
+	var stmts []string
+
+	for _, stmt := range strings.Split(plan.String(), "\n\n") {
+		if stmt != "" {
+			stmts = append(stmts, strings.Replace(stmt, "\n", " ", -1))
+		}
+	}
+
+	return &funcPlan{
+		sig:     base58.Encode(h.Sum(nil)),
+		stmts:   stmts,
+		helpers: helpers,
+	}, nil
+}
+
+// helperName returns the name to identify fn by in a signature, if fn is
+// a helper defined in a .export.xcr file.
+func helperName(fn *exprcore.Function) (string, bool) {
+	pos := fn.Position()
+
+	file := filepath.Base(pos.Filename())
+	if !strings.HasSuffix(file, ExportExtension) {
+		return "", false
+	}
+
+	name := strings.TrimSuffix(file, ExportExtension) + "." + fn.Name()
+
+	// Anonymous functions all share the same name.
+	if fn.Name() == "lambda" {
+		name = fmt.Sprintf("%s:%d", name, pos.Line)
+	}
+
+	return name, true
 }
 
 func (s *ScriptCalcSig) calcInstance(inst *Instance) error {
@@ -403,6 +635,10 @@ func (s *ScriptCalcSig) injectInputs() ([]*Instance, error) {
 			continue
 		}
 
+		if i.Git != nil {
+			continue
+		}
+
 		spew.Dump(i)
 		panic("not supported")
 	}
@@ -459,10 +695,9 @@ var times int
 
 func (s *ScriptCalcSig) Calculate(
 	proto *exprcore.Prototype,
-	helperSum []byte,
 	constraints map[string]string,
 ) (string, string, error) {
-	sig, err := s.calcSig(proto, helperSum, constraints)
+	sig, err := s.calcSig(proto, constraints)
 	if err != nil {
 		return "", "", err
 	}
@@ -0,0 +1,75 @@
+package ops
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/lab47/exprcore/exprcore"
+	"github.com/pkg/errors"
+	"lab47.dev/aperture/pkg/data"
+)
+
+var ErrBadEnvironment = errors.New("invalid environment")
+
+// extractEnvironment reads the environment attribute of a script. It's a
+// dict of variable names to either a string, which is the value of the
+// variable, or a list of paths to prepend to the variable.
+func (s *ScriptCalcSig) extractEnvironment(val exprcore.Value) error {
+	d, ok := val.(*exprcore.Dict)
+	if !ok {
+		return errors.Wrapf(ErrBadEnvironment, "environment must be a dict, not %s", val.Type())
+	}
+
+	var env []*data.PackageEnv
+
+	for _, item := range d.Items() {
+		name, ok := item[0].(exprcore.String)
+		if !ok || name == "" || strings.ContainsAny(string(name), "= ") {
+			return errors.Wrapf(ErrBadEnvironment, "invalid variable name: %s", item[0])
+		}
+
+		pe := &data.PackageEnv{Name: string(name)}
+
+		switch v := item[1].(type) {
+		case exprcore.String:
+			pe.Value = string(v)
+		case *exprcore.List:
+			pe.Paths = listStrings(v)
+		default:
+			return errors.Wrapf(ErrBadEnvironment, "value of %s must be a string or list, not %s", name, v.Type())
+		}
+
+		env = append(env, pe)
+	}
+
+	sort.Slice(env, func(i, j int) bool {
+		return env[i].Name < env[j].Name
+	})
+
+	if len(env) > 0 {
+		s.Environment = env
+	}
+
+	return nil
+}
+
+// environmentSigData is the part of the package signature that covers the
+// environment. It's nil for packages that don't set any, so their ids are
+// unaffected.
+func (s *ScriptCalcSig) environmentSigData() map[string]string {
+	if len(s.Environment) == 0 {
+		return nil
+	}
+
+	sd := map[string]string{}
+
+	for _, pe := range s.Environment {
+		if pe.Paths != nil {
+			sd[pe.Name] = "paths:" + strings.Join(pe.Paths, ":")
+		} else {
+			sd[pe.Name] = "value:" + pe.Value
+		}
+	}
+
+	return sd
+}
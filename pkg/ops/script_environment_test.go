@@ -0,0 +1,71 @@
+package ops
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"lab47.dev/aperture/pkg/data"
+)
+
+func TestScriptEnvironment(t *testing.T) {
+	top, err := ioutil.TempDir("", "environment")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(top)
+
+	load := func(t *testing.T, rev, attrs string) (*ScriptPackage, error) {
+		dir := filepath.Join(top, rev)
+
+		script := `
+def install(rc) {
+  rc.shell("make")
+}
+
+pkg(name: "jdk", version: "1.0", install: install` + attrs + `)
+`
+
+		writeScripts(t, dir, map[string]string{"jdk" + Extension: script})
+
+		return loadScript(dir, "jdk")
+	}
+
+	t.Run("reads the environment", func(t *testing.T) {
+		pkg, err := load(t, "read", `, environment: %{
+  "MANPATH": ["$prefix/share/man"],
+  "JAVA_HOME": "$prefix/libexec",
+}`)
+		require.NoError(t, err)
+
+		assert.Equal(t, []*data.PackageEnv{
+			{Name: "JAVA_HOME", Value: "$prefix/libexec"},
+			{Name: "MANPATH", Paths: []string{"$prefix/share/man"}},
+		}, pkg.cs.Environment)
+	})
+
+	t.Run("includes the environment in the signature", func(t *testing.T) {
+		plain, err := load(t, "plain", "")
+		require.NoError(t, err)
+
+		a, err := load(t, "a", `, environment: %{"JAVA_HOME": "$prefix/libexec"}`)
+		require.NoError(t, err)
+
+		b, err := load(t, "b", `, environment: %{"JAVA_HOME": "$prefix/libexec/jdk"}`)
+		require.NoError(t, err)
+
+		assert.Nil(t, plain.cs.sigData.Environment)
+		assert.NotEqual(t, plain.ID(), a.ID())
+		assert.NotEqual(t, a.ID(), b.ID())
+
+		diff := DiffSignatures(a, b)
+		assert.Equal(t, []string{`environment JAVA_HOME: "value:$prefix/libexec" => "value:$prefix/libexec/jdk"`}, diff.Changes)
+	})
+
+	t.Run("rejects invalid values", func(t *testing.T) {
+		_, err := load(t, "invalid", `, environment: %{"JAVA_HOME": 1}`)
+		assert.ErrorIs(t, err, ErrBadEnvironment)
+	})
+}
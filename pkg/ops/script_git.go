@@ -0,0 +1,132 @@
+package ops
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+
+	"github.com/lab47/exprcore/exprcore"
+	"github.com/pkg/errors"
+	"lab47.dev/aperture/pkg/config"
+)
+
+// GitInput is a script input that provides the tree of a git repository
+// at a specific commit.
+type GitInput struct {
+	cache *config.GitCache
+
+	URL        string
+	Rev        string
+	Commit     string
+	Submodules bool
+}
+
+var ErrNoGitCache = errors.New("git inputs are not available, no git cache configured")
+
+func (l *ScriptLoad) gitFn(thread *exprcore.Thread, b *exprcore.Builtin, args exprcore.Tuple, kwargs []exprcore.Tuple) (exprcore.Value, error) {
+	var (
+		url, rev   string
+		submodules bool
+	)
+
+	if err := exprcore.UnpackArgs(
+		"git", args, kwargs,
+		"url", &url,
+		"rev?", &rev,
+		"submodules?", &submodules,
+	); err != nil {
+		return nil, err
+	}
+
+	if l.GitCache == nil {
+		return nil, ErrNoGitCache
+	}
+
+	if rev == "" {
+		rev = "HEAD"
+	}
+
+	// The revision isn't resolved until the package signature is
+	// calculated, so scripts that are only evaluated don't fetch anything.
+	return &GitInput{
+		cache:      l.GitCache,
+		URL:        url,
+		Rev:        rev,
+		Submodules: submodules,
+	}, nil
+}
+
+// resolve sets Commit to the commit that Rev refers to, if it hasn't
+// been already.
+func (g *GitInput) resolve(ctx context.Context) error {
+	if g.Commit != "" {
+		return nil
+	}
+
+	commit, err := g.cache.Resolve(ctx, g.URL, g.Rev)
+	if err != nil {
+		return errors.Wrapf(err, "resolving git input %s", g.URL)
+	}
+
+	g.Commit = commit
+
+	return nil
+}
+
+// sigValue is the value used for the input in the package signature. The
+// url is left out so that moving a repository doesn't change the ids of
+// the packages built from it.
+func (g *GitInput) sigValue() string {
+	if g.Submodules {
+		return "git:" + g.Commit + "+submodules"
+	}
+
+	return "git:" + g.Commit
+}
+
+// Export writes a clean copy of the tree into dir.
+func (g *GitInput) Export(ctx context.Context, dir string) error {
+	return g.cache.Export(ctx, g.URL, g.Commit, dir, g.Submodules)
+}
+
+// String returns the string representation of the value.
+func (g *GitInput) String() string {
+	return fmt.Sprintf("git(url: %s, rev: %s)", g.URL, g.Rev)
+}
+
+// Type returns a short string describing the value's type.
+func (g *GitInput) Type() string {
+	return "script:git"
+}
+
+func (g *GitInput) Freeze() {}
+
+func (g *GitInput) Truth() exprcore.Bool {
+	return exprcore.True
+}
+
+func (g *GitInput) Hash() (uint32, error) {
+	h := fnv.New32()
+	h.Write([]byte(g.URL))
+	h.Write([]byte(g.Rev))
+	return h.Sum32(), nil
+}
+
+func (i *ScriptInstall) setupInputGit(ctx context.Context, dir string, in ScriptInput) error {
+	dest := filepath.Join(dir, in.Name)
+
+	err := in.Git.resolve(ctx)
+	if err != nil {
+		return err
+	}
+
+	i.L().Info("exporting git input", "url", in.Git.URL, "commit", in.Git.Commit, "dest", dest)
+
+	err = in.Git.Export(ctx, dest)
+	if err != nil {
+		return errors.Wrapf(err, "exporting git input %s", in.Name)
+	}
+
+	return nil
+}
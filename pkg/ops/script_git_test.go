@@ -0,0 +1,169 @@
+package ops
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"lab47.dev/aperture/pkg/config"
+)
+
+func TestGitInput(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	top, err := ioutil.TempDir("", "gitinput")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(top)
+
+	repo := filepath.Join(top, "repo")
+	require.NoError(t, os.MkdirAll(repo, 0755))
+
+	git := func(t *testing.T, args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		)
+
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+
+		return strings.TrimSpace(string(out))
+	}
+
+	commit := func(t *testing.T, tag, content string) string {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(repo, "README"), []byte(content), 0644))
+		git(t, "add", "README")
+		git(t, "commit", "-q", "-m", tag)
+		git(t, "tag", tag)
+
+		return git(t, "rev-parse", "HEAD")
+	}
+
+	git(t, "init", "-q")
+
+	// The cache must follow the default branch of the repository rather
+	// than the one git init defaults to.
+	git(t, "symbolic-ref", "HEAD", "refs/heads/trunk")
+
+	v1 := commit(t, "v1", "version 1")
+	v2 := commit(t, "v2", "version 2")
+
+	cache := &config.GitCache{Dir: filepath.Join(top, "cache")}
+
+	load := func(t *testing.T, rev string) *ScriptPackage {
+		dir := filepath.Join(top, "scripts-"+rev)
+
+		script := `
+def install(rc) {
+  rc.shell("make")
+}
+
+pkg(
+  name: "src",
+  version: "1.0",
+  input: git(url: "` + repo + `", rev: "` + rev + `"),
+  install: install,
+)
+`
+
+		writeScripts(t, dir, map[string]string{"src" + Extension: script})
+
+		var sl ScriptLoad
+		sl.lookup = &ScriptLookup{Path: []string{dir}}
+		sl.GitCache = cache
+
+		pkg, err := sl.Load("src")
+		require.NoError(t, err)
+
+		return pkg
+	}
+
+	t.Run("resolves the revision to a commit", func(t *testing.T) {
+		pkg := load(t, "v1")
+
+		require.Len(t, pkg.cs.Inputs, 1)
+
+		in := pkg.cs.Inputs[0]
+		require.NotNil(t, in.Git)
+
+		assert.Equal(t, "source", in.Name)
+		assert.Equal(t, v1, in.Git.Commit)
+		assert.Equal(t, "git:"+v1, pkg.cs.sigData.Sources["source"])
+	})
+
+	t.Run("changes the id when the commit changes", func(t *testing.T) {
+		a := load(t, "v1")
+		b := load(t, "v2")
+		c := load(t, v2)
+
+		assert.NotEqual(t, a.ID(), b.ID())
+		assert.Equal(t, b.ID(), c.ID())
+
+		diff := DiffSignatures(a, b)
+		assert.Equal(t, []string{`input source: "git:` + v1 + `" => "git:` + v2 + `"`}, diff.Changes)
+	})
+
+	t.Run("defaults to the default branch", func(t *testing.T) {
+		pkg := load(t, "")
+
+		assert.Equal(t, v2, pkg.cs.Inputs[0].Git.Commit)
+	})
+
+	t.Run("only resolves cached commits without fetching", func(t *testing.T) {
+		load(t, "v1")
+
+		moved := repo + "-moved"
+		require.NoError(t, os.Rename(repo, moved))
+
+		defer os.Rename(moved, repo)
+
+		commit, err := cache.Resolve(context.Background(), repo, v1)
+		require.NoError(t, err)
+
+		assert.Equal(t, v1, commit)
+
+		_, err = cache.Resolve(context.Background(), repo, "v1")
+		assert.Error(t, err)
+	})
+
+	t.Run("exports a clean tree", func(t *testing.T) {
+		pkg := load(t, "v1")
+
+		dest := filepath.Join(top, "build")
+		require.NoError(t, os.MkdirAll(dest, 0755))
+
+		var si ScriptInstall
+		si.pkg = pkg
+
+		err := si.setupInputGit(context.Background(), dest, pkg.cs.Inputs[0])
+		require.NoError(t, err)
+
+		data, err := ioutil.ReadFile(filepath.Join(dest, "source", "README"))
+		require.NoError(t, err)
+
+		assert.Equal(t, "version 1", string(data))
+
+		_, err = os.Stat(filepath.Join(dest, "source", ".git"))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("follows branches that have moved", func(t *testing.T) {
+		assert.Equal(t, v2, load(t, "trunk").cs.Inputs[0].Git.Commit)
+
+		v3 := commit(t, "v3", "version 3")
+
+		assert.Equal(t, v3, load(t, "trunk").cs.Inputs[0].Git.Commit)
+		assert.Equal(t, v3, load(t, "").cs.Inputs[0].Git.Commit)
+	})
+}
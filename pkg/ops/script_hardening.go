@@ -0,0 +1,57 @@
+package ops
+
+import (
+	"strings"
+
+	"github.com/lab47/exprcore/exprcore"
+	"github.com/pkg/errors"
+	"lab47.dev/aperture/pkg/cc"
+)
+
+var ErrBadHardening = errors.New("invalid hardening")
+
+// extractHardening reads the hardening attribute of a script. It's a list
+// of the hardening features of the cc wrapper to opt out of, such as
+// "-pie".
+func (s *ScriptCalcSig) extractHardening(val exprcore.Value) error {
+	l, ok := val.(*exprcore.List)
+	if !ok {
+		return errors.Wrapf(ErrBadHardening, "hardening must be a list, not %s", val.Type())
+	}
+
+	settings := listStrings(l)
+
+	_, err := cc.ResolveHardening(settings)
+	if err != nil {
+		return errors.Wrapf(ErrBadHardening, "%s", err)
+	}
+
+	if len(settings) > 0 {
+		s.Hardening = settings
+	}
+
+	return nil
+}
+
+// unsignedHardeningPolicy is the policy that packages which don't set
+// hardening are built with without it being in their signature. It keeps
+// the ids of those packages from changing until the policy is changed.
+const unsignedHardeningPolicy = "1"
+
+// hardeningSigData is the part of the package signature that covers the
+// hardening features. It's left out for packages that are built with the
+// default features of the unsigned policy.
+func (s *ScriptCalcSig) hardeningSigData() (string, error) {
+	features, err := cc.ResolveHardening(s.Hardening)
+	if err != nil {
+		return "", errors.Wrapf(ErrBadHardening, "%s", err)
+	}
+
+	sig := strings.Join(features, " ")
+
+	if cc.HardeningPolicy == unsignedHardeningPolicy && sig == strings.Join(cc.DefaultHardening, " ") {
+		return "", nil
+	}
+
+	return "policy " + cc.HardeningPolicy + ": " + sig, nil
+}
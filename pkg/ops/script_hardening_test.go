@@ -0,0 +1,72 @@
+package ops
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScriptHardening(t *testing.T) {
+	top, err := ioutil.TempDir("", "hardening")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(top)
+
+	load := func(t *testing.T, rev, attrs string) (*ScriptPackage, error) {
+		dir := filepath.Join(top, rev)
+
+		script := `
+def install(rc) {
+  rc.shell("make")
+}
+
+pkg(name: "zlib", version: "1.2.11", install: install` + attrs + `)
+`
+
+		writeScripts(t, dir, map[string]string{"zlib" + Extension: script})
+
+		return loadScript(dir, "zlib")
+	}
+
+	t.Run("reads the hardening settings", func(t *testing.T) {
+		pkg, err := load(t, "read", `, hardening: ["-pie", "-bindnow"]`)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"-pie", "-bindnow"}, pkg.cs.Hardening)
+	})
+
+	t.Run("includes the hardening in the signature", func(t *testing.T) {
+		plain, err := load(t, "plain", "")
+		require.NoError(t, err)
+
+		same, err := load(t, "same", `, hardening: ["pie"]`)
+		require.NoError(t, err)
+
+		nopie, err := load(t, "nopie", `, hardening: ["-pie"]`)
+		require.NoError(t, err)
+
+		// Packages built with the default features keep the ids they had
+		// before hardening was added.
+		assert.Equal(t, "4iKV1YQU9KGHFD9ujhrqky4bVrGjfCKpLfDHjLVYoGiN-zlib-1.2.11", plain.ID())
+		assert.Equal(t, "C1VgjJNaMArZqdK9V3kQA5hXDykWJAVjud6HY2hNGwWF-zlib-1.2.11", nopie.ID())
+
+		assert.Equal(t, "", plain.cs.sigData.Hardening)
+		assert.Equal(t, plain.ID(), same.ID())
+		assert.NotEqual(t, plain.ID(), nopie.ID())
+
+		diff := DiffSignatures(plain, nopie)
+		assert.Equal(t, []string{`hardening: "" => "policy 1: stackprotector fortify relro bindnow stackclash"`}, diff.Changes)
+	})
+
+	t.Run("rejects unknown features", func(t *testing.T) {
+		_, err := load(t, "unknown", `, hardening: ["-nx"]`)
+		assert.ErrorIs(t, err, ErrBadHardening)
+
+		_, err = load(t, "notlist", `, hardening: "-pie"`)
+		assert.ErrorIs(t, err, ErrBadHardening)
+	})
+}
@@ -0,0 +1,125 @@
+package ops
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHelperSignatures(t *testing.T) {
+	top, err := ioutil.TempDir("", "helpers")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(top)
+
+	tools := `
+pkg(name: "tools", version: "1.0")
+`
+
+	toolsExport := `
+def configure(rc, flags) {
+  rc.shell("./configure " + flags)
+}
+
+def unused(rc) {
+  rc.shell("unused")
+}
+`
+
+	plain := `
+import tools
+
+def install(rc) {
+  rc.shell("make")
+}
+
+pkg(name: "plain", version: "1.0", dependencies: [tools], install: install)
+`
+
+	user := `
+import tools
+
+def install(rc) {
+  tools.configure(rc, "--enable-foo")
+  rc.shell("make")
+}
+
+pkg(name: "user", version: "1.0", dependencies: [tools], install: install)
+`
+
+	load := func(t *testing.T, rev, name string, scripts map[string]string) *ScriptPackage {
+		dir := filepath.Join(top, rev)
+
+		writeScripts(t, dir, scripts)
+
+		pkg, err := loadScript(dir, name)
+		require.NoError(t, err)
+
+		return pkg
+	}
+
+	scripts := func(export string) map[string]string {
+		return map[string]string{
+			"tools.xcr":        tools,
+			"tools.export.xcr": export,
+			"plain.xcr":        plain,
+			"user.xcr":         user,
+		}
+	}
+
+	// The ids of these packages as calculated before helpers were included
+	// in signatures.
+	const (
+		toolsID = "3NJz8BKn3gectrsXSJS1Ljjb8ejDsKjxhVv6n3p3YFLA-tools-1.0"
+		plainID = "8nYxuyMgeefwTQ6WwD7eyneDY8fDksGNuRoKMZLJ1h4F-plain-1.0"
+		userID  = "JCsDPjPH7mMLgT3puNfkZyK8FMRotMP5Jd1MQNDiaNuL-user-1.0"
+	)
+
+	t.Run("keeps the ids of packages that don't call helpers", func(t *testing.T) {
+		assert.Equal(t, toolsID, load(t, "orig", "tools", scripts(toolsExport)).ID())
+		assert.Equal(t, plainID, load(t, "orig", "plain", scripts(toolsExport)).ID())
+	})
+
+	t.Run("changes the ids of packages that call helpers", func(t *testing.T) {
+		pkg := load(t, "orig", "user", scripts(toolsExport))
+
+		assert.NotEqual(t, userID, pkg.ID())
+		assert.Equal(t, "E2tzcpfNDazfKgtFbpwYGuL6frxzxLVHdTfwC6739T2y-user-1.0", pkg.ID())
+	})
+
+	t.Run("changes the id when a called helper changes", func(t *testing.T) {
+		export := strings.Replace(toolsExport,
+			`rc.shell("./configure " + flags)`,
+			`args = "./configure " + flags
+  rc.shell(args)`, 1)
+
+		orig := load(t, "orig", "user", scripts(toolsExport))
+		pkg := load(t, "called", "user", scripts(export))
+
+		// The commands run are the same, but the helper itself differs.
+		assert.Equal(t, orig.cs.installPlan, pkg.cs.installPlan)
+		assert.NotEqual(t, orig.ID(), pkg.ID())
+
+		diff := DiffSignatures(orig, pkg)
+		assert.Equal(t, []string{"helper tools.configure: code changed"}, diff.Changes)
+
+		assert.Equal(t, plainID, load(t, "called", "plain", scripts(export)).ID())
+	})
+
+	t.Run("keeps the id when an uncalled helper changes", func(t *testing.T) {
+		export := strings.Replace(toolsExport, `rc.shell("unused")`, `rc.shell("still unused")`, 1)
+
+		orig := load(t, "orig", "user", scripts(toolsExport))
+		pkg := load(t, "uncalled", "user", scripts(export))
+
+		assert.Equal(t, orig.ID(), pkg.ID())
+
+		assert.Equal(t, toolsID, load(t, "uncalled", "tools", scripts(export)).ID())
+		assert.Equal(t, plainID, load(t, "uncalled", "plain", scripts(export)).ID())
+	})
+}
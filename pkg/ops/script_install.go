@@ -29,6 +29,7 @@ import (
 	"github.com/mr-tron/base58"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/blake2b"
+	"lab47.dev/aperture/pkg/cc"
 	"lab47.dev/aperture/pkg/cleanhttp"
 	"lab47.dev/aperture/pkg/data"
 	"lab47.dev/aperture/pkg/evt"
@@ -191,13 +192,18 @@ func (i *ScriptInstall) setupInputDir(ui *UI, dir string, in ScriptInput) error
 	return inst.Install()
 }
 
-func (i *ScriptInstall) setupInputs(ui *UI, ienv *InstallEnv, dir string) error {
+func (i *ScriptInstall) setupInputs(ctx context.Context, ui *UI, ienv *InstallEnv, dir string) error {
 	for _, in := range i.pkg.cs.Inputs {
 		if in.Instance != nil {
 			err := i.setupInstance(ui, ienv, dir, in)
 			if err != nil {
 				return err
 			}
+		} else if in.Git != nil {
+			err := i.setupInputGit(ctx, dir, in)
+			if err != nil {
+				return err
+			}
 		} else if in.Data.dir != "" {
 			err := i.setupInputDir(ui, dir, in)
 			if err != nil {
@@ -214,6 +220,23 @@ func (i *ScriptInstall) setupInputs(ui *UI, ienv *InstallEnv, dir string) error
 	return nil
 }
 
+// SourceDateEpoch is the SOURCE_DATE_EPOCH builds run with, so that tools
+// which embed timestamps embed the same one every build. It's 1980-01-01,
+// the earliest time zip files can represent.
+const SourceDateEpoch = 315532800
+
+// CompDBPath returns where the cc wrapper records the compile commands run
+// while building the package id in buildDir.
+func CompDBPath(buildDir, id string) string {
+	return filepath.Join(buildDir, "compdb-"+id+".json")
+}
+
+// CCProbePath returns where the cc wrapper records which flags the
+// compilers used while building the package id in buildDir accept.
+func CCProbePath(buildDir, id string) string {
+	return filepath.Join(buildDir, "ccprobe-"+id+".json")
+}
+
 var allCCNames = strings.Fields(`
 c++
 c89
@@ -250,7 +273,44 @@ llvm_clang
 llvm_clang++
 `)
 
+// installOutput installs an output of a package by building the package
+// that produces it.
+func (i *ScriptInstall) installOutput(ctx context.Context, ienv *InstallEnv) error {
+	path := ienv.Store.ExpectedPath(i.pkg.ID())
+
+	if _, err := os.Stat(filepath.Join(path, ".pkg-info.json")); err == nil {
+		return nil
+	}
+
+	main := i.pkg.Main()
+
+	mainPath := ienv.Store.ExpectedPath(main.ID())
+
+	if _, err := os.Stat(filepath.Join(mainPath, ".pkg-info.json")); err == nil {
+		return fmt.Errorf(
+			"output '%s' is missing but %s is installed, remove %s to rebuild it",
+			i.pkg.Output(), main.ID(), mainPath)
+	}
+
+	si := &ScriptInstall{common: i.common, pkg: main}
+
+	err := si.Install(ctx, ienv)
+	if err != nil {
+		return err
+	}
+
+	if ienv.PackagePaths != nil {
+		ienv.PackagePaths[main.ID()] = mainPath
+	}
+
+	return nil
+}
+
 func (i *ScriptInstall) Install(ctx context.Context, ienv *InstallEnv) error {
+	if i.pkg.main != nil {
+		return i.installOutput(ctx, ienv)
+	}
+
 	var thread exprcore.Thread
 
 	log := i.L()
@@ -288,9 +348,19 @@ func (i *ScriptInstall) Install(ctx context.Context, ienv *InstallEnv) error {
 		}
 	}
 
+	// Only the commands of this build belong in the database, and the
+	// compilers are probed again in case they changed.
+	compDB := CompDBPath(ienv.BuildDir, i.pkg.ID())
+	ccProbe := CCProbePath(ienv.BuildDir, i.pkg.ID())
+
+	os.Remove(compDB)
+	os.Remove(ccProbe)
+
 	if !ienv.RetainBuild {
 		defer os.RemoveAll(buildDir)
 		defer os.RemoveAll(tmpDir)
+		defer os.Remove(compDB)
+		defer os.Remove(ccProbe)
 	}
 
 	err = os.Mkdir(targetDir, 0755)
@@ -314,7 +384,34 @@ func (i *ScriptInstall) Install(ctx context.Context, ienv *InstallEnv) error {
 		return err
 	}
 
-	err = i.setupInputs(ui, ienv, buildDir)
+	var outputs []*ScriptPackage
+
+	outputDirs := map[string]string{}
+
+	if !ienv.OnlyPostInstall {
+		for _, name := range i.pkg.OutputNames() {
+			op := i.pkg.OutputPackage(name)
+			dir := ienv.Store.ExpectedPath(op.ID())
+
+			if _, err := os.Stat(filepath.Join(dir, ".pkg-info.json")); err == nil {
+				// The output is already installed, so anything destined for it
+				// is discarded.
+				dir = filepath.Join(tmpDir, "output-"+name)
+			} else {
+				os.RemoveAll(dir)
+				outputs = append(outputs, op)
+			}
+
+			err = os.MkdirAll(dir, 0755)
+			if err != nil {
+				return err
+			}
+
+			outputDirs[name] = dir
+		}
+	}
+
+	err = i.setupInputs(ctx, ui, ienv, buildDir)
 	if err != nil {
 		return track(err)
 	}
@@ -381,6 +478,22 @@ func (i *ScriptInstall) Install(ctx context.Context, ienv *InstallEnv) error {
 	rc.topDir = buildDir
 	rc.stateDir = stateDir
 	rc.outputPrefix = i.pkg.Name()
+	rc.outputDirs = outputDirs
+
+	if ienv.LogDir != "" {
+		bl, err := OpenBuildLog(ienv.LogDir, i.pkg.Name(), i.pkg.ID())
+		if err != nil {
+			return errors.Wrapf(err, "opening build log")
+		}
+
+		defer func() {
+			if cerr := bl.Close(); cerr != nil {
+				log.Error("error writing build log", "error", cerr)
+			}
+		}()
+
+		rc.buildLog = bl
+	}
 
 	args := exprcore.Tuple{&rc}
 
@@ -400,6 +513,14 @@ func (i *ScriptInstall) Install(ctx context.Context, ienv *InstallEnv) error {
 		Prefix:       targetDir,
 		BuildDir:     buildDir,
 		Dependencies: make(map[string]*data.BuildInfoDependency),
+
+		SourceDateEpoch: SourceDateEpoch,
+		StrictRepro:     ienv.StrictRepro,
+	}
+
+	bi.Hardening, err = cc.ResolveHardening(i.pkg.cs.Hardening)
+	if err != nil {
+		return err
 	}
 
 	var scd ScriptCalcDeps
@@ -492,6 +613,9 @@ func (i *ScriptInstall) Install(ctx context.Context, ienv *InstallEnv) error {
 		}
 	}
 
+	pkgConfig := filepath.Join(buildBin, "pkg-config")
+	os.Symlink(iris, pkgConfig)
+
 	environ := []string{
 		"HOME=/nonexistant",
 		// readd buildBin here so that our above detection code doesn't
@@ -500,6 +624,10 @@ func (i *ScriptInstall) Install(ctx context.Context, ienv *InstallEnv) error {
 		"APERTURE_SHIM_PATH=" + buildBin,
 		"APERTURE_CC_LOG=" + filepath.Join(ienv.BuildDir, i.pkg.Name()+"-cc.log"),
 		"APERTURE_CC_CACHE=" + filepath.Join(ienv.BuildDir, "cache-"+i.pkg.Name()),
+		"APERTURE_CC_COMPDB=" + CompDBPath(ienv.BuildDir, i.pkg.ID()),
+		"APERTURE_CC_PROBE=" + CCProbePath(ienv.BuildDir, i.pkg.ID()),
+		"PKG_CONFIG=" + pkgConfig,
+		"SOURCE_DATE_EPOCH=" + strconv.Itoa(SourceDateEpoch),
 	}
 
 	if len(cflags) > 0 {
@@ -525,7 +653,15 @@ func (i *ScriptInstall) Install(ctx context.Context, ienv *InstallEnv) error {
 
 	environ = append(environ, "APERTURE_BUILD_INFO="+base64.StdEncoding.EncodeToString(data))
 
+	environ = append(environ, ienv.ExtraEnv...)
+
 	rc.extraEnv = environ
+	rc.cmakePrefix = cmakePrefix
+
+	rc.jobs = ienv.Jobs
+	if rc.jobs <= 0 {
+		rc.jobs = runtime.NumCPU()
+	}
 
 	ui.ListDepedencies(buildDeps)
 
@@ -596,49 +732,176 @@ func (i *ScriptInstall) Install(ctx context.Context, ienv *InstallEnv) error {
 			rc.installDir = targetDir
 			_, err = exprcore.Call(&thread, i.pkg.cs.Install, args, nil)
 		}
-	}
 
-	if err != nil {
-		log.Error("error running script install", "error", err)
-	} else {
+		if err == nil && ienv.RunCheck && i.pkg.cs.Check != nil {
+			log.Info("running package checks", "id", i.pkg.ID())
 
-		prep := func() {
-			// We still need to do this before making the .car file
-			var prc PackageRemoveCruft
-			prc.common = i.common
+			rc.buildLog.Begin("check")
 
-			perr := prc.RemoveCruft(targetDir)
-			if perr != nil {
-				log.Error("Error adjusting library names", "error", perr)
+			rc.installDir = targetDir
+			_, err = exprcore.Call(&thread, i.pkg.cs.Check, args, nil)
+			if err != nil {
+				err = errors.Wrapf(err, "check failed for %s", i.pkg.ID())
 			}
+		}
+	}
 
-			var pfp PackageFixPerms
-			pfp.common = i.common
+	// prep readies dir for freezing and export. It only fails when the link
+	// audit finds problems and StrictLinks is set, or the pkg-config check
+	// finds errors and StrictPkgConfig is set.
+	prep := func(pkg *ScriptPackage, dir string) error {
+		// We still need to do this before making the .car file
+		var prc PackageRemoveCruft
+		prc.common = i.common
 
-			perr = pfp.Fix(targetDir)
-			if perr != nil {
-				log.Error("Error adjusting permissions", "error", perr)
-			}
+		perr := prc.RemoveCruft(dir)
+		if perr != nil {
+			log.Error("Error adjusting library names", "error", perr)
+		}
 
-			var pan PackageAdjustNames
-			pan.common = i.common
+		var pfp PackageFixPerms
+		pfp.common = i.common
 
-			perr = pan.Adjust(targetDir)
-			if perr != nil {
-				log.Error("Error adjusting library names", "error", perr)
-			}
+		perr = pfp.Fix(dir)
+		if perr != nil {
+			log.Error("Error adjusting permissions", "error", perr)
+		}
 
-			if !ienv.OnlyPostInstall {
-				var pwi PackageWriteInfo
-				pwi.store = ienv.Store
+		var pan PackageAdjustNames
+		pan.common = i.common
 
-				_, perr = pwi.Write(i.pkg)
+		perr = pan.Adjust(dir)
+		if perr != nil {
+			log.Error("Error adjusting library names", "error", perr)
+		}
+
+		if ienv.OnlyPostInstall {
+			return nil
+		}
+
+		var psd PackageSplitDebug
+		psd.common = i.common
+		psd.store = ienv.Store
+
+		split, perr := psd.Split(pkg.ID(), dir)
+		if perr != nil {
+			log.Error("error splitting debug info", "error", perr)
+		} else if split > 0 {
+			log.Debug("split debug info", "id", pkg.ID(), "files", split)
+		}
+
+		var pla PackageLinkAudit
+		pla.common = i.common
+		pla.store = ienv.Store
+		pla.Allow = ienv.AllowLibraries
+
+		audit, perr := pla.Audit(pkg, dir)
+		if perr != nil {
+			log.Error("error auditing linked libraries", "error", perr)
+		} else {
+			ui.LinkAudit(pkg, audit)
+		}
+
+		var ppc PackagePkgConfigCheck
+		ppc.common = i.common
+		ppc.store = ienv.Store
+		ppc.buildDir = ienv.BuildDir
+
+		pcCheck, perr := ppc.Check(pkg, dir)
+		if perr != nil {
+			log.Error("error checking pkg-config files", "error", perr)
+		} else if pcCheck != nil {
+			ui.PkgConfigCheck(pkg, pcCheck)
+		}
+
+		// The package info marks the package as installed, so it's only
+		// written once the package passed the strict checks. Otherwise the
+		// next build would pick up the broken package. It's also removed in
+		// case the prep before post_install wrote it.
+		failStrict := func(err error) error {
+			os.Remove(filepath.Join(dir, ".pkg-info.json"))
+			return err
+		}
+
+		if ienv.StrictLinks && audit != nil && !audit.Clean() {
+			return failStrict(errors.Wrapf(ErrLinkAudit, "%s: %d unresolved, %d undeclared",
+				pkg.ID(), len(audit.Unresolved), len(audit.Undeclared)))
+		}
+
+		if ienv.StrictPkgConfig && pcCheck != nil && len(pcCheck.Errors) > 0 {
+			return failStrict(errors.Wrapf(ErrPkgConfigCheck, "%s: %d errors", pkg.ID(), len(pcCheck.Errors)))
+		}
+
+		var pwi PackageWriteInfo
+		pwi.store = ienv.Store
+		pwi.linkAudit = audit
+		pwi.pkgConfigCheck = pcCheck
+
+		_, perr = pwi.Write(pkg)
+		if perr != nil {
+			log.Error("error writing package info", "error", perr)
+		}
+
+		return nil
+	}
+
+	exportPkg := func(pkg *ScriptPackage, dir string) {
+		var ce CarExport
+		ce.cfg = ienv.Config
+
+		exported, perr := ce.Export(pkg, dir, ienv.ExportPath)
+		if perr != nil {
+			log.Error("error writing car file", "error", perr)
+		}
+
+		ienv.ExportedCars = append(ienv.ExportedCars, exported)
+
+		debug, perr := ce.ExportDebug(pkg, dir, ienv.ExportPath)
+		if perr != nil {
+			log.Error("error writing debug car file", "error", perr)
+		} else if debug != nil {
+			ienv.ExportedCars = append(ienv.ExportedCars, debug)
+		}
+	}
+
+	// Outputs are finished before post_install runs, since post_install only
+	// operates on the main prefix.
+	if err == nil && runInstall && len(outputDirs) > 0 {
+		var pso PackageSplitOutputs
+		pso.common = i.common
+
+		err = pso.Split(i.pkg, targetDir, outputDirs)
+		if err == nil {
+			for _, op := range outputs {
+				dir := outputDirs[op.Output()]
+
+				err = prep(op, dir)
+				if err != nil {
+					break
+				}
+
+				var sf StoreFreeze
+				sf.store = ienv.Store
+
+				perr := sf.Freeze(op.ID())
 				if perr != nil {
-					log.Error("error writing package info", "error", perr)
+					log.Error("error freezing store dir", "error", perr)
+				}
+
+				if export {
+					exportPkg(op, dir)
 				}
 			}
 		}
+	}
+
+	if err != nil {
+		log.Error("error running script install", "error", err)
 
+		if rc.buildLog != nil {
+			log.Error("build output saved", "path", rc.buildLog.Path())
+		}
+	} else {
 		var didExport bool
 
 		// If we're doing an export and the script has a post_install, then
@@ -646,22 +909,15 @@ func (i *ScriptInstall) Install(ctx context.Context, ienv *InstallEnv) error {
 		// prep again.
 		if export && runPost {
 			// We still need to do this before making the .car file
-			prep()
-
-			var ce CarExport
-			ce.cfg = ienv.Config
+			err = prep(i.pkg, targetDir)
+			if err == nil {
+				exportPkg(i.pkg, targetDir)
 
-			exported, perr := ce.Export(i.pkg, targetDir, ienv.ExportPath)
-			if perr != nil {
-				log.Error("error writing car file", "error", perr)
+				didExport = true
 			}
-
-			ienv.ExportedCars = append(ienv.ExportedCars, exported)
-
-			didExport = true
 		}
 
-		if runPost {
+		if err == nil && runPost {
 			log.Debug("executing post install")
 
 			rc.installDir = targetDir
@@ -670,9 +926,13 @@ func (i *ScriptInstall) Install(ctx context.Context, ienv *InstallEnv) error {
 
 		if err != nil {
 			log.Error("error running script post_install", "error", err)
-		} else {
-			prep()
 
+			if rc.buildLog != nil {
+				log.Error("build output saved", "path", rc.buildLog.Path())
+			}
+		} else if err = prep(i.pkg, targetDir); err != nil {
+			log.Error("error preparing package", "error", err)
+		} else {
 			var sf StoreFreeze
 			sf.store = ienv.Store
 
@@ -682,15 +942,7 @@ func (i *ScriptInstall) Install(ctx context.Context, ienv *InstallEnv) error {
 			}
 
 			if export && !didExport {
-				var ce CarExport
-				ce.cfg = ienv.Config
-
-				exported, perr := ce.Export(i.pkg, targetDir, ienv.ExportPath)
-				if perr != nil {
-					log.Error("error writing car file", "error", perr)
-				}
-
-				ienv.ExportedCars = append(ienv.ExportedCars, exported)
+				exportPkg(i.pkg, targetDir)
 			}
 		}
 	}
@@ -730,6 +982,18 @@ type RunCtx struct {
 
 	outputPrefix string
 
+	// Records the output of commands, may be nil
+	buildLog *BuildLog
+
+	// Maps output names to the directories to install them into
+	outputDirs map[string]string
+
+	// The number of jobs build tools may run in parallel
+	jobs int
+
+	// The store directories of the dependencies, for build tools to search
+	cmakePrefix []string
+
 	attrs exprcore.StringDict
 
 	top *evt.Statements
@@ -824,6 +1088,11 @@ var RunCtxFunctions = exprcore.StringDict{
 	"download":      exprcore.NewBuiltin("download", downloadFn),
 	"unpack":        exprcore.NewBuiltin("unpack", unpackFn),
 	"set_shebang":   exprcore.NewBuiltin("set_shebang", setShebangFn),
+	"wrap_program":  exprcore.NewBuiltin("wrap_program", wrapProgramFn),
+	"autotools":     exprcore.NewBuiltin("autotools", autotoolsFn),
+	"cmake":         exprcore.NewBuiltin("cmake", cmakeFn),
+	"meson":         exprcore.NewBuiltin("meson", mesonFn),
+	"make":          exprcore.NewBuiltin("make", makeFn),
 }
 
 func addHash(rc *RunCtx, parts ...interface{}) (exprcore.Value, error) {
@@ -1021,6 +1290,7 @@ func runCmd(env *RunCtx, cmd *exec.Cmd) error {
 			line, err := br.ReadString('\n')
 			if len(line) > 0 {
 				fmt.Printf("%s %s\n", header, strings.TrimRight(line, " \n\t"))
+				env.buildLog.Line(line)
 			}
 
 			if err != nil {
@@ -1037,6 +1307,7 @@ func runCmd(env *RunCtx, cmd *exec.Cmd) error {
 			line, err := br.ReadString('\n')
 			if len(line) > 0 {
 				fmt.Printf("%s %s\n", header, strings.TrimRight(line, " \n\t"))
+				env.buildLog.Line(line)
 			}
 
 			if err != nil {
@@ -1047,6 +1318,7 @@ func runCmd(env *RunCtx, cmd *exec.Cmd) error {
 
 	err = cmd.Start()
 	if err != nil {
+		env.buildLog.Failed(err)
 		return err
 	}
 
@@ -1054,6 +1326,7 @@ func runCmd(env *RunCtx, cmd *exec.Cmd) error {
 
 	err = cmd.Wait()
 	if err != nil {
+		env.buildLog.Failed(err)
 		return err
 	}
 
@@ -1084,6 +1357,8 @@ func shellFn(thread *exprcore.Thread, b *exprcore.Builtin, args exprcore.Tuple,
 	cmd.Env = env.extraEnv
 	cmd.Dir = env.buildDir
 
+	env.buildLog.Begin("shell: " + code)
+
 	err := runCmd(env, cmd)
 	if err != nil {
 		return nil, err
@@ -1116,6 +1391,8 @@ func patchFn(thread *exprcore.Thread, b *exprcore.Builtin, args exprcore.Tuple,
 	cmd.Env = env.extraEnv
 	cmd.Dir = env.buildDir
 
+	env.buildLog.Begin("apply_patch")
+
 	err := runCmd(env, cmd)
 	if err != nil {
 		return nil, err
@@ -1198,6 +1475,8 @@ func systemFn(thread *exprcore.Thread, b *exprcore.Builtin, args exprcore.Tuple,
 	cmd.Env = env.extraEnv
 	cmd.Dir = filepath.Join(env.buildDir, dir)
 
+	env.buildLog.Begin("system: " + joinQuote(segments, " ") + " (in " + cmd.Dir + ")")
+
 	err = runCmd(env, cmd)
 	if err != nil {
 		return nil, err
@@ -1814,6 +2093,7 @@ func installFn(thread *exprcore.Thread, b *exprcore.Builtin, args exprcore.Tuple
 	var (
 		target, pattern string
 		symlink         bool
+		output          string
 	)
 
 	err := exprcore.UnpackArgs(
@@ -1821,6 +2101,7 @@ func installFn(thread *exprcore.Thread, b *exprcore.Builtin, args exprcore.Tuple
 		"target", &target,
 		"pattern", &pattern,
 		"symlink?", &symlink,
+		"output?", &output,
 	)
 
 	if err != nil {
@@ -1833,11 +2114,31 @@ func installFn(thread *exprcore.Thread, b *exprcore.Builtin, args exprcore.Tuple
 	}
 
 	if env.h != nil {
+		if output != "" {
+			return addHash(env, "install", "target", target, "pattern", pattern, "symlink", symlink, "output", output)
+		}
+
 		return addHash(env, "install", "target", target, "pattern", pattern, "symlink", symlink)
 	}
 
 	pattern = env.workPath(pattern)
-	target = env.workPath(target)
+
+	if output != "" {
+		dir, ok := env.outputDirs[output]
+		if !ok {
+			return exprcore.None, errors.Wrapf(ErrBadOutput, "unknown output: %s", output)
+		}
+
+		// Paths within the main prefix are moved to the same place within
+		// the output, other relative paths are relative to the output.
+		if rel, err := filepath.Rel(env.installDir, target); err == nil && !strings.HasPrefix(rel, "..") {
+			target = filepath.Join(dir, rel)
+		} else if !filepath.IsAbs(target) {
+			target = filepath.Join(dir, target)
+		}
+	} else {
+		target = env.workPath(target)
+	}
 
 	var inst fileutils.Install
 	inst.Ctx = env.ctx
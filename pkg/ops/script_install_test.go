@@ -7,7 +7,9 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"lab47.dev/aperture/pkg/config"
 )
 
 func TestScriptInstall(t *testing.T) {
@@ -16,45 +18,110 @@ func TestScriptInstall(t *testing.T) {
 
 	defer os.RemoveAll(top)
 
-	t.Run("executes the install function", func(t *testing.T) {
+	load := func(t *testing.T, dir, name string) *ScriptPackage {
 		var lookup ScriptLookup
-		lookup.Path = []string{"./testdata/script_install"}
+		lookup.Path = []string{dir}
 
 		var sl ScriptLoad
 		sl.lookup = &lookup
 
-		pkg, err := sl.Load("touch")
+		pkg, err := sl.Load(name)
 		require.NoError(t, err)
 
-		target := filepath.Join(top, "in")
+		return pkg
+	}
 
-		err = os.Mkdir(target, 0755)
+	// install builds pkg into a new store, returning the store directory.
+	install := func(t *testing.T, pkg *ScriptPackage, ienv *InstallEnv) (string, error) {
+		target, err := ioutil.TempDir(top, "store")
 		require.NoError(t, err)
 
-		defer os.RemoveAll(target)
-
-		build := filepath.Join(top, "build")
-
-		err = os.Mkdir(build, 0755)
+		build, err := ioutil.TempDir(top, "build")
 		require.NoError(t, err)
 
-		defer os.RemoveAll(build)
-
-		ienv := &InstallEnv{
-			BuildDir: build,
-			StoreDir: target,
-		}
+		ienv.BuildDir = build
+		ienv.Store = &config.Store{Paths: []string{target}, Default: target}
 
 		si := &ScriptInstall{
 			pkg: pkg,
 		}
 
-		ctx := context.Background()
+		return target, si.Install(context.Background(), ienv)
+	}
 
-		err = si.Install(ctx, ienv)
+	t.Run("executes the install function", func(t *testing.T) {
+		pkg := load(t, "./testdata/script_install", "touch")
+
+		target, err := install(t, pkg, &InstallEnv{})
 		require.NoError(t, err)
 
 		_, err = os.Stat(filepath.Join(target, pkg.ID(), "flag"))
 		require.NoError(t, err)
 	})
+
+	t.Run("removes the files of the cc wrapper with the build", func(t *testing.T) {
+		pkg := load(t, "./testdata/script_install", "probe")
+
+		ienv := &InstallEnv{}
+
+		_, err := install(t, pkg, ienv)
+		require.NoError(t, err)
+
+		_, err = os.Stat(CCProbePath(ienv.BuildDir, pkg.ID()))
+		assert.True(t, os.IsNotExist(err))
+
+		_, err = os.Stat(CompDBPath(ienv.BuildDir, pkg.ID()))
+		assert.True(t, os.IsNotExist(err))
+
+		ienv = &InstallEnv{RetainBuild: true}
+
+		_, err = install(t, pkg, ienv)
+		require.NoError(t, err)
+
+		_, err = os.Stat(CCProbePath(ienv.BuildDir, pkg.ID()))
+		assert.NoError(t, err)
+
+		_, err = os.Stat(CompDBPath(ienv.BuildDir, pkg.ID()))
+		assert.NoError(t, err)
+	})
+
+	t.Run("only runs check when asked to", func(t *testing.T) {
+		pkg := load(t, "./testdata/script_install", "checked")
+
+		target, err := install(t, pkg, &InstallEnv{})
+		require.NoError(t, err)
+
+		_, err = os.Stat(filepath.Join(target, pkg.ID(), "checked"))
+		assert.True(t, os.IsNotExist(err))
+
+		target, err = install(t, pkg, &InstallEnv{RunCheck: true})
+		require.NoError(t, err)
+
+		_, err = os.Stat(filepath.Join(target, pkg.ID(), "checked"))
+		assert.NoError(t, err)
+	})
+
+	t.Run("does not export packages that fail their check", func(t *testing.T) {
+		export := filepath.Join(top, "export")
+		pkg := load(t, "./testdata/script_install", "failcheck")
+
+		require.NoError(t, os.MkdirAll(export, 0755))
+
+		target, err := install(t, pkg, &InstallEnv{RunCheck: true, ExportPath: export})
+		assert.Error(t, err)
+
+		cars, err := ioutil.ReadDir(export)
+		require.NoError(t, err)
+		assert.Empty(t, cars)
+
+		_, err = os.Stat(filepath.Join(target, pkg.ID(), ".pkg-info.json"))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("leaves check out of the id", func(t *testing.T) {
+		checked := load(t, "./testdata/script_install", "checked")
+		unchecked := load(t, "./testdata/script_install_nocheck", "checked")
+
+		assert.Equal(t, unchecked.ID(), checked.ID())
+	})
 }
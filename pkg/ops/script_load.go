@@ -26,6 +26,9 @@ type ScriptLoad struct {
 
 	Store *config.Store
 
+	// Used to fetch the repositories of git inputs.
+	GitCache *config.GitCache
+
 	lookup *ScriptLookup
 	cfg    *Config
 
@@ -72,8 +75,7 @@ type ScriptPackage struct {
 
 	cs ScriptCalcSig
 
-	helpers    exprcore.StringDict
-	helpersSum []byte
+	helpers exprcore.StringDict
 
 	constraints map[string]string
 
@@ -84,6 +86,15 @@ type ScriptPackage struct {
 	repoConfig repo.Repo
 
 	vendor string
+
+	// Set when this package is one of the outputs of main
+	output string
+	main   *ScriptPackage
+
+	outputs map[string]*ScriptPackage
+
+	// Overrides the priority of the script when set by the project
+	priority *int
 }
 
 func (s *ScriptPackage) Name() string {
@@ -176,6 +187,26 @@ func (s *ScriptPackage) Dependencies() []*ScriptPackage {
 	return slice
 }
 
+// Priority returns the priority of the package when it's linked into a
+// profile. The package with the highest priority provides any file that
+// other packages in the profile provide as well.
+func (s *ScriptPackage) Priority() int {
+	if s.priority != nil {
+		return *s.priority
+	}
+
+	if s.main != nil {
+		return s.main.Priority()
+	}
+
+	return s.cs.Priority
+}
+
+// SetPriority overrides the priority of the package.
+func (s *ScriptPackage) SetPriority(priority int) {
+	s.priority = &priority
+}
+
 func (s *ScriptPackage) DependencyNames() []string {
 	var out []string
 
@@ -298,6 +329,7 @@ func (s *ScriptLoad) Search(query string, opts ...Option) ([]*ScriptPackage, err
 			"fmt":      exprcore.NewBuiltin("fmt", fmtFn),
 			"basename": exprcore.NewBuiltin("basename", basenameFn),
 			"fetch":    exprcore.NewBuiltin("fetch", s.fetchFn),
+			"git":      exprcore.NewBuiltin("git", s.gitFn),
 			"sys":      sysobj,
 			"platform": &platform{},
 		}
@@ -358,7 +390,7 @@ func (s *ScriptLoad) Search(query string, opts ...Option) ([]*ScriptPackage, err
 
 		sp.cs.common.logger = s.common.logger
 
-		sig, id, err := sp.cs.Calculate(ppkg, sp.helpersSum, lc.constraints)
+		sig, id, err := sp.cs.Calculate(ppkg, lc.constraints)
 		if err != nil {
 			return err
 		}
@@ -478,6 +510,7 @@ func (s *ScriptLoad) Load(name string, opts ...Option) (*ScriptPackage, error) {
 		"fmt":      exprcore.NewBuiltin("fmt", fmtFn),
 		"basename": exprcore.NewBuiltin("basename", basenameFn),
 		"fetch":    exprcore.NewBuiltin("fetch", s.fetchFn),
+		"git":      exprcore.NewBuiltin("git", s.gitFn),
 		"sys":      sysobj,
 		"platform": &platform{},
 	}
@@ -548,7 +581,7 @@ func (s *ScriptLoad) Load(name string, opts ...Option) (*ScriptPackage, error) {
 
 	sp.cs.common.logger = s.common.logger
 
-	sig, id, err := sp.cs.Calculate(ppkg, sp.helpersSum, lc.constraints)
+	sig, id, err := sp.cs.Calculate(ppkg, lc.constraints)
 	if err != nil {
 		return nil, err
 	}
@@ -569,7 +602,7 @@ func ProcessPrototype(pkgval exprcore.Value, constraints map[string]string) (*Sc
 		prototype: ppkg,
 	}
 
-	sig, id, err := sp.cs.Calculate(ppkg, nil, constraints)
+	sig, id, err := sp.cs.Calculate(ppkg, constraints)
 	if err != nil {
 		return nil, err
 	}
@@ -1051,31 +1084,6 @@ func (l *ScriptLoad) loadHelpers(s *ScriptPackage, lctx *loadContext, name strin
 
 	s.helpers = gbls
 
-	var keys []string
-
-	for k := range gbls {
-		keys = append(keys, k)
-	}
-
-	sort.Strings(keys)
-
-	h, _ := blake2b.New256(nil)
-
-	for _, k := range keys {
-		v := gbls[k]
-
-		if fn, ok := v.(*exprcore.Function); ok {
-			d, err := fn.HashCode()
-			if err != nil {
-				return err
-			}
-
-			h.Write(d)
-		}
-	}
-
-	s.helpersSum = h.Sum(nil)
-
 	return nil
 }
 
@@ -1094,22 +1102,31 @@ func (s *ScriptPackage) Attr(name string) (exprcore.Value, error) {
 		return exprcore.String(path), nil
 	}
 
-	if s.helpers == nil {
-		return nil, nil
+	// Helpers take precedence over outputs so that adding an output to a
+	// package doesn't change what existing references to it resolve to.
+	if val, ok := s.helpers[name]; ok {
+		return val, nil
 	}
 
-	val, ok := s.helpers[name]
-	if !ok {
-		return nil, nil
+	if op := s.OutputPackage(name); op != nil {
+		return op, nil
 	}
 
-	return val, nil
+	return nil, nil
 }
 
 func (s *ScriptPackage) AttrNames() []string {
-	if s.helpers == nil {
-		return nil
+	var names []string
+
+	if s.helpers != nil {
+		names = append(names, s.helpers.Keys()...)
+	}
+
+	for _, name := range s.OutputNames() {
+		if _, ok := s.helpers[name]; !ok {
+			names = append(names, name)
+		}
 	}
 
-	return s.helpers.Keys()
+	return names
 }
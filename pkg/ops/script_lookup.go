@@ -501,7 +501,7 @@ func (s *ScriptLookup) Load(name string) (ScriptData, error) {
 
 func (s *ScriptLookup) loadGeneric(p, name string) (ScriptData, error) {
 	switch {
-	case strings.HasPrefix(name, "./"):
+	case strings.HasPrefix(name, "./"), filepath.IsAbs(name):
 		r, err := s.LoadFile(name)
 		if err != nil {
 			return nil, err
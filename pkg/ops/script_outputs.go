@@ -0,0 +1,606 @@
+package ops
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lab47/exprcore/exprcore"
+	"github.com/mr-tron/base58"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/blake2b"
+	"lab47.dev/aperture/pkg/rpath"
+)
+
+// When a script lists an output without giving any patterns for it, these
+// are used to decide which files in the prefix are moved into the output.
+// Patterns are matched against paths relative to the prefix, a pattern
+// that matches a directory moves the whole directory.
+var DefaultOutputRules = map[string][]string{
+	"bin": {"bin", "sbin"},
+	"dev": {
+		"include", "lib/pkgconfig", "share/pkgconfig", "lib/cmake",
+		"share/aclocal", "lib/*.a", "lib/*.la",
+	},
+	"doc": {"share/doc", "share/man", "share/info", "share/gtk-doc"},
+	"lib": {"lib/*.so", "lib/*.so.*", "lib/*.dylib"},
+}
+
+// Outputs that are linked into a profile along with the package itself.
+var ProfileOutputs = []string{"bin", "doc"}
+
+var ErrBadOutput = errors.New("invalid output")
+
+func (s *ScriptCalcSig) extractOutputs(val exprcore.Value) error {
+	outputs := map[string][]string{}
+
+	addOutput := func(v exprcore.Value, patterns []string) error {
+		name, ok := v.(exprcore.String)
+		if !ok {
+			return errors.Wrapf(ErrBadOutput, "output name must be a string, not %s", v.Type())
+		}
+
+		switch string(name) {
+		case "", "prefix":
+			return errors.Wrapf(ErrBadOutput, "reserved output name: '%s'", name)
+		}
+
+		if patterns == nil {
+			patterns = DefaultOutputRules[string(name)]
+		}
+
+		outputs[string(name)] = patterns
+
+		return nil
+	}
+
+	switch v := val.(type) {
+	case *exprcore.List:
+		for i := 0; i < v.Len(); i++ {
+			err := addOutput(v.Index(i), nil)
+			if err != nil {
+				return err
+			}
+		}
+	case *exprcore.Dict:
+		for _, item := range v.Items() {
+			var patterns []string
+
+			switch pv := item[1].(type) {
+			case *exprcore.List:
+				patterns = []string{}
+
+				for i := 0; i < pv.Len(); i++ {
+					patterns = append(patterns, exprString(pv.Index(i)))
+				}
+			case exprcore.NoneType:
+				// use the defaults
+			default:
+				return errors.Wrapf(ErrBadOutput, "output patterns must be a list, not %s", pv.Type())
+			}
+
+			err := addOutput(item[0], patterns)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.Wrapf(ErrBadOutput, "outputs must be a list or dict, not %s", val.Type())
+	}
+
+	if len(outputs) > 0 {
+		s.Outputs = outputs
+	}
+
+	return nil
+}
+
+// outputSigData is the part of the package signature that covers the
+// outputs. Only the names and patterns matter, the id of each output is
+// derived from the main signature.
+func (s *ScriptCalcSig) outputSigData() map[string]string {
+	if len(s.Outputs) == 0 {
+		return nil
+	}
+
+	sd := map[string]string{}
+
+	for name, patterns := range s.Outputs {
+		sd[name] = strings.Join(patterns, "\x00")
+	}
+
+	return sd
+}
+
+// OutputSignature derives the signature of an output from the signature
+// of the package that produces it.
+func OutputSignature(sig, output string) string {
+	h, _ := blake2b.New256(nil)
+	h.Write([]byte(sig))
+	h.Write([]byte{0})
+	h.Write([]byte(output))
+
+	return base58.Encode(h.Sum(nil))
+}
+
+// Output returns the name of the output this package represents, or an
+// empty string if it's the main package.
+func (s *ScriptPackage) Output() string {
+	return s.output
+}
+
+// Main returns the package that builds this output. For the main package,
+// it returns itself.
+func (s *ScriptPackage) Main() *ScriptPackage {
+	if s.main != nil {
+		return s.main
+	}
+
+	return s
+}
+
+// OutputNames returns the sorted names of the extra outputs the package
+// declares.
+func (s *ScriptPackage) OutputNames() []string {
+	main := s.Main()
+
+	var names []string
+
+	for name := range main.cs.Outputs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// OutputPackage returns the package representing the named output, or
+// nil if there is no such output.
+func (s *ScriptPackage) OutputPackage(name string) *ScriptPackage {
+	main := s.Main()
+
+	if _, ok := main.cs.Outputs[name]; !ok {
+		return nil
+	}
+
+	if op, ok := main.outputs[name]; ok {
+		return op
+	}
+
+	sig := OutputSignature(main.sig, name)
+
+	op := &ScriptPackage{
+		loader:      main.loader,
+		requestName: main.requestName + "." + name,
+		id:          fmt.Sprintf("%s-%s-%s-%s", sig, main.Name(), main.Version(), name),
+		sig:         sig,
+		repo:        main.repo,
+		constraints: main.constraints,
+		repoConfig:  main.repoConfig,
+		vendor:      main.vendor,
+		output:      name,
+		main:        main,
+	}
+
+	op.cs.common = main.cs.common
+	op.cs.Name = main.cs.Name
+	op.cs.Version = main.cs.Version
+	op.cs.Description = main.cs.Description
+	op.cs.URL = main.cs.URL
+	op.cs.Metadata = main.cs.Metadata
+	op.cs.Dependencies = main.cs.Dependencies
+
+	// An output always needs the main package at runtime.
+	op.cs.ExplicitDeps = []*ScriptPackage{main}
+
+	if main.outputs == nil {
+		main.outputs = map[string]*ScriptPackage{}
+	}
+
+	main.outputs[name] = op
+
+	return op
+}
+
+// siblings returns all other packages that are built along side this one,
+// ie the main package and all of it's outputs.
+func (s *ScriptPackage) siblings() []*ScriptPackage {
+	main := s.Main()
+
+	var out []*ScriptPackage
+
+	if main != s {
+		out = append(out, main)
+	}
+
+	for _, name := range s.OutputNames() {
+		if op := main.OutputPackage(name); op != s {
+			out = append(out, op)
+		}
+	}
+
+	return out
+}
+
+// sameBuild indicates if a and b are produced by the same build.
+func sameBuild(a, b *ScriptPackage) bool {
+	return a.Main() == b.Main()
+}
+
+// withProfileOutputs adds any outputs of pkgs that should be linked into a
+// profile along with the package itself.
+func withProfileOutputs(pkgs []*ScriptPackage) []*ScriptPackage {
+	var out []*ScriptPackage
+
+	for _, pkg := range pkgs {
+		out = append(out, pkg)
+
+		if pkg.Output() != "" {
+			continue
+		}
+
+		for _, name := range ProfileOutputs {
+			if op := pkg.OutputPackage(name); op != nil {
+				out = append(out, op)
+			}
+		}
+	}
+
+	return out
+}
+
+// PackageSplitOutputs moves the files of a freshly installed package into
+// the store directories of its outputs.
+type PackageSplitOutputs struct {
+	common
+}
+
+// Split moves any files in dir that match the rules of the outputs into
+// the directories given by dirs, which maps output names to directories.
+func (p *PackageSplitOutputs) Split(pkg *ScriptPackage, dir string, dirs map[string]string) error {
+	names := pkg.OutputNames()
+
+	var moved []string
+
+	// Where each shared library that was moved out of dir ended up, by name.
+	libs := map[string]string{}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		if rel == "." {
+			return nil
+		}
+
+		for _, name := range names {
+			for _, pat := range pkg.cs.Outputs[name] {
+				ok, err := filepath.Match(pat, rel)
+				if err != nil {
+					return errors.Wrapf(err, "bad pattern for output %s", name)
+				}
+
+				if !ok {
+					continue
+				}
+
+				target := filepath.Join(dirs[name], rel)
+
+				p.L().Debug("moving into output", "output", name, "path", rel)
+
+				err = os.MkdirAll(filepath.Dir(target), 0755)
+				if err != nil {
+					return err
+				}
+
+				err = os.Rename(path, target)
+				if err != nil {
+					return err
+				}
+
+				moved = append(moved, filepath.Dir(path))
+
+				err = findSharedLibs(target, libs)
+				if err != nil {
+					return err
+				}
+
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// Clean up any directories that were emptied by moving files out.
+	sort.Sort(sort.Reverse(sort.StringSlice(moved)))
+
+	for _, path := range moved {
+		for path != dir && strings.HasPrefix(path, dir) {
+			if os.Remove(path) != nil {
+				break
+			}
+
+			path = filepath.Dir(path)
+		}
+	}
+
+	err = p.fixRunPath(dir, names, dirs, libs)
+	if err != nil {
+		return err
+	}
+
+	return p.fixPkgConfig(dir, names, dirs)
+}
+
+// findSharedLibs records the directory of each shared library at or below
+// path in libs.
+func findSharedLibs(path string, libs map[string]string) error {
+	return filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		name := info.Name()
+
+		if strings.HasSuffix(name, ".so") || strings.Contains(name, ".so.") {
+			libs[name] = filepath.Dir(path)
+		}
+
+		return nil
+	})
+}
+
+// fixRunPath adds the directories that shared libraries were moved into to
+// the RUNPATH of any ELF files in dir or the outputs that need them, so
+// that they're still found once they're no longer in the main prefix.
+func (p *PackageSplitOutputs) fixRunPath(dir string, names []string, dirs map[string]string, libs map[string]string) error {
+	if len(libs) == 0 {
+		return nil
+	}
+
+	for _, root := range append([]string{dir}, sortedDirs(names, dirs)...) {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !info.Mode().IsRegular() || !isELF(path) {
+				return nil
+			}
+
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			// Static executables and object files don't load anything.
+			e, err := rpath.NewEditor(b)
+			if err != nil {
+				return nil
+			}
+
+			runPath := e.RunPath()
+
+			var add []string
+
+			for _, lib := range e.Needed() {
+				libDir, ok := libs[lib]
+				if !ok {
+					continue
+				}
+
+				if !hasString(runPath, libDir) && !hasString(add, libDir) {
+					add = append(add, libDir)
+				}
+			}
+
+			if len(add) == 0 {
+				return nil
+			}
+
+			p.L().Debug("adding output libraries to runpath", "path", path, "dirs", add)
+
+			return rpath.EditFile(path, func(e *rpath.Editor) error {
+				e.SetRunPath(append(e.RunPath(), add...))
+				return nil
+			})
+		})
+
+		if err != nil {
+			return errors.Wrapf(err, "updating runpath in %s", root)
+		}
+	}
+
+	return nil
+}
+
+func hasString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The outputs that pkg-config variables should refer to once the files
+// they point to have been moved, in order of preference.
+var pcVarOutputs = map[string][]string{
+	"includedir": {"dev"},
+	"libdir":     {"lib", "dev"},
+}
+
+// The files a pkg-config variable refers to. A variable is only pointed at
+// an output if the output's copy of the directory holds some of them.
+var pcVarFiles = map[string][]string{
+	"includedir": {"*.h", "*.hh", "*.hpp", "*.hxx"},
+	"libdir":     {"lib*.so", "lib*.so.*", "*.a", "*.dylib"},
+}
+
+// holdsFiles indicates if dir, or any directory below it, contains a file
+// whose name matches one of patterns.
+func holdsFiles(dir string, patterns []string) bool {
+	var found bool
+
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+
+		for _, pat := range patterns {
+			if ok, _ := filepath.Match(pat, info.Name()); ok {
+				found = true
+				return errFound
+			}
+		}
+
+		return nil
+	})
+
+	return found
+}
+
+var errFound = errors.New("found")
+
+// fixPkgConfig rewrites the includedir and libdir of any pkg-config files
+// that point into the main prefix to point to the outputs that now contain
+// those files instead.
+func (p *PackageSplitOutputs) fixPkgConfig(dir string, names []string, dirs map[string]string) error {
+	var pcDirs []string
+
+	for _, root := range append([]string{dir}, sortedDirs(names, dirs)...) {
+		pcDirs = append(pcDirs,
+			filepath.Join(root, "lib", "pkgconfig"),
+			filepath.Join(root, "share", "pkgconfig"),
+		)
+	}
+
+	for _, pcDir := range pcDirs {
+		files, err := filepath.Glob(filepath.Join(pcDir, "*.pc"))
+		if err != nil {
+			return err
+		}
+
+		for _, path := range files {
+			err = p.fixPkgConfigFile(path, dir, dirs)
+			if err != nil {
+				return errors.Wrapf(err, "adjusting pkg-config file %s", path)
+			}
+		}
+	}
+
+	return nil
+}
+
+func sortedDirs(names []string, dirs map[string]string) []string {
+	var out []string
+
+	for _, name := range names {
+		out = append(out, dirs[name])
+	}
+
+	return out
+}
+
+func (p *PackageSplitOutputs) fixPkgConfigFile(path, dir string, dirs map[string]string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	vars := map[string]string{}
+
+	expand := func(s string) string {
+		return os.Expand(s, func(name string) string {
+			return vars[name]
+		})
+	}
+
+	var (
+		out     bytes.Buffer
+		changed bool
+	)
+
+	br := bufio.NewScanner(bytes.NewReader(data))
+
+	for br.Scan() {
+		line := br.Text()
+
+		eq := strings.IndexByte(line, '=')
+		col := strings.IndexByte(line, ':')
+
+		if eq == -1 || (col != -1 && col < eq) {
+			out.WriteString(line)
+			out.WriteByte('\n')
+			continue
+		}
+
+		name := strings.TrimSpace(line[:eq])
+		val := expand(strings.TrimSpace(line[eq+1:]))
+
+		vars[name] = val
+
+		rel, err := filepath.Rel(dir, val)
+
+		// Leave the variable alone if the files are still in the main prefix.
+		if err == nil && !strings.HasPrefix(rel, "..") && pcVarOutputs[name] != nil &&
+			!holdsFiles(val, pcVarFiles[name]) {
+			for _, output := range pcVarOutputs[name] {
+				od, ok := dirs[output]
+				if !ok {
+					continue
+				}
+
+				if !holdsFiles(filepath.Join(od, rel), pcVarFiles[name]) {
+					continue
+				}
+
+				vars[name] = filepath.Join(od, rel)
+				line = name + "=" + vars[name]
+				changed = true
+
+				break
+			}
+		}
+
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+
+	if !changed {
+		return nil
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, out.Bytes(), fi.Mode().Perm())
+}
@@ -0,0 +1,186 @@
+package ops
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lab47/exprcore/exprcore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScriptOutputs(t *testing.T) {
+	top, err := ioutil.TempDir("", "outputs")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(top)
+
+	newPkg := func() *ScriptPackage {
+		pkg := &ScriptPackage{
+			id:  "abcd-foo-1.0",
+			sig: "abcd",
+		}
+
+		pkg.cs.Name = "foo"
+		pkg.cs.Version = "1.0"
+		pkg.cs.Outputs = map[string][]string{
+			"dev": DefaultOutputRules["dev"],
+			"doc": DefaultOutputRules["doc"],
+		}
+
+		return pkg
+	}
+
+	writeFile := func(t *testing.T, path, data string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(data), 0644))
+	}
+
+	t.Run("derives output ids from the main signature", func(t *testing.T) {
+		pkg := newPkg()
+
+		dev := pkg.OutputPackage("dev")
+		require.NotNil(t, dev)
+
+		assert.Equal(t, "dev", dev.Output())
+		assert.Equal(t, pkg, dev.Main())
+		assert.Equal(t, OutputSignature("abcd", "dev"), dev.Signature())
+		assert.True(t, strings.HasSuffix(dev.ID(), "-foo-1.0-dev"))
+
+		assert.True(t, dev == pkg.OutputPackage("dev"))
+		assert.Nil(t, pkg.OutputPackage("lib"))
+
+		assert.Equal(t, []*ScriptPackage{pkg, pkg.OutputPackage("doc")}, dev.siblings())
+	})
+
+	t.Run("moves files matching the output rules", func(t *testing.T) {
+		pkg := newPkg()
+
+		dir := filepath.Join(top, "main")
+		devDir := filepath.Join(top, "dev")
+		docDir := filepath.Join(top, "doc")
+
+		writeFile(t, filepath.Join(dir, "bin", "foo"), "foo")
+		writeFile(t, filepath.Join(dir, "lib", "libfoo.so"), "lib")
+		writeFile(t, filepath.Join(dir, "lib", "libfoo.a"), "lib")
+		writeFile(t, filepath.Join(dir, "include", "foo.h"), "header")
+		writeFile(t, filepath.Join(dir, "share", "man", "man1", "foo.1"), "man")
+
+		writeFile(t, filepath.Join(dir, "lib", "pkgconfig", "foo.pc"),
+			"prefix="+dir+"\nincludedir=${prefix}/include\nlibdir=${prefix}/lib\n\nName: foo\nCflags: -I${includedir}\n")
+
+		var pso PackageSplitOutputs
+
+		err := pso.Split(pkg, dir, map[string]string{
+			"dev": devDir,
+			"doc": docDir,
+		})
+		require.NoError(t, err)
+
+		for _, path := range []string{"bin/foo", "lib/libfoo.so"} {
+			_, err = os.Stat(filepath.Join(dir, path))
+			assert.NoError(t, err, path)
+		}
+
+		for _, path := range []string{"include/foo.h", "lib/libfoo.a", "lib/pkgconfig/foo.pc"} {
+			_, err = os.Stat(filepath.Join(devDir, path))
+			assert.NoError(t, err, path)
+
+			_, err = os.Stat(filepath.Join(dir, path))
+			assert.True(t, os.IsNotExist(err), path)
+		}
+
+		_, err = os.Stat(filepath.Join(docDir, "share", "man", "man1", "foo.1"))
+		assert.NoError(t, err)
+
+		_, err = os.Stat(filepath.Join(dir, "share"))
+		assert.True(t, os.IsNotExist(err))
+
+		pc, err := ioutil.ReadFile(filepath.Join(devDir, "lib", "pkgconfig", "foo.pc"))
+		require.NoError(t, err)
+
+		assert.Contains(t, string(pc), "includedir="+filepath.Join(devDir, "include")+"\n")
+		assert.Contains(t, string(pc), "libdir=${prefix}/lib\n")
+		assert.Contains(t, string(pc), "Cflags: -I${includedir}\n")
+	})
+
+	t.Run("keeps executables working when libraries move", func(t *testing.T) {
+		if _, err := exec.LookPath("gcc"); err != nil {
+			t.Skip("gcc not available")
+		}
+
+		pkg := newPkg()
+		pkg.cs.Outputs = map[string][]string{
+			"dev": DefaultOutputRules["dev"],
+			"lib": DefaultOutputRules["lib"],
+		}
+
+		dir := filepath.Join(top, "exe", "main")
+		devDir := filepath.Join(top, "exe", "dev")
+		libDir := filepath.Join(top, "exe", "lib")
+
+		src := filepath.Join(top, "exe", "src")
+
+		writeFile(t, filepath.Join(src, "foo.c"), "int foo(void) { return 42; }\n")
+		writeFile(t, filepath.Join(src, "main.c"),
+			"#include <stdio.h>\nint foo(void);\nint main(void) { printf(\"%d\\n\", foo()); return 0; }\n")
+
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "lib"), 0755))
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "bin"), 0755))
+
+		gcc := func(args ...string) {
+			out, err := exec.Command("gcc", args...).CombinedOutput()
+			require.NoError(t, err, string(out))
+		}
+
+		gcc("-shared", "-fPIC", "-Wl,-soname,libfoo.so.1",
+			"-o", filepath.Join(dir, "lib", "libfoo.so.1"), filepath.Join(src, "foo.c"))
+		require.NoError(t, os.Symlink("libfoo.so.1", filepath.Join(dir, "lib", "libfoo.so")))
+
+		gcc("-o", filepath.Join(dir, "bin", "foo"), filepath.Join(src, "main.c"),
+			"-L"+filepath.Join(dir, "lib"), "-lfoo", "-Wl,-rpath,"+filepath.Join(dir, "lib"))
+
+		writeFile(t, filepath.Join(dir, "lib", "pkgconfig", "foo.pc"),
+			"prefix="+dir+"\nlibdir=${prefix}/lib\n\nName: foo\nLibs: -L${libdir} -lfoo\n")
+
+		var pso PackageSplitOutputs
+
+		err := pso.Split(pkg, dir, map[string]string{
+			"dev": devDir,
+			"lib": libDir,
+		})
+		require.NoError(t, err)
+
+		_, err = os.Stat(filepath.Join(libDir, "lib", "libfoo.so.1"))
+		require.NoError(t, err)
+
+		out, err := exec.Command(filepath.Join(dir, "bin", "foo")).CombinedOutput()
+		require.NoError(t, err, string(out))
+
+		assert.Equal(t, "42\n", string(out))
+
+		pc, err := ioutil.ReadFile(filepath.Join(devDir, "lib", "pkgconfig", "foo.pc"))
+		require.NoError(t, err)
+
+		assert.Contains(t, string(pc), "libdir="+filepath.Join(libDir, "lib")+"\n")
+	})
+
+	t.Run("prefers helpers over outputs", func(t *testing.T) {
+		pkg := newPkg()
+		pkg.helpers = exprcore.StringDict{"dev": exprcore.String("helper")}
+
+		val, err := pkg.Attr("dev")
+		require.NoError(t, err)
+		assert.Equal(t, exprcore.String("helper"), val)
+
+		val, err = pkg.Attr("doc")
+		require.NoError(t, err)
+		assert.Equal(t, pkg.OutputPackage("doc"), val)
+
+		assert.ElementsMatch(t, []string{"dev", "doc"}, pkg.AttrNames())
+	})
+}
@@ -0,0 +1,49 @@
+package ops
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScriptPriority(t *testing.T) {
+	top, err := ioutil.TempDir("", "priority")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(top)
+
+	load := func(t *testing.T, rev, attrs string) *ScriptPackage {
+		dir := filepath.Join(top, rev)
+
+		script := `pkg(name: "python", version: "3.9"` + attrs + `)`
+
+		writeScripts(t, dir, map[string]string{"python" + Extension: script})
+
+		pkg, err := loadScript(dir, "python")
+		require.NoError(t, err)
+
+		return pkg
+	}
+
+	t.Run("reads the priority of the script", func(t *testing.T) {
+		plain := load(t, "plain", "")
+		pkg := load(t, "priority", ", priority: 5")
+
+		assert.Equal(t, 0, plain.Priority())
+		assert.Equal(t, 5, pkg.Priority())
+
+		// It has no effect on the package itself.
+		assert.Equal(t, plain.ID(), pkg.ID())
+	})
+
+	t.Run("can be overridden by the project", func(t *testing.T) {
+		pkg := load(t, "override", ", priority: 5")
+		pkg.SetPriority(-1)
+
+		assert.Equal(t, -1, pkg.Priority())
+	})
+}
@@ -0,0 +1,303 @@
+package ops
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// SigDiff explains why the signature, and thus the id, of a package differs
+// between two versions of it.
+type SigDiff struct {
+	Name string
+	IDs  [2]string
+
+	// Changes to the simple fields of the signature, such as the version.
+	Changes []string
+
+	// The statements of install and post_install that were removed (prefixed
+	// with -) or added (prefixed with +).
+	Install     []string
+	PostInstall []string
+
+	// Dependencies that were removed (prefixed with -) or added (prefixed
+	// with +).
+	DepChanges []string
+
+	// Dependencies present in both versions, but whose id changed.
+	Deps []*SigDiff
+}
+
+// Same indicates if both versions of the package have the same signature.
+func (d *SigDiff) Same() bool {
+	return d.IDs[0] == d.IDs[1]
+}
+
+// DiffSignatures compares the data that went into the signatures of a
+// and b, recursing into any dependencies whose signatures also differ.
+func DiffSignatures(a, b *ScriptPackage) *SigDiff {
+	return diffSignatures(a.Main(), b.Main(), map[string]*SigDiff{})
+}
+
+func diffSignatures(a, b *ScriptPackage, seen map[string]*SigDiff) *SigDiff {
+	key := a.ID() + "\x00" + b.ID()
+
+	if d, ok := seen[key]; ok {
+		return d
+	}
+
+	d := &SigDiff{
+		Name: a.Name(),
+		IDs:  [2]string{a.ID(), b.ID()},
+	}
+
+	seen[key] = d
+
+	if d.Same() {
+		return d
+	}
+
+	sa, sb := a.cs.sigData, b.cs.sigData
+	if sa == nil || sb == nil {
+		d.Changes = append(d.Changes, "signature data unavailable")
+		return d
+	}
+
+	change := func(field, va, vb string) {
+		if va != vb {
+			d.Changes = append(d.Changes, fmt.Sprintf("%s: %q => %q", field, va, vb))
+		}
+	}
+
+	change("name", sa.Name, sb.Name)
+	change("version", sa.Version, sb.Version)
+
+	for _, k := range unionKeys(sa.Constraints, sb.Constraints) {
+		change("constraint "+k, sa.Constraints[k], sb.Constraints[k])
+	}
+
+	for _, k := range unionKeys(sa.Outputs, sb.Outputs) {
+		change("output "+k,
+			strings.Replace(sa.Outputs[k], "\x00", " ", -1),
+			strings.Replace(sb.Outputs[k], "\x00", " ", -1),
+		)
+	}
+
+	for _, k := range unionKeys(sa.Environment, sb.Environment) {
+		change("environment "+k, sa.Environment[k], sb.Environment[k])
+	}
+
+	change("hardening", sa.Hardening, sb.Hardening)
+
+	for _, k := range unionKeys(sa.Sources, sb.Sources) {
+		change("input "+k, sa.Sources[k], sb.Sources[k])
+	}
+
+	for _, k := range setDiff(sa.Instances, sb.Instances) {
+		d.Changes = append(d.Changes, "input "+k)
+	}
+
+	for _, k := range unionKeys(sa.Helpers, sb.Helpers) {
+		ha, inA := sa.Helpers[k]
+		hb, inB := sb.Helpers[k]
+
+		switch {
+		case !inB:
+			d.Changes = append(d.Changes, "helper "+k+": no longer called")
+		case !inA:
+			d.Changes = append(d.Changes, "helper "+k+": now called")
+		case ha != hb:
+			d.Changes = append(d.Changes, "helper "+k+": code changed")
+		}
+	}
+
+	if sa.FuncSig != sb.FuncSig {
+		d.Install = diffLines(a.cs.installPlan, b.cs.installPlan)
+	}
+
+	if sa.PostSig != sb.PostSig {
+		d.PostInstall = diffLines(a.cs.postPlan, b.cs.postPlan)
+	}
+
+	depsA, namesA := sigDeps(a)
+	depsB, namesB := sigDeps(b)
+
+	for _, name := range unionKeys(namesA, namesB) {
+		da, inA := depsA[name]
+		db, inB := depsB[name]
+
+		switch {
+		case !inB:
+			d.DepChanges = append(d.DepChanges, "-"+da.ID())
+		case !inA:
+			d.DepChanges = append(d.DepChanges, "+"+db.ID())
+		case da.ID() != db.ID():
+			d.Deps = append(d.Deps, diffSignatures(da, db, seen))
+		}
+	}
+
+	return d
+}
+
+// sigDeps returns the dependencies that are part of the signature of pkg,
+// indexed by name, along with those names.
+func sigDeps(pkg *ScriptPackage) (map[string]*ScriptPackage, map[string]string) {
+	deps := map[string]*ScriptPackage{}
+	names := map[string]string{}
+
+	for _, list := range [][]*ScriptPackage{pkg.cs.Dependencies, pkg.cs.ExplicitDeps} {
+		for _, dep := range list {
+			name := dep.Name()
+			if dep.Output() != "" {
+				name += "." + dep.Output()
+			}
+
+			deps[name] = dep
+			names[name] = dep.ID()
+		}
+	}
+
+	return deps, names
+}
+
+// unionKeys returns the sorted keys present in either a or b.
+func unionKeys(a, b map[string]string) []string {
+	set := map[string]struct{}{}
+
+	for k := range a {
+		set[k] = struct{}{}
+	}
+
+	for k := range b {
+		set[k] = struct{}{}
+	}
+
+	var keys []string
+
+	for k := range set {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func setDiff(a, b map[string]struct{}) []string {
+	var out []string
+
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			out = append(out, "-"+k)
+		}
+	}
+
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			out = append(out, "+"+k)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i][1:] < out[j][1:]
+	})
+
+	return out
+}
+
+// diffLines returns the lines that must be removed from a (prefixed with -)
+// and added (prefixed with +) to produce b. Lines common to both are
+// omitted.
+func diffLines(a, b []string) []string {
+	// Standard longest common subsequence table.
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var out []string
+
+	i, j := 0, 0
+
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "-"+a[i])
+			i++
+		default:
+			out = append(out, "+"+b[j])
+			j++
+		}
+	}
+
+	for ; i < len(a); i++ {
+		out = append(out, "-"+a[i])
+	}
+
+	for ; j < len(b); j++ {
+		out = append(out, "+"+b[j])
+	}
+
+	return out
+}
+
+// Write outputs a human readable version of the diff to w.
+func (d *SigDiff) Write(w io.Writer) {
+	d.write(w, "", map[*SigDiff]bool{})
+}
+
+func (d *SigDiff) write(w io.Writer, indent string, shown map[*SigDiff]bool) {
+	if d.Same() {
+		fmt.Fprintf(w, "%s%s: unchanged (%s)\n", indent, d.Name, d.IDs[0])
+		return
+	}
+
+	fmt.Fprintf(w, "%s%s: %s => %s\n", indent, d.Name, d.IDs[0], d.IDs[1])
+
+	if shown[d] {
+		fmt.Fprintf(w, "%s  (explained above)\n", indent)
+		return
+	}
+
+	shown[d] = true
+
+	for _, c := range d.Changes {
+		fmt.Fprintf(w, "%s  %s\n", indent, c)
+	}
+
+	section := func(title string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+
+		fmt.Fprintf(w, "%s  %s:\n", indent, title)
+
+		for _, l := range lines {
+			fmt.Fprintf(w, "%s    %s\n", indent, l)
+		}
+	}
+
+	section("install", d.Install)
+	section("post_install", d.PostInstall)
+	section("dependencies", d.DepChanges)
+
+	for _, dep := range d.Deps {
+		dep.write(w, indent+"  ", shown)
+	}
+}
@@ -0,0 +1,112 @@
+package ops
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigDiff(t *testing.T) {
+	top, err := ioutil.TempDir("", "sigdiff")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(top)
+
+	load := func(t *testing.T, rev string, scripts map[string]string) *ScriptPackage {
+		dir := filepath.Join(top, rev)
+
+		files := map[string]string{}
+
+		for name, script := range scripts {
+			files[name+Extension] = script
+		}
+
+		writeScripts(t, dir, files)
+
+		pkg, err := loadScript(dir, "foo")
+		require.NoError(t, err)
+
+		return pkg
+	}
+
+	bar := `
+def install(rc) {
+  rc.shell("make bar")
+}
+
+pkg(name: "bar", version: "1.0", install: install)
+`
+
+	foo := `
+import bar
+
+def install(rc) {
+  rc.shell("./configure")
+  rc.shell("make")
+  rc.shell("make install")
+}
+
+pkg(name: "foo", version: "1.0", dependencies: [bar], install: install)
+`
+
+	t.Run("reports no changes for the same scripts", func(t *testing.T) {
+		a := load(t, "same-a", map[string]string{"foo": foo, "bar": bar})
+		b := load(t, "same-b", map[string]string{"foo": foo, "bar": bar})
+
+		diff := DiffSignatures(a, b)
+		assert.True(t, diff.Same())
+	})
+
+	t.Run("explains changes to the install function and dependencies", func(t *testing.T) {
+		a := load(t, "change-a", map[string]string{"foo": foo, "bar": bar})
+
+		b := load(t, "change-b", map[string]string{
+			"foo": `
+import bar
+
+def install(rc) {
+  rc.shell("./configure --enable-foo")
+  rc.shell("make")
+  rc.shell("make install")
+}
+
+pkg(name: "foo", version: "1.1", dependencies: [bar], install: install)
+`,
+			"bar": `
+def install(rc) {
+  rc.shell("make bar")
+  rc.shell("make install")
+}
+
+pkg(name: "bar", version: "1.0", install: install)
+`,
+		})
+
+		diff := DiffSignatures(a, b)
+		require.False(t, diff.Same())
+
+		assert.Equal(t, []string{`version: "1.0" => "1.1"`}, diff.Changes)
+
+		require.Len(t, diff.Install, 2)
+		assert.Contains(t, diff.Install[0], `-"shell" "./configure"`)
+		assert.Contains(t, diff.Install[1], `+"shell" "./configure --enable-foo"`)
+
+		require.Len(t, diff.Deps, 1)
+
+		dep := diff.Deps[0]
+		assert.Equal(t, "bar", dep.Name)
+		assert.Empty(t, dep.Changes)
+		require.Len(t, dep.Install, 1)
+		assert.Contains(t, dep.Install[0], `+"shell" "make install"`)
+
+		var buf bytes.Buffer
+		diff.Write(&buf)
+
+		assert.Contains(t, buf.String(), "  bar: "+a.Dependencies()[0].ID())
+	})
+}
@@ -1,108 +1,397 @@
 package ops
 
 import (
+	"bufio"
 	"bytes"
+	"debug/elf"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/pkg/errors"
 	"lab47.dev/aperture/pkg/config"
+	"lab47.dev/aperture/pkg/data"
 	"lab47.dev/aperture/pkg/pkgconfig"
+	"lab47.dev/aperture/pkg/rpath"
 )
 
+// StoreFindDeps figures out which of the dependencies of a package it still
+// refers to once installed. ELF files, scripts, pkg-config files and
+// symlinks are read for the references they actually make, other files are
+// scanned for store paths.
 type StoreFindDeps struct {
+	common
+
 	store *config.Store
 }
 
-func (s *StoreFindDeps) PruneDeps(id string, deps []*ScriptPackage) ([]*ScriptPackage, error) {
-	seen := make(map[string]struct{})
+// The ELF sections scanned for store paths compiled into a program.
+var elfDataSections = []string{".rodata", ".data"}
+
+type depFinder struct {
+	*StoreFindDeps
+
+	dir      string
+	prefixes []string
+
+	// The candidate deps, by signature
+	bySig map[string]*ScriptPackage
+
+	// The candidate deps, by the pkg-config names they provide
+	byPC map[string]*ScriptPackage
 
-	var trbuf bytes.Buffer
+	reasons map[string]*data.RuntimeDepReason
+}
 
-	path, err := s.store.Locate(id)
+// PruneDeps returns the packages in deps that the package id refers to,
+// along with why each was detected, keyed by id.
+func (s *StoreFindDeps) PruneDeps(id string, deps []*ScriptPackage) ([]*ScriptPackage, map[string]*data.RuntimeDepReason, error) {
+	dir, err := s.store.Locate(id)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	f := &depFinder{
+		StoreFindDeps: s,
+		dir:           dir,
+		bySig:         map[string]*ScriptPackage{},
+		byPC:          map[string]*ScriptPackage{},
+		reasons:       map[string]*data.RuntimeDepReason{},
+	}
+
+	for _, path := range s.store.Paths {
+		f.prefixes = append(f.prefixes, path+"/")
 	}
 
-	filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+	for _, sp := range deps {
+		f.bySig[sp.Signature()] = sp
+
+		subPath, err := s.store.Locate(sp.ID())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		configs, err := pkgconfig.LoadAll(subPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, cfg := range configs {
+			f.byPC[cfg.Id] = sp
+		}
+	}
+
+	err = filepath.Walk(dir, f.examine)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "finding runtime deps of %s", id)
+	}
+
+	var runtimeDeps []*ScriptPackage
+
+	for _, sp := range deps {
+		if _, ok := f.reasons[sp.ID()]; ok {
+			runtimeDeps = append(runtimeDeps, sp)
+		}
+	}
+
+	return runtimeDeps, f.reasons, nil
+}
+
+// add records that file refers to path, when path is in one of the
+// candidate deps.
+func (f *depFinder) add(kind, file, path, detail string) {
+	sp := f.owner(path)
+	if sp == nil {
+		return
+	}
+
+	f.addDep(sp, kind, file, detail)
+}
+
+func (f *depFinder) addDep(sp *ScriptPackage, kind, file, detail string) {
+	// A precise reason replaces one found by scanning.
+	if cur, ok := f.reasons[sp.ID()]; ok && (cur.Kind != data.DepReference || kind == data.DepReference) {
+		return
+	}
+
+	f.reasons[sp.ID()] = &data.RuntimeDepReason{
+		Kind:   kind,
+		File:   file,
+		Detail: detail,
+	}
+}
+
+// owner returns the candidate dep that path is inside of.
+func (f *depFinder) owner(path string) *ScriptPackage {
+	for _, prefix := range f.prefixes {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+
+		ent := path[len(prefix):]
+
+		if idx := strings.IndexByte(ent, '/'); idx != -1 {
+			ent = ent[:idx]
+		}
+
+		if idx := strings.IndexByte(ent, '-'); idx != -1 {
+			ent = ent[:idx]
+		}
+
+		return f.bySig[ent]
+	}
+
+	return nil
+}
+
+func (f *depFinder) examine(path string, info os.FileInfo, err error) error {
+	if err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(f.dir, path)
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
 		if err != nil {
 			return err
 		}
 
-		if !info.Mode().IsRegular() {
+		f.add(data.DepSymlink, rel, target, target)
+		return nil
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	// Don't scan any pkg-info files we might find.
+	if filepath.Base(path) == ".pkg-info.json" {
+		return nil
+	}
+
+	switch {
+	case isELF(path):
+		if f.examineELF(rel, path) {
 			return nil
 		}
-
-		// Don't scan any pkg-info files we might find.
-		if filepath.Base(path) == ".pkg-info.json" {
+	case filepath.Ext(path) == ".pc":
+		if f.examinePC(rel, path) {
 			return nil
 		}
+	default:
+		if f.examineScript(rel, path) {
+			return nil
+		}
+	}
+
+	return f.scan(rel, path)
+}
+
+// examineELF records the libraries and interpreter of a dynamically linked
+// ELF file, as well as any store paths in its data. It returns false if
+// the file isn't dynamically linked, so it needs to be scanned.
+func (f *depFinder) examineELF(rel, path string) bool {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	e, err := rpath.NewEditor(b)
+	if err != nil {
+		if !errors.Is(err, rpath.ErrNoDynamic) {
+			f.L().Debug("unable to read ELF file for runtime deps", "path", path, "error", err)
+		}
+
+		return false
+	}
+
+	if interp := e.Interpreter(); interp != "" {
+		f.add(data.DepInterpreter, rel, interp, interp)
+	}
+
+	var search []string
+
+	for _, ent := range e.RunPath() {
+		ent = strings.Replace(ent, "${ORIGIN}", filepath.Dir(path), -1)
+		ent = strings.Replace(ent, "$ORIGIN", filepath.Dir(path), -1)
+		search = append(search, ent)
+	}
+
+	for _, lib := range e.Needed() {
+		if found := findLibrary(lib, search); found != "" {
+			f.add(data.DepLinked, rel, found, lib)
+		}
+	}
+
+	ef, err := elf.NewFile(bytes.NewReader(b))
+	if err != nil {
+		return true
+	}
+
+	for _, name := range elfDataSections {
+		sect := ef.Section(name)
+		if sect == nil || sect.Type == elf.SHT_NOBITS {
+			continue
+		}
 
-		f, err := os.Open(path)
+		sd, err := sect.Data()
 		if err != nil {
-			return err
+			continue
 		}
 
-		defer f.Close()
+		for _, ref := range f.storeRefs(sd) {
+			f.add(data.DepReference, rel, ref, ref)
+		}
+	}
 
-		var writers []io.Writer
+	return true
+}
 
-		for _, path := range s.store.Paths {
-			var dr depDetect
-			dr.deps = seen
-			dr.file = path
-			dr.prefix = []byte(path + "/")
-			dr.buf = &trbuf
+// examinePC records the packages a pkg-config file requires and the store
+// paths in its variables and flags.
+func (f *depFinder) examinePC(rel, path string) bool {
+	cfg, err := pkgconfig.Load(path)
+	if err != nil {
+		return false
+	}
 
-			writers = append(writers, &dr)
+	reqs, err := pkgconfig.ParseRequires(append(cfg.Requires, cfg.Private...))
+	if err != nil {
+		return false
+	}
+
+	for _, req := range reqs {
+		if sp, ok := f.byPC[req.Name]; ok {
+			f.addDep(sp, data.DepPkgConfig, rel, req.Name)
 		}
+	}
 
-		io.Copy(io.MultiWriter(writers...), f)
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
 
-		return nil
-	})
+	for _, ref := range f.storeRefs(b) {
+		f.add(data.DepPkgConfig, rel, ref, ref)
+	}
 
-	refPkgs := map[string]struct{}{}
+	return true
+}
 
-	configs, err := pkgconfig.LoadAll(path)
-	if err == nil {
-		for _, cfg := range configs {
-			for _, sel := range cfg.Requires {
-				if strings.TrimSpace(sel) == "" {
-					continue
-				}
-				name := strings.Fields(sel)[0]
-				refPkgs[name] = struct{}{}
-			}
+// examineScript records the interpreter of a script and the store paths it
+// refers to, such as the program a wrapper script runs. It returns false
+// if the file isn't a script.
+func (f *depFinder) examineScript(rel, path string) bool {
+	r, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+
+	defer r.Close()
+
+	br := bufio.NewReader(r)
+
+	if magic, err := br.Peek(2); err != nil || string(magic) != "#!" {
+		return false
+	}
+
+	line, err := br.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false
+	}
+
+	if fields := strings.Fields(line[2:]); len(fields) > 0 {
+		interp := fields[0]
+
+		// #!/usr/bin/env prog names the real interpreter second.
+		if filepath.Base(interp) == "env" && len(fields) > 1 {
+			interp = fields[1]
 		}
+
+		f.add(data.DepInterpreter, rel, interp, interp)
 	}
 
-	var runtimeDeps []*ScriptPackage
+	rest, err := ioutil.ReadAll(br)
+	if err != nil {
+		return false
+	}
 
-	for _, sp := range deps {
-		if _, ok := seen[sp.Signature()]; ok {
-			runtimeDeps = append(runtimeDeps, sp)
-		} else {
-			// See if it's a pkg-config ref'd package
+	for _, ref := range f.storeRefs(rest) {
+		f.add(data.DepScript, rel, ref, ref)
+	}
 
-			subPath, err := s.store.Locate(sp.ID())
-			if err != nil {
-				return nil, err
-			}
+	return true
+}
 
-			configs, err := pkgconfig.LoadAll(subPath)
-			if err != nil {
-				return nil, err
+// scan finds references to deps in any other file by scanning it for store
+// paths.
+func (f *depFinder) scan(rel, path string) error {
+	r, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	var (
+		trbuf   bytes.Buffer
+		seen    = map[string]struct{}{}
+		writers []io.Writer
+	)
+
+	for _, prefix := range f.prefixes {
+		var dr depDetect
+		dr.deps = seen
+		dr.file = rel
+		dr.prefix = []byte(prefix)
+		dr.buf = &trbuf
+
+		writers = append(writers, &dr)
+	}
+
+	io.Copy(io.MultiWriter(writers...), r)
+
+	for sig := range seen {
+		if sp, ok := f.bySig[sig]; ok {
+			f.addDep(sp, data.DepReference, rel, "")
+		}
+	}
+
+	return nil
+}
+
+// storeRefs returns the store paths in b. Paths end at whitespace, quotes,
+// NUL or the separators used in search path variables.
+func (f *depFinder) storeRefs(b []byte) []string {
+	var refs []string
+
+	for _, prefix := range f.prefixes {
+		p := []byte(prefix)
+		rest := b
+
+		for {
+			idx := bytes.Index(rest, p)
+			if idx == -1 {
+				break
 			}
 
-			for _, cfg := range configs {
-				if _, ok := refPkgs[cfg.Id]; ok {
-					runtimeDeps = append(runtimeDeps, sp)
-				}
+			rest = rest[idx:]
+
+			end := bytes.IndexAny(rest, " \t\r\n\x00\"':;=()`")
+			if end == -1 {
+				end = len(rest)
 			}
+
+			refs = append(refs, string(rest[:end]))
+			rest = rest[end:]
 		}
 	}
 
-	return runtimeDeps, nil
+	return refs
 }
@@ -0,0 +1,139 @@
+package ops
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"lab47.dev/aperture/pkg/config"
+	"lab47.dev/aperture/pkg/data"
+	"lab47.dev/aperture/pkg/rpath"
+)
+
+func TestStoreFindDeps(t *testing.T) {
+	top, err := ioutil.TempDir("", "find-deps")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(top)
+
+	storeDir := filepath.Join(top, "store")
+
+	sfd := StoreFindDeps{
+		store: &config.Store{Paths: []string{storeDir}, Default: storeDir},
+	}
+
+	writeFile := func(t *testing.T, path string, data []byte) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, data, 0755))
+	}
+
+	var deps []*ScriptPackage
+
+	dep := func(t *testing.T, sig, name string) string {
+		sp := &ScriptPackage{id: sig + "-" + name + "-1.0", sig: sig}
+		deps = append(deps, sp)
+
+		dir := filepath.Join(storeDir, sp.id)
+		require.NoError(t, os.MkdirAll(dir, 0755))
+
+		return dir
+	}
+
+	fix, err := ioutil.ReadFile(filepath.Join("..", "rpath", "testdata", "libfix.so"))
+	require.NoError(t, err)
+
+	hello, err := ioutil.ReadFile(filepath.Join("..", "rpath", "testdata", "hello"))
+	require.NoError(t, err)
+
+	zlib := dep(t, "aaaa", "zlib")
+	writeFile(t, filepath.Join(zlib, "lib", "libfix.so"), fix)
+
+	unused := dep(t, "bbbb", "unused")
+
+	python := dep(t, "cccc", "python")
+	writeFile(t, filepath.Join(python, "bin", "python3"), []byte("python"))
+
+	ssl := dep(t, "dddd", "ssl")
+	writeFile(t, filepath.Join(ssl, "lib", "pkgconfig", "openssl.pc"), []byte("Name: openssl\n"))
+
+	certs := dep(t, "eeee", "certs")
+	wrapped := dep(t, "ffff", "wrapped")
+	linked := dep(t, "gggg", "linked")
+
+	id := "hhhh-pkg-1.0"
+	dir := filepath.Join(storeDir, id)
+
+	// RUNPATH names both zlib and unused, but only a library in zlib is
+	// needed.
+	writeFile(t, filepath.Join(dir, "bin", "hello"), hello)
+
+	err = rpath.EditFile(filepath.Join(dir, "bin", "hello"), func(e *rpath.Editor) error {
+		e.SetRunPath([]string{filepath.Join(unused, "lib"), filepath.Join(zlib, "lib")})
+		e.AddNeeded("libfix.so")
+		return nil
+	})
+	require.NoError(t, err)
+
+	writeFile(t, filepath.Join(dir, "bin", "tool"), []byte("#!"+filepath.Join(python, "bin", "python3")+"\nprint('hi')\n"))
+	writeFile(t, filepath.Join(dir, "bin", "wrapped"), programWrapper(filepath.Join(wrapped, "bin", "wrapped"), nil, nil, nil))
+	writeFile(t, filepath.Join(dir, "lib", "pkgconfig", "pkg.pc"), []byte("Name: pkg\nRequires: openssl >= 1.1\n"))
+	writeFile(t, filepath.Join(dir, "share", "certs.conf"), []byte("bundle "+filepath.Join(certs, "etc", "ca.pem")+"\n"))
+
+	require.NoError(t, os.Symlink(filepath.Join(linked, "bin", "linked"), filepath.Join(dir, "bin", "linked")))
+
+	// Not a runtime dep, only the package's own path is mentioned.
+	writeFile(t, filepath.Join(dir, "share", "self.conf"), []byte(dir+"\n"))
+
+	runtimeDeps, reasons, err := sfd.PruneDeps(id, deps)
+	require.NoError(t, err)
+
+	var ids []string
+
+	for _, sp := range runtimeDeps {
+		ids = append(ids, sp.ID())
+	}
+
+	assert.Equal(t, []string{
+		"aaaa-zlib-1.0",
+		"cccc-python-1.0",
+		"dddd-ssl-1.0",
+		"eeee-certs-1.0",
+		"ffff-wrapped-1.0",
+		"gggg-linked-1.0",
+	}, ids)
+
+	assert.Equal(t, map[string]*data.RuntimeDepReason{
+		"aaaa-zlib-1.0": {
+			Kind:   data.DepLinked,
+			File:   "bin/hello",
+			Detail: "libfix.so",
+		},
+		"cccc-python-1.0": {
+			Kind:   data.DepInterpreter,
+			File:   "bin/tool",
+			Detail: filepath.Join(python, "bin", "python3"),
+		},
+		"dddd-ssl-1.0": {
+			Kind:   data.DepPkgConfig,
+			File:   "lib/pkgconfig/pkg.pc",
+			Detail: "openssl",
+		},
+		"eeee-certs-1.0": {
+			Kind: data.DepReference,
+			File: "share/certs.conf",
+		},
+		"ffff-wrapped-1.0": {
+			Kind:   data.DepScript,
+			File:   "bin/wrapped",
+			Detail: filepath.Join(wrapped, "bin", "wrapped"),
+		},
+		"gggg-linked-1.0": {
+			Kind:   data.DepSymlink,
+			File:   "bin/linked",
+			Detail: filepath.Join(linked, "bin", "linked"),
+		},
+	}, reasons)
+}
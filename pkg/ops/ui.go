@@ -6,6 +6,7 @@ import (
 
 	"github.com/mr-tron/base58"
 	"lab47.dev/aperture/pkg/config"
+	"lab47.dev/aperture/pkg/data"
 )
 
 type UI struct {
@@ -52,6 +53,38 @@ func (u *UI) ListDepedencies(pkgs []*ScriptPackage) {
 	}
 }
 
+func (u *UI) LinkAudit(pkg *ScriptPackage, audit *data.LinkAudit) {
+	if audit.Clean() {
+		return
+	}
+
+	fmt.Printf("Library problems in %s:\n", pkg.ID())
+
+	for _, p := range audit.Unresolved {
+		fmt.Printf("  %s: %s not found\n", p.File, p.Library)
+	}
+
+	for _, p := range audit.Undeclared {
+		fmt.Printf("  %s: %s from undeclared %s\n", p.File, p.Library, p.Path)
+	}
+}
+
+func (u *UI) PkgConfigCheck(pkg *ScriptPackage, check *data.PkgConfigCheck) {
+	if check.Clean() {
+		return
+	}
+
+	fmt.Printf("pkg-config problems in %s:\n", pkg.ID())
+
+	for _, p := range check.Errors {
+		fmt.Printf("  error: %s: %s\n", p.File, p.Message)
+	}
+
+	for _, p := range check.Warnings {
+		fmt.Printf("  warning: %s: %s\n", p.File, p.Message)
+	}
+}
+
 type uiMarker struct{}
 
 func GetUI(ctx context.Context) *UI {
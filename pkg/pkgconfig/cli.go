@@ -0,0 +1,264 @@
+package pkgconfig
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// The version of pkg-config Main is compatible with.
+const Version = "0.29.2"
+
+// Main runs the pkg-config command line with args, resolving packages only
+// against the directories in path, a PKG_CONFIG_PATH value. It returns the
+// exit code.
+func Main(args []string, path string, stdout, stderr io.Writer) int {
+	fs := pflag.NewFlagSet("pkg-config", pflag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	// Flags pkg-config accepts that don't change the result here. Unknown
+	// flags are errors, rather than guessing whether they take a value.
+	for _, name := range []string{"debug", "define-prefix", "dont-define-prefix", "keep-system-cflags", "keep-system-libs", "uninstalled"} {
+		fs.Bool(name, false, "ignored")
+	}
+
+	var (
+		version         = fs.Bool("version", false, "print the pkg-config version")
+		atleastPC       = fs.String("atleast-pkgconfig-version", "", "require at least this pkg-config version")
+		modversion      = fs.Bool("modversion", false, "print the versions of the packages")
+		exists          = fs.Bool("exists", false, "check that the packages exist")
+		atleastVersion  = fs.String("atleast-version", "", "require at least this version of the packages")
+		exactVersion    = fs.String("exact-version", "", "require exactly this version of the packages")
+		maxVersion      = fs.String("max-version", "", "require at most this version of the packages")
+		cflags          = fs.Bool("cflags", false, "print the compiler flags")
+		cflagsOnlyI     = fs.Bool("cflags-only-I", false, "print the -I flags")
+		cflagsOnlyOther = fs.Bool("cflags-only-other", false, "print the compiler flags other than -I")
+		libs            = fs.Bool("libs", false, "print the linker flags")
+		libsOnlyL       = fs.Bool("libs-only-L", false, "print the -L flags")
+		libsOnlyl       = fs.Bool("libs-only-l", false, "print the -l flags")
+		libsOnlyOther   = fs.Bool("libs-only-other", false, "print the linker flags other than -L and -l")
+		static          = fs.Bool("static", false, "include the flags for static linking")
+		variable        = fs.String("variable", "", "print the value of a variable")
+		printVariables  = fs.Bool("print-variables", false, "print the names of the variables defined")
+		defineVariable  = fs.StringArray("define-variable", nil, "set a variable, as name=value, in every package")
+		printRequires   = fs.Bool("print-requires", false, "print the packages required")
+		printPrivate    = fs.Bool("print-requires-private", false, "print the packages required for static linking")
+		printErrors     = fs.Bool("print-errors", false, "print errors")
+		shortErrors     = fs.Bool("short-errors", false, "print short errors")
+		silenceErrors   = fs.Bool("silence-errors", false, "don't print errors")
+		errorsToStdout  = fs.Bool("errors-to-stdout", false, "print errors to stdout")
+	)
+
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	defines := map[string]string{}
+
+	for _, def := range *defineVariable {
+		eq := strings.IndexByte(def, '=')
+		if eq <= 0 {
+			fmt.Fprintf(stderr, "--define-variable argument does not have a value for the variable: %s\n", def)
+			return 1
+		}
+
+		defines[strings.TrimSpace(def[:eq])] = strings.TrimSpace(def[eq+1:])
+	}
+
+	if *version {
+		fmt.Fprintln(stdout, Version)
+		return 0
+	}
+
+	if *atleastPC != "" {
+		if CompareVersions(Version, *atleastPC) >= 0 {
+			return 0
+		}
+		return 1
+	}
+
+	if *errorsToStdout {
+		stderr = stdout
+	}
+
+	// Like pkg-config, only checking for packages is quiet by default.
+	quiet := *silenceErrors || (!*printErrors && (*exists || *atleastVersion != "" || *exactVersion != "" || *maxVersion != ""))
+
+	fail := func(err error) int {
+		if quiet {
+			return 1
+		}
+
+		var nf *NotFoundError
+
+		if !*shortErrors && errors.As(err, &nf) {
+			fmt.Fprintf(stderr, "Package %s was not found in the pkg-config search path.\n", nf.Name)
+			fmt.Fprintf(stderr, "Perhaps you should add the directory containing `%s.pc'\n", nf.Name)
+			fmt.Fprintf(stderr, "to the PKG_CONFIG_PATH environment variable\n")
+		}
+
+		fmt.Fprintln(stderr, err)
+
+		return 1
+	}
+
+	reqs, err := ParseRequires(fs.Args())
+	if err != nil {
+		return fail(err)
+	}
+
+	if len(reqs) == 0 {
+		return fail(fmt.Errorf("Must specify package names on the command line"))
+	}
+
+	for _, opt := range []struct{ op, version string }{
+		{">=", *atleastVersion},
+		{"=", *exactVersion},
+		{"<=", *maxVersion},
+	} {
+		if opt.version == "" {
+			continue
+		}
+
+		for _, req := range reqs {
+			req.Op = opt.op
+			req.Version = opt.version
+		}
+	}
+
+	r := &Resolver{
+		Path:    filepath.SplitList(path),
+		Static:  *static,
+		Defines: defines,
+	}
+
+	// Every package and private requirement has to be available, even if
+	// only the public flags are printed.
+	_, err = r.Resolve(reqs, true)
+	if err != nil {
+		return fail(err)
+	}
+
+	if *modversion {
+		for _, req := range reqs {
+			cfg, _ := r.Find(req.Name)
+			fmt.Fprintln(stdout, cfg.Version)
+		}
+
+		return 0
+	}
+
+	if *variable != "" {
+		cfg, _ := r.Find(reqs[0].Name)
+		fmt.Fprintln(stdout, cfg.Variables[*variable])
+
+		return 0
+	}
+
+	if *printVariables {
+		for _, req := range reqs {
+			cfg, _ := r.Find(req.Name)
+
+			var names []string
+
+			for name := range cfg.Variables {
+				names = append(names, name)
+			}
+
+			sort.Strings(names)
+
+			for _, name := range names {
+				fmt.Fprintln(stdout, name)
+			}
+		}
+
+		return 0
+	}
+
+	if *printRequires || *printPrivate {
+		for _, req := range reqs {
+			cfg, _ := r.Find(req.Name)
+
+			entries := cfg.Requires
+			if *printPrivate {
+				entries = cfg.Private
+			}
+
+			sub, err := ParseRequires(entries)
+			if err != nil {
+				return fail(err)
+			}
+
+			for _, s := range sub {
+				fmt.Fprintln(stdout, s)
+			}
+		}
+
+		return 0
+	}
+
+	var out []string
+
+	if *cflags || *cflagsOnlyI || *cflagsOnlyOther {
+		flags, err := r.Cflags(reqs)
+		if err != nil {
+			return fail(err)
+		}
+
+		for _, flag := range flags {
+			isI := strings.HasPrefix(flag, "-I")
+
+			if *cflags || (*cflagsOnlyI && isI) || (*cflagsOnlyOther && !isI) {
+				out = append(out, flag)
+			}
+		}
+	}
+
+	if *libs || *libsOnlyL || *libsOnlyl || *libsOnlyOther {
+		flags, err := r.Libs(reqs)
+		if err != nil {
+			return fail(err)
+		}
+
+		for _, flag := range flags {
+			isL := strings.HasPrefix(flag, "-L")
+			isl := strings.HasPrefix(flag, "-l")
+
+			if *libs || (*libsOnlyL && isL) || (*libsOnlyl && isl) || (*libsOnlyOther && !isL && !isl) {
+				out = append(out, flag)
+			}
+		}
+	}
+
+	if len(out) > 0 || *cflags || *libs {
+		for i, flag := range out {
+			out[i] = quoteFlag(flag)
+		}
+
+		fmt.Fprintln(stdout, strings.Join(out, " "))
+	}
+
+	return 0
+}
+
+// quoteFlag escapes the characters in flag a shell would interpret, so that
+// a command line the output is pasted into gets flag back.
+func quoteFlag(flag string) string {
+	var sb strings.Builder
+
+	for _, r := range flag {
+		if strings.ContainsRune(" \t\"'\\$`()&;<>|*?#~", r) {
+			sb.WriteByte('\\')
+		}
+
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
@@ -21,6 +21,9 @@ type Config struct {
 	Cflags      string
 	Libs        string
 	PrivLibs    string
+
+	// The variables defined in the file, after expansion
+	Variables map[string]string
 }
 
 func LoadAll(root string) ([]*Config, error) {
@@ -49,6 +52,14 @@ func LoadAll(root string) ([]*Config, error) {
 }
 
 func Load(path string) (*Config, error) {
+	return LoadDefined(path, nil)
+}
+
+// LoadDefined loads the file at path with the variables in defines set to
+// the values given, overriding the definitions in the file. Like
+// pkg-config's --define-variable, the overrides are used when expanding
+// the rest of the file.
+func LoadDefined(path string, defines map[string]string) (*Config, error) {
 	r, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -58,19 +69,33 @@ func Load(path string) (*Config, error) {
 
 	br := bufio.NewReader(r)
 
-	vars := map[string]string{}
+	// pcfiledir is predefined so that .pc files can refer to paths
+	// relative to themselves.
+	vars := map[string]string{
+		"pcfiledir": filepath.Dir(path),
+	}
+
+	for name, value := range defines {
+		vars[name] = value
+	}
 
 	var cfg Config
 
+	cfg.Path = path
 	cfg.Id = filepath.Base(path)
 	cfg.Id = cfg.Id[:len(cfg.Id)-3] // -3 to remove .pc
+	cfg.Variables = vars
 
 	for {
 		line, err := br.ReadString('\n')
-		if err != nil {
+		if err != nil && line == "" {
 			break
 		}
 
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
 		var (
 			name  string
 			value string
@@ -81,12 +106,12 @@ func Load(path string) (*Config, error) {
 		for i, b := range line {
 			switch b {
 			case '=':
-				name = line[:i]
+				name = strings.TrimSpace(line[:i])
 				value = strings.TrimSpace(line[i+1:])
 				isVar = true
 				break outer
 			case ':':
-				name = line[:i]
+				name = strings.TrimSpace(line[:i])
 				value = strings.TrimSpace(line[i+1:])
 				break outer
 			}
@@ -96,6 +121,11 @@ func Load(path string) (*Config, error) {
 			continue
 		}
 
+		if v, ok := defines[name]; ok && isVar {
+			vars[name] = v
+			continue
+		}
+
 		value = expand(value, vars)
 
 		if isVar {
@@ -1,6 +1,9 @@
 package pkgconfig
 
 import (
+	"bytes"
+	"errors"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -20,3 +23,238 @@ func TestLoadAll(t *testing.T) {
 	assert.Equal(t, "-I/this/is/a/prefix/include", cfg.Cflags)
 	assert.Equal(t, "-L/this/is/a/prefix/lib -lXau", cfg.Libs)
 }
+
+func TestCompareVersions(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		cmp  int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.0.0", -1},
+		{"1.10", "1.9", 1},
+		{"2.0", "10.0", -1},
+		{"1.01", "1.1", 0},
+		{"1.0a", "1.0", 1},
+		{"1.0", "1.0b", -1},
+		{"1.0.1", "1.0a", 1},
+		{"alpha", "beta", -1},
+	} {
+		assert.Equal(t, c.cmp, CompareVersions(c.a, c.b), "%s <=> %s", c.a, c.b)
+		assert.Equal(t, -c.cmp, CompareVersions(c.b, c.a), "%s <=> %s", c.b, c.a)
+	}
+}
+
+func TestParseRequires(t *testing.T) {
+	t.Run("splits on commas and spaces", func(t *testing.T) {
+		reqs, err := ParseRequires([]string{"glib-2.0 >= 2.50, gobject-2.0", " zlib x11>1.6"})
+		require.NoError(t, err)
+
+		assert.Equal(t, []*Requirement{
+			{Name: "glib-2.0", Op: ">=", Version: "2.50"},
+			{Name: "gobject-2.0"},
+			{Name: "zlib"},
+			{Name: "x11", Op: ">", Version: "1.6"},
+		}, reqs)
+	})
+
+	t.Run("checks versions", func(t *testing.T) {
+		req := &Requirement{Name: "a", Op: ">=", Version: "1.2"}
+
+		assert.True(t, req.Satisfied("1.10"))
+		assert.False(t, req.Satisfied("1.1.9"))
+
+		req = &Requirement{Name: "a", Op: "!=", Version: "1.2"}
+
+		assert.True(t, req.Satisfied("1.3"))
+		assert.False(t, req.Satisfied("1.2"))
+	})
+
+	t.Run("rejects bad operators", func(t *testing.T) {
+		_, err := ParseRequires([]string{">= 1.0"})
+		assert.Error(t, err)
+
+		_, err = ParseRequires([]string{"a => 1.0"})
+		assert.Error(t, err)
+
+		_, err = ParseRequires([]string{"a >="})
+		assert.Error(t, err)
+	})
+}
+
+func TestResolver(t *testing.T) {
+	dir, err := filepath.Abs(filepath.Join("testdata", "resolve"))
+	require.NoError(t, err)
+
+	reqs := []*Requirement{{Name: "a"}}
+
+	t.Run("loads files relative to themselves", func(t *testing.T) {
+		cfg, err := Load(filepath.Join(dir, "d.pc"))
+		require.NoError(t, err)
+
+		assert.Equal(t, dir+"/../..", cfg.Variables["prefix"])
+	})
+
+	t.Run("collects cflags of all requirements", func(t *testing.T) {
+		r := &Resolver{Path: []string{"/nonexistant", dir}}
+
+		flags, err := r.Cflags(reqs)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{
+			"-I/store/aaaa-a-1.2.0/include",
+			"-DA",
+			"-I/store/bbbb-b-2.1/include",
+			`-DD_NAME="d lib"`,
+			"-I/store/cccc-c-1.0/include",
+		}, flags)
+	})
+
+	t.Run("collects libs of public requirements", func(t *testing.T) {
+		r := &Resolver{Path: []string{dir}}
+
+		flags, err := r.Libs(reqs)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{
+			"-L/store/aaaa-a-1.2.0/lib",
+			"-L/store/bbbb-b-2.1/lib",
+			"-la",
+			"-lb",
+			"-ld",
+		}, flags)
+	})
+
+	t.Run("collects private libs when static", func(t *testing.T) {
+		r := &Resolver{Path: []string{dir}, Static: true}
+
+		flags, err := r.Libs(reqs)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{
+			"-L/store/aaaa-a-1.2.0/lib",
+			"-L/store/bbbb-b-2.1/lib",
+			"-L/store/cccc-c-1.0/lib",
+			"-la",
+			"-lm",
+			"-lb",
+			"-ld",
+			"-lc-priv",
+		}, flags)
+	})
+
+	t.Run("keeps libs after everything that uses them", func(t *testing.T) {
+		r := &Resolver{Path: []string{dir}}
+
+		flags, err := r.Libs([]*Requirement{{Name: "d"}, {Name: "b"}})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"-L/store/bbbb-b-2.1/lib", "-lb", "-ld"}, flags)
+	})
+
+	t.Run("reports missing packages and versions", func(t *testing.T) {
+		r := &Resolver{Path: []string{dir}}
+
+		var nf *NotFoundError
+
+		_, err := r.Resolve([]*Requirement{{Name: "e"}}, false)
+		require.True(t, errors.As(err, &nf))
+
+		assert.Equal(t, "missing", nf.Name)
+
+		var ve *VersionError
+
+		_, err = r.Resolve([]*Requirement{{Name: "a", Op: ">=", Version: "1.3"}}, false)
+		require.True(t, errors.As(err, &ve))
+
+		assert.Equal(t, "Requested 'a >= 1.3' but version of A is 1.2.0", ve.Error())
+	})
+}
+
+func TestMainCommand(t *testing.T) {
+	dir := filepath.Join("testdata", "resolve")
+
+	run := func(args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+
+		code := Main(args, "/nonexistant:"+dir, &stdout, &stderr)
+
+		return code, stdout.String(), stderr.String()
+	}
+
+	t.Run("prints flags", func(t *testing.T) {
+		code, out, _ := run("--cflags", "--libs", "d")
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "-DD_NAME=\\\"d\\ lib\\\" -ld\n", out)
+
+		code, out, _ = run("--libs-only-l", "--static", "a")
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "-la -lm -lb -ld -lc-priv\n", out)
+	})
+
+	t.Run("prints versions and variables", func(t *testing.T) {
+		code, out, _ := run("--modversion", "a", "b")
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "1.2.0\n2.1\n", out)
+
+		code, out, _ = run("--variable=prefix", "a")
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "/store/aaaa-a-1.2.0\n", out)
+
+		code, out, _ = run("--version")
+		assert.Equal(t, 0, code)
+		assert.Equal(t, Version+"\n", out)
+	})
+
+	t.Run("overrides variables", func(t *testing.T) {
+		code, out, _ := run("--define-variable=prefix=/usr/local", "--variable=libdir", "a")
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "/usr/local/lib\n", out)
+
+		code, out, _ = run("--define-variable", "prefix=/usr/local", "--cflags", "a")
+		assert.Equal(t, 0, code)
+		assert.Contains(t, out, "-I/usr/local/include")
+		assert.NotContains(t, out, "/store/aaaa-a-1.2.0")
+
+		code, _, stderr := run("--define-variable=prefix", "--variable=libdir", "a")
+		assert.Equal(t, 1, code)
+		assert.Contains(t, stderr, "does not have a value")
+	})
+
+	t.Run("rejects unknown flags", func(t *testing.T) {
+		code, out, stderr := run("--list-everything", "a")
+		assert.Equal(t, 1, code)
+		assert.Equal(t, "", out)
+		assert.Contains(t, stderr, "unknown flag")
+
+		code, out, _ = run("--print-variables", "a")
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "includedir\nlibdir\npcfiledir\nprefix\n", out)
+	})
+
+	t.Run("checks packages exist", func(t *testing.T) {
+		code, _, stderr := run("--exists", "a >= 1.0", "b")
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "", stderr)
+
+		code, _, stderr = run("--exists", "a >= 1.10")
+		assert.Equal(t, 1, code)
+		assert.Equal(t, "", stderr)
+
+		code, _, stderr = run("--exists", "--print-errors", "--short-errors", "e")
+		assert.Equal(t, 1, code)
+		assert.Equal(t, "No package 'missing' found\n", stderr)
+
+		code, _, _ = run("--atleast-version=2", "b")
+		assert.Equal(t, 0, code)
+
+		code, _, _ = run("--atleast-pkgconfig-version", "0.9.0")
+		assert.Equal(t, 0, code)
+	})
+
+	t.Run("only searches the given path", func(t *testing.T) {
+		code, out, stderr := run("--cflags", "xau")
+		assert.Equal(t, 1, code)
+		assert.Equal(t, "", out)
+		assert.Contains(t, stderr, "No package 'xau' found")
+	})
+}
@@ -0,0 +1,315 @@
+package pkgconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// NotFoundError is returned when a package isn't in the search path.
+type NotFoundError struct {
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("No package '%s' found", e.Name)
+}
+
+// VersionError is returned when the version of a package doesn't satisfy
+// a requirement.
+type VersionError struct {
+	Requirement *Requirement
+	Config      *Config
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("Requested '%s' but version of %s is %s", e.Requirement, e.Config.Name, e.Config.Version)
+}
+
+// The directories compilers search by default, flags for them are left out.
+var (
+	DefaultSystemIncludeDirs = []string{"/usr/include"}
+	DefaultSystemLibDirs     = []string{"/usr/lib", "/lib", "/usr/lib64", "/lib64"}
+)
+
+// Resolver finds packages in the directories of Path and collects the
+// flags needed to use them and their requirements.
+type Resolver struct {
+	Path []string
+
+	// Include Libs.private and the libs of Requires.private, for static
+	// linking.
+	Static bool
+
+	SystemIncludeDirs []string
+	SystemLibDirs     []string
+
+	// Variables set in every package, overriding their definitions
+	Defines map[string]string
+
+	configs map[string]*Config
+}
+
+// Find returns the package name from the first directory of Path that
+// contains it.
+func (r *Resolver) Find(name string) (*Config, error) {
+	if cfg, ok := r.configs[name]; ok {
+		return cfg, nil
+	}
+
+	for _, dir := range r.Path {
+		if dir == "" {
+			continue
+		}
+
+		path := filepath.Join(dir, name+".pc")
+
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+
+		cfg, err := LoadDefined(path, r.Defines)
+		if err != nil {
+			return nil, err
+		}
+
+		if r.configs == nil {
+			r.configs = map[string]*Config{}
+		}
+
+		r.configs[name] = cfg
+
+		return cfg, nil
+	}
+
+	return nil, &NotFoundError{Name: name}
+}
+
+// Resolve returns the packages reqs refer to, followed by the packages they
+// require, with every package before the ones it requires. Requires.private
+// is followed when private is true.
+func (r *Resolver) Resolve(reqs []*Requirement, private bool) ([]*Config, error) {
+	var (
+		order []*Config
+		seen  = map[string]bool{}
+	)
+
+	var visit func(req *Requirement) error
+
+	visit = func(req *Requirement) error {
+		cfg, err := r.Find(req.Name)
+		if err != nil {
+			return err
+		}
+
+		if !req.Satisfied(cfg.Version) {
+			return &VersionError{Requirement: req, Config: cfg}
+		}
+
+		if seen[req.Name] {
+			return nil
+		}
+
+		seen[req.Name] = true
+
+		entries := cfg.Requires
+		if private {
+			entries = append(entries[:len(entries):len(entries)], cfg.Private...)
+		}
+
+		sub, err := ParseRequires(entries)
+		if err != nil {
+			return fmt.Errorf("%s: %w", cfg.Path, err)
+		}
+
+		for i := len(sub) - 1; i >= 0; i-- {
+			err = visit(sub[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		order = append(order, cfg)
+
+		return nil
+	}
+
+	for i := len(reqs) - 1; i >= 0; i-- {
+		err := visit(reqs[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+
+	return order, nil
+}
+
+// Cflags returns the compiler flags needed to use the packages reqs refer
+// to. The headers of private requirements are needed too, so they're
+// always included.
+func (r *Resolver) Cflags(reqs []*Requirement) ([]string, error) {
+	configs, err := r.Resolve(reqs, true)
+	if err != nil {
+		return nil, err
+	}
+
+	sysDirs := r.SystemIncludeDirs
+	if sysDirs == nil {
+		sysDirs = DefaultSystemIncludeDirs
+	}
+
+	var (
+		flags []string
+		seen  = map[string]bool{}
+	)
+
+	for _, cfg := range configs {
+		for _, flag := range SplitFlags(cfg.Cflags) {
+			if seen[flag] || isSystemFlag(flag, "-I", sysDirs) {
+				continue
+			}
+
+			seen[flag] = true
+			flags = append(flags, flag)
+		}
+	}
+
+	return flags, nil
+}
+
+// Libs returns the linker flags needed to use the packages reqs refer to.
+// Search path flags come first, and when a library is named more than once
+// only the last one is kept so that it follows everything that uses it.
+func (r *Resolver) Libs(reqs []*Requirement) ([]string, error) {
+	configs, err := r.Resolve(reqs, r.Static)
+	if err != nil {
+		return nil, err
+	}
+
+	sysDirs := r.SystemLibDirs
+	if sysDirs == nil {
+		sysDirs = DefaultSystemLibDirs
+	}
+
+	var (
+		dirs, rest []string
+		seen       = map[string]bool{}
+	)
+
+	for _, cfg := range configs {
+		libs := cfg.Libs
+		if r.Static {
+			libs += " " + cfg.PrivLibs
+		}
+
+		for _, flag := range SplitFlags(libs) {
+			switch {
+			case strings.HasPrefix(flag, "-L"):
+				if seen[flag] || isSystemFlag(flag, "-L", sysDirs) {
+					continue
+				}
+
+				seen[flag] = true
+				dirs = append(dirs, flag)
+			case strings.HasPrefix(flag, "-l"):
+				rest = append(rest, flag)
+			default:
+				if seen[flag] {
+					continue
+				}
+
+				seen[flag] = true
+				rest = append(rest, flag)
+			}
+		}
+	}
+
+	// Keep the last of each -l
+	last := map[string]int{}
+
+	for i, flag := range rest {
+		if strings.HasPrefix(flag, "-l") {
+			last[flag] = i
+		}
+	}
+
+	flags := dirs
+
+	for i, flag := range rest {
+		if strings.HasPrefix(flag, "-l") && last[flag] != i {
+			continue
+		}
+
+		flags = append(flags, flag)
+	}
+
+	return flags, nil
+}
+
+func isSystemFlag(flag, prefix string, dirs []string) bool {
+	if !strings.HasPrefix(flag, prefix) {
+		return false
+	}
+
+	dir := filepath.Clean(flag[len(prefix):])
+
+	for _, sys := range dirs {
+		if dir == sys {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SplitFlags splits the value of Cflags or Libs into flags the way a shell
+// would, honoring quotes and backslashes.
+func SplitFlags(s string) []string {
+	var (
+		flags []string
+		sb    strings.Builder
+		inArg bool
+		quote rune
+		esc   bool
+	)
+
+	for _, r := range s {
+		switch {
+		case esc:
+			sb.WriteRune(r)
+			esc = false
+		case r == '\\' && quote != '\'':
+			esc = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				sb.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inArg {
+				flags = append(flags, sb.String())
+				sb.Reset()
+				inArg = false
+			}
+		default:
+			sb.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if inArg {
+		flags = append(flags, sb.String())
+	}
+
+	return flags
+}
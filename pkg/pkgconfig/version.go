@@ -0,0 +1,203 @@
+package pkgconfig
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Requirement is a package named in Requires, optionally constrained to
+// certain versions.
+type Requirement struct {
+	Name    string
+	Op      string
+	Version string
+}
+
+func (r *Requirement) String() string {
+	if r.Op == "" {
+		return r.Name
+	}
+
+	return fmt.Sprintf("%s %s %s", r.Name, r.Op, r.Version)
+}
+
+// Satisfied returns true if version meets the requirement's constraint.
+func (r *Requirement) Satisfied(version string) bool {
+	cmp := CompareVersions(version, r.Version)
+
+	switch r.Op {
+	case "":
+		return true
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	default:
+		return false
+	}
+}
+
+func isOpChar(r rune) bool {
+	return r == '<' || r == '>' || r == '=' || r == '!'
+}
+
+// ParseRequires parses the package list of Requires and Requires.private,
+// as well as the packages given to pkg-config on the command line. Entries
+// are separated by commas or spaces, and a name may be followed by an
+// operator and version.
+func ParseRequires(entries []string) ([]*Requirement, error) {
+	var (
+		tokens []string
+		sb     strings.Builder
+	)
+
+	flush := func() {
+		if sb.Len() > 0 {
+			tokens = append(tokens, sb.String())
+			sb.Reset()
+		}
+	}
+
+	for _, r := range strings.Join(entries, ",") {
+		switch {
+		case r == ',' || unicode.IsSpace(r):
+			flush()
+		case isOpChar(r):
+			if sb.Len() > 0 && !isOpChar(rune(sb.String()[sb.Len()-1])) {
+				flush()
+			}
+
+			sb.WriteRune(r)
+		default:
+			if sb.Len() > 0 && isOpChar(rune(sb.String()[sb.Len()-1])) {
+				flush()
+			}
+
+			sb.WriteRune(r)
+		}
+	}
+
+	flush()
+
+	var reqs []*Requirement
+
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+
+		if !isOpChar(rune(tok[0])) {
+			reqs = append(reqs, &Requirement{Name: tok})
+			continue
+		}
+
+		if len(reqs) == 0 || reqs[len(reqs)-1].Op != "" {
+			return nil, fmt.Errorf("operator %s without a package name", tok)
+		}
+
+		switch tok {
+		case "=", "!=", "<", "<=", ">", ">=":
+		default:
+			return nil, fmt.Errorf("unknown version operator %s", tok)
+		}
+
+		if i+1 >= len(tokens) {
+			return nil, fmt.Errorf("operator %s without a version", tok)
+		}
+
+		i++
+
+		last := reqs[len(reqs)-1]
+		last.Op = tok
+		last.Version = tokens[i]
+	}
+
+	return reqs, nil
+}
+
+// CompareVersions compares 2 versions the way pkg-config does: runs of
+// digits compare numerically, runs of letters as strings, and a number is
+// newer than letters. It returns -1, 0, or 1 when a is older than, the same
+// as, or newer than b.
+func CompareVersions(a, b string) int {
+	if a == b {
+		return 0
+	}
+
+	isAlnum := func(c byte) bool {
+		return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	}
+
+	isDigit := func(c byte) bool {
+		return c >= '0' && c <= '9'
+	}
+
+	for {
+		for len(a) > 0 && !isAlnum(a[0]) {
+			a = a[1:]
+		}
+
+		for len(b) > 0 && !isAlnum(b[0]) {
+			b = b[1:]
+		}
+
+		if a == "" || b == "" {
+			break
+		}
+
+		numeric := isDigit(a[0])
+
+		seg := func(s string) (string, string) {
+			i := 0
+			for i < len(s) && isAlnum(s[i]) && isDigit(s[i]) == numeric {
+				i++
+			}
+			return s[:i], s[i:]
+		}
+
+		var sa, sb string
+
+		sa, a = seg(a)
+		sb, b = seg(b)
+
+		// A segment of a different kind in b means the number is newer.
+		if sb == "" {
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+
+		if numeric {
+			sa = strings.TrimLeft(sa, "0")
+			sb = strings.TrimLeft(sb, "0")
+
+			if len(sa) != len(sb) {
+				if len(sa) > len(sb) {
+					return 1
+				}
+				return -1
+			}
+		}
+
+		if c := strings.Compare(sa, sb); c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	default:
+		return 1
+	}
+}
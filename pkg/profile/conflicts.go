@@ -0,0 +1,333 @@
+package profile
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Conflict is a file in the profile that more than one package provides.
+type Conflict struct {
+	// The path of the file, relative to the profile
+	Path string
+
+	// The packages that provide the file, the one that is linked first
+	Packages []string
+
+	// Set when the linked package has a higher priority than the others,
+	// otherwise it was picked by link order.
+	Resolved bool
+}
+
+// linkedDirs are the top level directories of a package that are linked
+// into a profile.
+var linkedDirs = []string{"etc", "bin", "sbin", "share", "include", "lib"}
+
+// packageFiles returns the paths, relative to root, of the files that
+// LinkTree links into a profile.
+func packageFiles(root string) ([]string, error) {
+	var files []string
+
+	for _, dir := range linkedDirs {
+		top := filepath.Join(root, dir)
+
+		if _, err := os.Lstat(top); err != nil {
+			continue
+		}
+
+		err := filepath.Walk(top, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() {
+				return nil
+			}
+
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+
+			files = append(files, rel)
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// findConflicts returns the files provided by more than one of changes,
+// which must be in the order they are linked.
+func findConflicts(changes []profileChange) ([]*Conflict, error) {
+	providers := map[string][]int{}
+
+	for i, ch := range changes {
+		files, err := packageFiles(ch.path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			providers[f] = append(providers[f], i)
+		}
+	}
+
+	var conflicts []*Conflict
+
+	for path, idx := range providers {
+		if len(idx) < 2 || sameFiles(changes, idx, path) {
+			continue
+		}
+
+		c := &Conflict{
+			Path:     path,
+			Resolved: changes[idx[0]].priority > changes[idx[1]].priority,
+		}
+
+		for _, i := range idx {
+			c.Packages = append(c.Packages, changes[i].id)
+		}
+
+		conflicts = append(conflicts, c)
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].Path < conflicts[j].Path
+	})
+
+	return conflicts, nil
+}
+
+// sameFiles reports whether the packages idx of changes all provide the
+// same file at path, in which case it doesn't matter which one is linked.
+func sameFiles(changes []profileChange, idx []int, path string) bool {
+	first := filepath.Join(changes[idx[0]].path, path)
+
+	for _, i := range idx[1:] {
+		if !sameFile(first, filepath.Join(changes[i].path, path)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sameFile reports whether a and b are symlinks to the same target or
+// files with the same mode and contents.
+func sameFile(a, b string) bool {
+	ai, err := os.Lstat(a)
+	if err != nil {
+		return false
+	}
+
+	bi, err := os.Lstat(b)
+	if err != nil {
+		return false
+	}
+
+	if ai.Mode() != bi.Mode() {
+		return false
+	}
+
+	if ai.Mode()&os.ModeSymlink != 0 {
+		at, err := os.Readlink(a)
+		if err != nil {
+			return false
+		}
+
+		bt, err := os.Readlink(b)
+		if err != nil {
+			return false
+		}
+
+		return at == bt
+	}
+
+	if !ai.Mode().IsRegular() || ai.Size() != bi.Size() {
+		return false
+	}
+
+	if os.SameFile(ai, bi) {
+		return true
+	}
+
+	ad, err := ioutil.ReadFile(a)
+	if err != nil {
+		return false
+	}
+
+	bd, err := ioutil.ReadFile(b)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(ad, bd)
+}
+
+// misLinked reports whether any conflicting file in the profile is
+// currently provided by a package other than the one that should win.
+func (p *Profile) misLinked(conflicts []*Conflict, changes []profileChange) bool {
+	roots := map[string]string{}
+
+	for _, ch := range changes {
+		roots[ch.id] = ch.path
+	}
+
+	for _, c := range conflicts {
+		cur, err := filepath.EvalSymlinks(filepath.Join(p.path, c.Path))
+		if err != nil {
+			continue
+		}
+
+		want, err := filepath.EvalSymlinks(filepath.Join(roots[c.Packages[0]], c.Path))
+		if err != nil {
+			continue
+		}
+
+		if cur != want {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *Profile) prioritiesPath() string {
+	return filepath.Join(p.path, ".priorities.json")
+}
+
+func (p *Profile) writePriorities(changes []profileChange) error {
+	priorities := map[string]int{}
+
+	for _, ch := range changes {
+		if ch.priority != 0 {
+			priorities[ch.id] = ch.priority
+		}
+	}
+
+	if len(priorities) == 0 {
+		err := os.Remove(p.prioritiesPath())
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return nil
+	}
+
+	data, err := json.Marshal(priorities)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(p.prioritiesPath(), data, 0644)
+}
+
+// current returns the packages linked into the profile, in link order.
+func (p *Profile) current() ([]profileChange, error) {
+	priorities := map[string]int{}
+
+	data, err := ioutil.ReadFile(p.prioritiesPath())
+	if err == nil {
+		err = json.Unmarshal(data, &priorities)
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	refs := filepath.Join(p.path, ".refs")
+
+	files, _ := ioutil.ReadDir(refs)
+
+	var changes []profileChange
+
+	for _, fi := range files {
+		root, err := os.Readlink(filepath.Join(refs, fi.Name()))
+		if err != nil {
+			continue
+		}
+
+		changes = append(changes, profileChange{
+			id:       fi.Name(),
+			path:     root,
+			priority: priorities[fi.Name()],
+		})
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].priority > changes[j].priority
+	})
+
+	return changes, nil
+}
+
+// Packages returns the store directories of the packages linked into the
+// profile, keyed by id.
+func (p *Profile) Packages() (map[string]string, error) {
+	changes, err := p.current()
+	if err != nil {
+		return nil, err
+	}
+
+	roots := map[string]string{}
+
+	for _, ch := range changes {
+		roots[ch.id] = ch.path
+	}
+
+	return roots, nil
+}
+
+// Conflicts returns the files that more than one package in the profile
+// provides.
+func (p *Profile) Conflicts() ([]*Conflict, error) {
+	changes, err := p.current()
+	if err != nil {
+		return nil, err
+	}
+
+	conflicts, err := findConflicts(changes)
+	if err != nil {
+		return nil, err
+	}
+
+	roots := map[string]string{}
+
+	for _, ch := range changes {
+		roots[ch.id] = ch.path
+	}
+
+	// Packages with the same priority were linked in the order they were
+	// requested, which isn't recorded, so see which one the profile uses.
+	for _, c := range conflicts {
+		cur, err := filepath.EvalSymlinks(filepath.Join(p.path, c.Path))
+		if err != nil {
+			continue
+		}
+
+		for i, id := range c.Packages {
+			path, err := filepath.EvalSymlinks(filepath.Join(roots[id], c.Path))
+			if err == nil && path == cur {
+				c.Packages[0], c.Packages[i] = c.Packages[i], c.Packages[0]
+				break
+			}
+		}
+	}
+
+	return conflicts, nil
+}
+
+func (c *Conflict) String() string {
+	return c.Path + ": " + strings.Join(c.Packages, ", ")
+}
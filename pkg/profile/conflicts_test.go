@@ -0,0 +1,130 @@
+package profile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConflicts(t *testing.T) {
+	top, err := ioutil.TempDir("", "profile-conflicts")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(top)
+
+	pkg := func(id string, files ...string) string {
+		root := filepath.Join(top, "store", id)
+
+		for _, f := range files {
+			path := filepath.Join(root, f)
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+			require.NoError(t, ioutil.WriteFile(path, []byte(id), 0755))
+		}
+
+		return root
+	}
+
+	python2 := pkg("a-python-2.7", "bin/python", "bin/python2", "share/man/man1/python.1")
+	python3 := pkg("b-python-3.9", "bin/python", "bin/python3", "share/man/man1/python.1")
+	jq := pkg("c-jq-1.6", "bin/jq")
+
+	open := func(t *testing.T, name string) *Profile {
+		path := filepath.Join(top, name)
+		require.NoError(t, os.MkdirAll(path, 0755))
+		return &Profile{path: path}
+	}
+
+	linked := func(t *testing.T, prof *Profile, path string) string {
+		data, err := ioutil.ReadFile(filepath.Join(prof.path, path))
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	t.Run("reports conflicts resolved by link order", func(t *testing.T) {
+		prof := open(t, "order")
+
+		prof.Link("a-python-2.7", python2)
+		prof.Link("b-python-3.9", python3)
+		prof.Link("c-jq-1.6", jq)
+
+		require.NoError(t, prof.Commit())
+
+		assert.Equal(t, "a-python-2.7", linked(t, prof, "bin/python"))
+		assert.Equal(t, "b-python-3.9", linked(t, prof, "bin/python3"))
+
+		conflicts, err := prof.Conflicts()
+		require.NoError(t, err)
+
+		assert.Equal(t, []*Conflict{
+			{Path: "bin/python", Packages: []string{"a-python-2.7", "b-python-3.9"}},
+			{Path: "share/man/man1/python.1", Packages: []string{"a-python-2.7", "b-python-3.9"}},
+		}, conflicts)
+
+		assert.Equal(t, conflicts, prof.Unresolved())
+	})
+
+	t.Run("ignores files that are the same in each package", func(t *testing.T) {
+		same := func(id string) string {
+			root := filepath.Join(top, "store", id)
+
+			require.NoError(t, os.MkdirAll(filepath.Join(root, "share", "licenses"), 0755))
+			require.NoError(t, ioutil.WriteFile(filepath.Join(root, "share", "licenses", "COPYING"), []byte("license"), 0644))
+
+			require.NoError(t, os.MkdirAll(filepath.Join(root, "bin"), 0755))
+			require.NoError(t, os.Symlink("../libexec/tool", filepath.Join(root, "bin", "tool")))
+
+			return root
+		}
+
+		prof := open(t, "same")
+
+		prof.Link("d-tool-1.0", same("d-tool-1.0"))
+		prof.Link("e-tool-extras-1.0", same("e-tool-extras-1.0"))
+
+		require.NoError(t, prof.Commit())
+
+		conflicts, err := prof.Conflicts()
+		require.NoError(t, err)
+
+		assert.Empty(t, conflicts)
+		assert.Empty(t, prof.Unresolved())
+	})
+
+	t.Run("links the package with the highest priority", func(t *testing.T) {
+		prof := open(t, "priority")
+
+		prof.Link("a-python-2.7", python2)
+		prof.LinkPriority("b-python-3.9", python3, 10)
+
+		require.NoError(t, prof.Commit())
+
+		assert.Equal(t, "b-python-3.9", linked(t, prof, "bin/python"))
+
+		conflicts, err := prof.Conflicts()
+		require.NoError(t, err)
+
+		require.Len(t, conflicts, 2)
+		assert.True(t, conflicts[0].Resolved)
+		assert.Equal(t, []string{"b-python-3.9", "a-python-2.7"}, conflicts[0].Packages)
+	})
+
+	t.Run("relinks when the priority changes", func(t *testing.T) {
+		prof := open(t, "change")
+		prof.Link("a-python-2.7", python2)
+		prof.Link("b-python-3.9", python3)
+		require.NoError(t, prof.Commit())
+
+		assert.Equal(t, "a-python-2.7", linked(t, prof, "bin/python"))
+
+		prof = open(t, "change")
+		prof.LinkPriority("b-python-3.9", python3, 1)
+		require.NoError(t, prof.Add())
+
+		assert.Equal(t, "b-python-3.9", linked(t, prof, "bin/python"))
+		assert.Equal(t, "a-python-2.7", linked(t, prof, "bin/python2"))
+	})
+}
@@ -0,0 +1,233 @@
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"lab47.dev/aperture/pkg/data"
+)
+
+var ErrEnvConflict = errors.New("conflicting package environment")
+
+// Env is the environment declared by the packages linked into a profile.
+type Env struct {
+	// Variables to set, replacing any existing value
+	Set map[string]string
+
+	// Paths to prepend to the existing value of variables
+	Paths map[string][]string
+
+	// The package that declared each variable, used to explain conflicts
+	from map[string]string
+}
+
+// expandPrefix replaces references to $prefix in val with root.
+func expandPrefix(val, root string) string {
+	val = strings.Replace(val, "${prefix}", root, -1)
+	return strings.Replace(val, "$prefix", root, -1)
+}
+
+// add merges the environment of package id, installed at root, into e. A
+// variable may be set by more than one package only if they agree on the
+// value. Paths from different packages are combined, but a variable can't
+// be both set and have paths prepended to it.
+func (e *Env) add(id, root string, vars []*data.PackageEnv) error {
+	for _, pe := range vars {
+		prev, seen := e.from[pe.Name]
+
+		if pe.Paths == nil {
+			val := expandPrefix(pe.Value, root)
+
+			if seen {
+				cur, isSet := e.Set[pe.Name]
+				if !isSet || cur != val {
+					return errors.Wrapf(ErrEnvConflict, "%s is declared by both %s and %s", pe.Name, prev, id)
+				}
+
+				continue
+			}
+
+			e.Set[pe.Name] = val
+			e.from[pe.Name] = id
+
+			continue
+		}
+
+		if _, isSet := e.Set[pe.Name]; isSet {
+			return errors.Wrapf(ErrEnvConflict, "%s is declared by both %s and %s", pe.Name, prev, id)
+		}
+
+		if !seen {
+			e.from[pe.Name] = id
+		}
+
+	paths:
+		for _, path := range pe.Paths {
+			path = expandPrefix(path, root)
+
+			for _, cur := range e.Paths[pe.Name] {
+				if cur == path {
+					continue paths
+				}
+			}
+
+			e.Paths[pe.Name] = append(e.Paths[pe.Name], path)
+		}
+	}
+
+	return nil
+}
+
+// PackageEnv returns the merged environment of the packages in the profile.
+func (p *Profile) PackageEnv() (*Env, error) {
+	env := &Env{
+		Set:   map[string]string{},
+		Paths: map[string][]string{},
+		from:  map[string]string{},
+	}
+
+	refs := filepath.Join(p.path, ".refs")
+
+	files, _ := ioutil.ReadDir(refs)
+
+	for _, fi := range files {
+		root, err := os.Readlink(filepath.Join(refs, fi.Name()))
+		if err != nil {
+			continue
+		}
+
+		f, err := os.Open(filepath.Join(root, ".pkg-info.json"))
+		if err != nil {
+			// Packages installed before their info was recorded have no
+			// environment to contribute.
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		var pi data.PackageInfo
+
+		err = json.NewDecoder(f).Decode(&pi)
+		f.Close()
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading info for %s", fi.Name())
+		}
+
+		err = env.add(fi.Name(), root, pi.Environment)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return env, nil
+}
+
+// Env returns the environment of the profile: that of its packages, with
+// the profile's bin directory at the front of PATH.
+func (p *Profile) Env() (*Env, error) {
+	env, err := p.PackageEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	env.Paths["PATH"] = append([]string{filepath.Join(p.path, "bin")}, env.Paths["PATH"]...)
+
+	return env, nil
+}
+
+// Updates returns the variables of cur that change when e is applied to it.
+func (e *Env) Updates(cur map[string]string) map[string]string {
+	updates := map[string]string{}
+
+	for k, v := range e.Set {
+		updates[k] = v
+	}
+
+	for k, paths := range e.Paths {
+		v := strings.Join(paths, string(filepath.ListSeparator))
+
+		if existing, ok := cur[k]; ok && existing != "" {
+			v = fmt.Sprintf("%s%s%s", v, string(filepath.ListSeparator), existing)
+		}
+
+		updates[k] = v
+	}
+
+	return updates
+}
+
+// updates returns the variables in env that are changed by the profile.
+func (p *Profile) updates(env []string) (map[string]string, error) {
+	penv, err := p.Env()
+	if err != nil {
+		return nil, err
+	}
+
+	return penv.Updates(envMap(env)), nil
+}
+
+// PureAllowlist are the variables kept from the host environment in a pure
+// environment.
+var PureAllowlist = []string{"TERM", "HOME", "USER", "LANG"}
+
+// SystemPath is the PATH of a pure environment before the profile is
+// applied.
+const SystemPath = "/usr/bin:/bin"
+
+// PureEnv returns a minimal environment containing only the variables of
+// env in PureAllowlist. PATH is set to SystemPath, unless hideSystem is set,
+// in which case it's left unset so that only programs in the profile can
+// be found.
+func PureEnv(env []string, hideSystem bool) []string {
+	m := envMap(env)
+
+	var out []string
+
+	for _, k := range PureAllowlist {
+		if v, ok := m[k]; ok {
+			out = append(out, k+"="+v)
+		}
+	}
+
+	if !hideSystem {
+		out = append(out, "PATH="+SystemPath)
+	}
+
+	return out
+}
+
+func envMap(env []string) map[string]string {
+	m := map[string]string{}
+
+	for _, kv := range env {
+		eq := strings.IndexByte(kv, '=')
+		if eq == -1 {
+			continue
+		}
+
+		m[kv[:eq]] = kv[eq+1:]
+	}
+
+	return m
+}
+
+func sortedEnv(m map[string]string) []string {
+	var out []string
+
+	for k, v := range m {
+		out = append(out, k+"="+v)
+	}
+
+	sort.Strings(out)
+
+	return out
+}
@@ -0,0 +1,132 @@
+package profile
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"lab47.dev/aperture/pkg/data"
+)
+
+func TestPackageEnv(t *testing.T) {
+	top, err := ioutil.TempDir("", "profile-env")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(top)
+
+	// setup creates a profile containing packages with the given
+	// environments, returning the profile and the store dir of each package.
+	setup := func(t *testing.T, name string, pkgs map[string][]*data.PackageEnv) (*Profile, map[string]string) {
+		prof := &Profile{path: filepath.Join(top, name, "profile")}
+		refs := filepath.Join(prof.path, ".refs")
+
+		require.NoError(t, os.MkdirAll(refs, 0755))
+
+		roots := map[string]string{}
+
+		for id, env := range pkgs {
+			root := filepath.Join(top, name, "store", id)
+			require.NoError(t, os.MkdirAll(root, 0755))
+
+			buf, err := json.Marshal(&data.PackageInfo{Id: id, Environment: env})
+			require.NoError(t, err)
+
+			require.NoError(t, ioutil.WriteFile(filepath.Join(root, ".pkg-info.json"), buf, 0644))
+			require.NoError(t, os.Symlink(root, filepath.Join(refs, id)))
+
+			roots[id] = root
+		}
+
+		return prof, roots
+	}
+
+	t.Run("sets variables and prepends paths", func(t *testing.T) {
+		prof, roots := setup(t, "merge", map[string][]*data.PackageEnv{
+			"a-jdk-1.0": {
+				{Name: "JAVA_HOME", Value: "$prefix/libexec"},
+				{Name: "MANPATH", Paths: []string{"$prefix/share/man"}},
+			},
+			"b-tool-1.0": {
+				{Name: "MANPATH", Paths: []string{"${prefix}/man"}},
+				{Name: "PATH", Paths: []string{"$prefix/libexec/bin"}},
+			},
+		})
+
+		updates, err := prof.UpdateEnv([]string{"PATH=/bin", "MANPATH=/usr/share/man", "HOME=/home/test"})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{
+			"JAVA_HOME=" + roots["a-jdk-1.0"] + "/libexec",
+			"MANPATH=" + roots["a-jdk-1.0"] + "/share/man:" + roots["b-tool-1.0"] + "/man:/usr/share/man",
+			"PATH=" + prof.path + "/bin:" + roots["b-tool-1.0"] + "/libexec/bin:/bin",
+		}, updates)
+
+		m, err := prof.EnvMap([]string{"PATH=/bin", "HOME=/home/test"})
+		require.NoError(t, err)
+
+		assert.Equal(t, "/home/test", m["HOME"])
+		assert.Equal(t, roots["a-jdk-1.0"]+"/share/man:"+roots["b-tool-1.0"]+"/man", m["MANPATH"])
+	})
+
+	t.Run("allows packages to agree on a value", func(t *testing.T) {
+		prof, _ := setup(t, "agree", map[string][]*data.PackageEnv{
+			"a-one-1.0": {{Name: "SSL_CERT_FILE", Value: "/etc/ssl/cert.pem"}},
+			"b-two-1.0": {{Name: "SSL_CERT_FILE", Value: "/etc/ssl/cert.pem"}},
+		})
+
+		env, err := prof.PackageEnv()
+		require.NoError(t, err)
+
+		assert.Equal(t, "/etc/ssl/cert.pem", env.Set["SSL_CERT_FILE"])
+	})
+
+	t.Run("rejects conflicting values", func(t *testing.T) {
+		prof, _ := setup(t, "conflict", map[string][]*data.PackageEnv{
+			"a-one-1.0": {{Name: "SSL_CERT_FILE", Value: "$prefix/cert.pem"}},
+			"b-two-1.0": {{Name: "SSL_CERT_FILE", Value: "$prefix/cert.pem"}},
+		})
+
+		_, err := prof.PackageEnv()
+		assert.ErrorIs(t, err, ErrEnvConflict)
+	})
+
+	t.Run("rejects setting a variable others add paths to", func(t *testing.T) {
+		prof, _ := setup(t, "mixed", map[string][]*data.PackageEnv{
+			"a-one-1.0": {{Name: "MANPATH", Paths: []string{"$prefix/man"}}},
+			"b-two-1.0": {{Name: "MANPATH", Value: "/man"}},
+		})
+
+		_, err := prof.UpdateEnv(nil)
+		assert.ErrorIs(t, err, ErrEnvConflict)
+	})
+
+	t.Run("starts pure environments from the allowlist", func(t *testing.T) {
+		prof, _ := setup(t, "pure", map[string][]*data.PackageEnv{
+			"a-jdk-1.0": {{Name: "JAVA_HOME", Value: "/jdk"}},
+		})
+
+		host := []string{"HOME=/home/test", "TERM=xterm", "SECRET=x", "PATH=/opt/bin:/usr/bin"}
+
+		// ComputeEnv updates PATH for the current process.
+		defer os.Setenv("PATH", os.Getenv("PATH"))
+
+		env, err := prof.ComputeEnv(PureEnv(host, false))
+		require.NoError(t, err)
+
+		assert.ElementsMatch(t, []string{
+			"HOME=/home/test",
+			"TERM=xterm",
+			"JAVA_HOME=/jdk",
+			"PATH=" + prof.path + "/bin:" + SystemPath,
+		}, env)
+
+		env, err = prof.ComputeEnv(PureEnv(host, true))
+		require.NoError(t, err)
+
+		assert.Contains(t, env, "PATH="+prof.path+"/bin")
+	})
+}
@@ -72,15 +72,9 @@ func LinkTree(targetRoot, root string) error {
 			return nil
 		}
 
+		// The file is already provided by another package, which wins. See
+		// Profile.Commit for how conflicts are reported.
 		if !info.IsDir() {
-			lt, err := os.Readlink(target)
-			if err != nil || lt != path {
-				absLt := filepath.Join(filepath.Dir(target), lt)
-				if err != nil || absLt != path {
-					fmt.Printf("skipping duplicate entries for %s (%s != %s)", target, lt, path)
-				}
-			}
-
 			return nil
 		}
 
@@ -1,10 +1,10 @@
 package profile
 
 import (
-	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/mr-tron/base58"
@@ -13,12 +13,15 @@ import (
 
 type profileChange struct {
 	id, path string
+	priority int
 }
 
 type Profile struct {
 	path string
 
 	changes []profileChange
+
+	unresolved []*Conflict
 }
 
 func OpenProfile(cfg *config.Config, path string) (*Profile, error) {
@@ -39,8 +42,20 @@ func OpenProfile(cfg *config.Config, path string) (*Profile, error) {
 	return &Profile{path: path}, nil
 }
 
+// Path returns the directory of the profile.
+func (p *Profile) Path() string {
+	return p.path
+}
+
 func (p *Profile) Link(id string, root string) error {
-	p.changes = append(p.changes, profileChange{id: id, path: root})
+	return p.LinkPriority(id, root, 0)
+}
+
+// LinkPriority requests that the package id, installed at root, be linked
+// into the profile. When packages provide the same file, the one with the
+// highest priority is linked.
+func (p *Profile) LinkPriority(id string, root string, priority int) error {
+	p.changes = append(p.changes, profileChange{id: id, path: root, priority: priority})
 	return nil
 }
 
@@ -73,34 +88,83 @@ func (p *Profile) linkOne(id string, root string) error {
 // Add adds any requested links to the profile, it does not
 // prune out entries like Commit.
 func (p *Profile) Add() error {
+	current, err := p.current()
+	if err != nil {
+		return err
+	}
+
+	requested := map[string]struct{}{}
+
 	for _, ch := range p.changes {
-		err := p.linkOne(ch.id, ch.path)
-		if err != nil {
-			return err
+		requested[ch.id] = struct{}{}
+	}
+
+	var changes []profileChange
+
+	for _, ch := range current {
+		if _, ok := requested[ch.id]; !ok {
+			changes = append(changes, ch)
 		}
 	}
 
-	return nil
+	return p.apply(append(changes, p.changes...))
 }
 
+// Commit makes the profile contain exactly the requested links.
 func (p *Profile) Commit() error {
+	return p.apply(p.changes)
+}
+
+// Unresolved returns the conflicts between the packages linked by the last
+// Add or Commit that were decided by link order rather than priority.
+func (p *Profile) Unresolved() []*Conflict {
+	return p.unresolved
+}
+
+func (p *Profile) apply(requested []profileChange) error {
+	var changes []profileChange
+
+	seen := map[string]int{}
+
+	for _, ch := range requested {
+		if i, ok := seen[ch.id]; ok {
+			if ch.priority > changes[i].priority {
+				changes[i].priority = ch.priority
+			}
+
+			continue
+		}
+
+		seen[ch.id] = len(changes)
+		changes = append(changes, ch)
+	}
+
 	known := map[string]struct{}{}
 
 	files, _ := ioutil.ReadDir(filepath.Join(p.path, ".refs"))
 
-	var refs []string
-
 	for _, fi := range files {
 		known[fi.Name()] = struct{}{}
-		refs = append(refs, fi.Name())
 	}
 
-	for _, ch := range p.changes {
+	for _, ch := range changes {
 		delete(known, ch.id)
 	}
 
-	// If we deleted something, nuke the profile dir and we'll rebuild it now
-	if len(known) > 0 {
+	// Packages are linked in priority order, the first package linked
+	// provides any file that others do as well.
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].priority > changes[j].priority
+	})
+
+	conflicts, err := findConflicts(changes)
+	if err != nil {
+		return err
+	}
+
+	// If we deleted something, or a file is provided by the wrong package,
+	// nuke the profile dir and we'll rebuild it now
+	if len(known) > 0 || p.misLinked(conflicts, changes) {
 		err := os.RemoveAll(p.path)
 		if err != nil {
 			return err
@@ -112,70 +176,76 @@ func (p *Profile) Commit() error {
 		}
 	}
 
-	for _, ch := range p.changes {
+	for _, ch := range changes {
 		err := p.linkOne(ch.id, ch.path)
 		if err != nil {
 			return err
 		}
 	}
 
-	return nil
-}
-
-func (p *Profile) UpdateEnv(env []string) []string {
-	var updates []string
+	p.unresolved = nil
 
-	binDir := filepath.Join(p.path, "bin")
-
-	for _, kv := range env {
-		if strings.HasPrefix(kv, "PATH=") {
-			val := kv[5:]
-			updates = append(updates, fmt.Sprintf("PATH=%s:%s", binDir, val))
+	for _, c := range conflicts {
+		if !c.Resolved {
+			p.unresolved = append(p.unresolved, c)
 		}
 	}
 
-	return updates
+	return p.writePriorities(changes)
 }
 
-func (p *Profile) ComputeEnv(env []string) []string {
-	var updates []string
-
-	binDir := filepath.Join(p.path, "bin")
-
-	for _, kv := range env {
-		if strings.HasPrefix(kv, "PATH=") {
-			val := kv[5:]
-			kv = fmt.Sprintf("PATH=%s:%s", binDir, val)
-
-			os.Setenv("PATH", binDir+":"+val)
-		}
-
-		updates = append(updates, kv)
+// UpdateEnv returns the variables of env that the profile changes, along
+// with any variables set by the packages in the profile.
+func (p *Profile) UpdateEnv(env []string) ([]string, error) {
+	updates, err := p.updates(env)
+	if err != nil {
+		return nil, err
 	}
 
-	return updates
+	return sortedEnv(updates), nil
 }
 
-func (p *Profile) EnvMap(env []string) map[string]string {
-	m := map[string]string{}
+// ComputeEnv returns env with the changes made by the profile applied. PATH
+// is also updated in the current process so that programs in the profile
+// can be found.
+func (p *Profile) ComputeEnv(env []string) ([]string, error) {
+	updates, err := p.updates(env)
+	if err != nil {
+		return nil, err
+	}
 
-	binDir := filepath.Join(p.path, "bin")
+	var out []string
 
 	for _, kv := range env {
 		eq := strings.IndexByte(kv, '=')
-		if eq == -1 {
-			continue
+		if eq != -1 {
+			if _, ok := updates[kv[:eq]]; ok {
+				continue
+			}
 		}
-		k := kv[:eq]
 
-		v := kv[eq+1:]
+		out = append(out, kv)
+	}
 
-		if k == "PATH" {
-			v = fmt.Sprintf("%s%s%s", binDir, string(filepath.ListSeparator), v)
-		}
+	if path, ok := updates["PATH"]; ok {
+		os.Setenv("PATH", path)
+	}
+
+	return append(out, sortedEnv(updates)...), nil
+}
+
+// EnvMap returns env as a map with the changes made by the profile applied.
+func (p *Profile) EnvMap(env []string) (map[string]string, error) {
+	updates, err := p.updates(env)
+	if err != nil {
+		return nil, err
+	}
+
+	m := envMap(env)
 
+	for k, v := range updates {
 		m[k] = v
 	}
 
-	return m
+	return m, nil
 }
@@ -0,0 +1,552 @@
+package rpath
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrNoDebugInfo = errors.New("ELF file has no debug info")
+	ErrNoBuildID   = errors.New("ELF file has no build id")
+)
+
+// DebugInfo is the debug info split out of an ELF file.
+type DebugInfo struct {
+	// The build id of the file, in hex
+	BuildID string
+
+	// An ELF file containing the debug sections, along with the headers of
+	// the other sections so debuggers can map the debug info onto the
+	// original file.
+	Data []byte
+}
+
+// Path returns where the debug info goes in a directory debuggers search
+// by build id, such as .build-id/ab/cdef.debug.
+func (d *DebugInfo) Path() string {
+	return filepath.Join(".build-id", d.BuildID[:2], d.BuildID[2:]+".debug")
+}
+
+// rawSection is a section header, independent of the ELF class.
+type rawSection struct {
+	Name      uint32
+	Type      uint32
+	Flags     uint64
+	Addr      uint64
+	Offset    uint64
+	Size      uint64
+	Link      uint32
+	Info      uint32
+	Addralign uint64
+	Entsize   uint64
+}
+
+// elfLayout is what's needed to rewrite the section headers of a file.
+type elfLayout struct {
+	is64     bool
+	order    binary.ByteOrder
+	ehsize   int
+	shstrndx int
+	sections []rawSection
+	names    []string
+}
+
+func readLayout(data []byte, ef *elf.File) (*elfLayout, error) {
+	l := &elfLayout{
+		is64:  ef.Class == elf.ELFCLASS64,
+		order: ef.ByteOrder,
+	}
+
+	r := bytes.NewReader(data)
+
+	var (
+		shoff, shentsize uint64
+		shnum            int
+	)
+
+	if l.is64 {
+		var hdr elf.Header64
+		if err := binary.Read(r, l.order, &hdr); err != nil {
+			return nil, err
+		}
+
+		l.ehsize = int(hdr.Ehsize)
+		l.shstrndx = int(hdr.Shstrndx)
+		shoff, shentsize, shnum = hdr.Shoff, uint64(hdr.Shentsize), int(hdr.Shnum)
+	} else {
+		var hdr elf.Header32
+		if err := binary.Read(r, l.order, &hdr); err != nil {
+			return nil, err
+		}
+
+		l.ehsize = int(hdr.Ehsize)
+		l.shstrndx = int(hdr.Shstrndx)
+		shoff, shentsize, shnum = uint64(hdr.Shoff), uint64(hdr.Shentsize), int(hdr.Shnum)
+	}
+
+	// Files with extended section numbering aren't supported.
+	if shnum == 0 || shnum != len(ef.Sections) || l.shstrndx >= shnum {
+		return nil, errors.New("unsupported section header table")
+	}
+
+	for i := 0; i < shnum; i++ {
+		off := shoff + uint64(i)*shentsize
+
+		if off+shentsize > uint64(len(data)) {
+			return nil, errors.New("section header out of range")
+		}
+
+		sr := bytes.NewReader(data[off : off+shentsize])
+
+		var s rawSection
+
+		if l.is64 {
+			var sh elf.Section64
+			if err := binary.Read(sr, l.order, &sh); err != nil {
+				return nil, err
+			}
+
+			s = rawSection{
+				sh.Name, sh.Type, sh.Flags, sh.Addr, sh.Off, sh.Size,
+				sh.Link, sh.Info, sh.Addralign, sh.Entsize,
+			}
+		} else {
+			var sh elf.Section32
+			if err := binary.Read(sr, l.order, &sh); err != nil {
+				return nil, err
+			}
+
+			s = rawSection{
+				sh.Name, sh.Type, uint64(sh.Flags), uint64(sh.Addr), uint64(sh.Off), uint64(sh.Size),
+				sh.Link, sh.Info, uint64(sh.Addralign), uint64(sh.Entsize),
+			}
+		}
+
+		l.sections = append(l.sections, s)
+		l.names = append(l.names, ef.Sections[i].Name)
+	}
+
+	return l, nil
+}
+
+func (l *elfLayout) encodeSection(s rawSection) []byte {
+	var buf bytes.Buffer
+
+	if l.is64 {
+		binary.Write(&buf, l.order, &elf.Section64{
+			Name: s.Name, Type: s.Type, Flags: s.Flags, Addr: s.Addr, Off: s.Offset, Size: s.Size,
+			Link: s.Link, Info: s.Info, Addralign: s.Addralign, Entsize: s.Entsize,
+		})
+	} else {
+		binary.Write(&buf, l.order, &elf.Section32{
+			Name: s.Name, Type: s.Type, Flags: uint32(s.Flags), Addr: uint32(s.Addr), Off: uint32(s.Offset), Size: uint32(s.Size),
+			Link: s.Link, Info: s.Info, Addralign: uint32(s.Addralign), Entsize: uint32(s.Entsize),
+		})
+	}
+
+	return buf.Bytes()
+}
+
+// writeSections appends the section headers to out and points the ELF
+// header at them.
+func (l *elfLayout) writeSections(out []byte, sections []rawSection, shstrndx int) []byte {
+	align := 4
+	if l.is64 {
+		align = 8
+	}
+
+	for len(out)%align != 0 {
+		out = append(out, 0)
+	}
+
+	shoff := uint64(len(out))
+
+	for _, s := range sections {
+		out = append(out, l.encodeSection(s)...)
+	}
+
+	if l.is64 {
+		l.order.PutUint64(out[0x28:], shoff)
+		l.order.PutUint16(out[0x3c:], uint16(len(sections)))
+		l.order.PutUint16(out[0x3e:], uint16(shstrndx))
+	} else {
+		l.order.PutUint32(out[0x20:], uint32(shoff))
+		l.order.PutUint16(out[0x30:], uint16(len(sections)))
+		l.order.PutUint16(out[0x32:], uint16(shstrndx))
+	}
+
+	return out
+}
+
+// appendAligned appends b to out, aligned to align, returning the new
+// slice and the offset b was written at.
+func appendAligned(out, b []byte, align uint64) ([]byte, uint64) {
+	if align == 0 {
+		align = 1
+	}
+
+	for uint64(len(out))%align != 0 {
+		out = append(out, 0)
+	}
+
+	off := uint64(len(out))
+
+	return append(out, b...), off
+}
+
+func isDebugSection(name string, s rawSection) bool {
+	if s.Flags&uint64(elf.SHF_ALLOC) != 0 {
+		return false
+	}
+
+	return strings.HasPrefix(name, ".debug_") || strings.HasPrefix(name, ".zdebug_")
+}
+
+// readBuildID returns the build id from the GNU build id note, in hex.
+func readBuildID(ef *elf.File) (string, error) {
+	sec := ef.Section(".note.gnu.build-id")
+	if sec == nil {
+		return "", ErrNoBuildID
+	}
+
+	note, err := sec.Data()
+	if err != nil {
+		return "", err
+	}
+
+	const ntGnuBuildID = 3
+
+	for len(note) >= 12 {
+		namesz := ef.ByteOrder.Uint32(note[0:])
+		descsz := ef.ByteOrder.Uint32(note[4:])
+		typ := ef.ByteOrder.Uint32(note[8:])
+
+		nameEnd := 12 + int(alignUp(uint64(namesz), 4))
+		descEnd := nameEnd + int(alignUp(uint64(descsz), 4))
+
+		if descEnd > len(note) {
+			break
+		}
+
+		if typ == ntGnuBuildID && string(note[12:12+namesz]) == "GNU\x00" && descsz >= 2 {
+			return hex.EncodeToString(note[nameEnd : nameEnd+int(descsz)]), nil
+		}
+
+		note = note[descEnd:]
+	}
+
+	return "", ErrNoBuildID
+}
+
+// SplitDebug separates the DWARF sections of the ELF executable or shared
+// library in data from the rest of it. It returns the file without them and
+// the debug info, which is found through the build id of the file.
+func SplitDebug(data []byte) ([]byte, *DebugInfo, error) {
+	ef, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if ef.Type != elf.ET_EXEC && ef.Type != elf.ET_DYN {
+		return nil, nil, ErrNoDebugInfo
+	}
+
+	l, err := readLayout(data, ef)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	removed := make([]bool, len(l.sections))
+
+	var found bool
+
+	for i, s := range l.sections {
+		if !isDebugSection(l.names[i], s) {
+			continue
+		}
+
+		if elf.SectionType(s.Type) != elf.SHT_NOBITS && s.Offset+s.Size > uint64(len(data)) {
+			return nil, nil, errors.Errorf("section %s out of range", l.names[i])
+		}
+
+		removed[i] = true
+		found = true
+	}
+
+	if !found {
+		return nil, nil, ErrNoDebugInfo
+	}
+
+	// Relocations against the debug sections go with them.
+	for i, s := range l.sections {
+		if (elf.SectionType(s.Type) == elf.SHT_RELA || elf.SectionType(s.Type) == elf.SHT_REL) &&
+			int(s.Info) < len(removed) && removed[s.Info] {
+			removed[i] = true
+		}
+	}
+
+	if removed[l.shstrndx] {
+		return nil, nil, errors.New("section name table is a debug section")
+	}
+
+	// Removing a section renumbers the ones after it, and the section
+	// indexes in loaded data, such as those of the dynamic symbols, can't
+	// be updated.
+	for i := range l.sections {
+		if !removed[i] {
+			continue
+		}
+
+		for j := i + 1; j < len(l.sections); j++ {
+			if l.sections[j].Flags&uint64(elf.SHF_ALLOC) != 0 {
+				return nil, nil, errors.Errorf("debug section %s comes before allocated section %s", l.names[i], l.names[j])
+			}
+		}
+	}
+
+	buildID, err := readBuildID(ef)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stripped, err := l.strip(data, ef, removed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return stripped, &DebugInfo{BuildID: buildID, Data: l.debugFile(data, removed)}, nil
+}
+
+// strip returns data without the removed sections. Everything covered by
+// the program headers stays where it is, the remaining sections are packed
+// after it.
+func (l *elfLayout) strip(data []byte, ef *elf.File, removed []bool) ([]byte, error) {
+	var loadEnd uint64
+
+	for _, p := range ef.Progs {
+		if end := p.Off + p.Filesz; end > loadEnd {
+			loadEnd = end
+		}
+	}
+
+	if uint64(l.ehsize) > loadEnd {
+		loadEnd = uint64(l.ehsize)
+	}
+
+	if loadEnd > uint64(len(data)) {
+		return nil, errors.New("program header out of range")
+	}
+
+	index := make([]uint32, len(l.sections))
+
+	var (
+		next     uint32
+		sections []rawSection
+	)
+
+	for i := range l.sections {
+		if removed[i] {
+			continue
+		}
+
+		index[i] = next
+		next++
+	}
+
+	for i, s := range l.sections {
+		if !removed[i] && elf.SectionType(s.Type) != elf.SHT_NOBITS && s.Offset+s.Size > uint64(len(data)) {
+			return nil, errors.Errorf("section %s out of range", l.names[i])
+		}
+	}
+
+	// The symbol tables, and the extended section indexes that go with
+	// them, refer to sections by index.
+	remapped := map[int][]byte{}
+
+	for i, s := range l.sections {
+		if removed[i] || elf.SectionType(s.Type) != elf.SHT_SYMTAB {
+			continue
+		}
+
+		xindex := -1
+
+		for j, x := range l.sections {
+			if !removed[j] && elf.SectionType(x.Type) == elf.SHT_SYMTAB_SHNDX && int(x.Link) == i {
+				xindex = j
+			}
+		}
+
+		var xcontent []byte
+
+		if xindex != -1 {
+			x := l.sections[xindex]
+			xcontent = data[x.Offset : x.Offset+x.Size]
+		}
+
+		syms, xsyms := l.remapSymbols(data[s.Offset:s.Offset+s.Size], xcontent, index, removed)
+
+		remapped[i] = syms
+
+		if xindex != -1 {
+			remapped[xindex] = xsyms
+		}
+	}
+
+	out := append([]byte(nil), data[:loadEnd]...)
+
+	for i, s := range l.sections {
+		if removed[i] {
+			continue
+		}
+
+		if i > 0 && elf.SectionType(s.Type) != elf.SHT_NOBITS && s.Offset+s.Size > loadEnd {
+			content := data[s.Offset : s.Offset+s.Size]
+
+			if r, ok := remapped[i]; ok {
+				content = r
+			}
+
+			out, s.Offset = appendAligned(out, content, s.Addralign)
+		}
+
+		if s.Link != 0 && int(s.Link) < len(index) {
+			s.Link = index[s.Link]
+		}
+
+		typ := elf.SectionType(s.Type)
+
+		if (typ == elf.SHT_REL || typ == elf.SHT_RELA || s.Flags&uint64(elf.SHF_INFO_LINK) != 0) &&
+			s.Info != 0 && int(s.Info) < len(index) {
+			s.Info = index[s.Info]
+		}
+
+		sections = append(sections, s)
+	}
+
+	return l.writeSections(out, sections, int(index[l.shstrndx])), nil
+}
+
+// remapSymbols updates the section indexes of the symbols in a symbol
+// table for the removed sections, along with those in xcontent, its table
+// of extended section indexes, if it has one. Symbols in removed sections
+// become absolute.
+func (l *elfLayout) remapSymbols(content, xcontent []byte, index []uint32, removed []bool) ([]byte, []byte) {
+	content = append([]byte(nil), content...)
+	xcontent = append([]byte(nil), xcontent...)
+
+	size, shndxOff := 16, 14
+	if l.is64 {
+		size, shndxOff = 24, 6
+	}
+
+	for off, n := 0, 0; off+size <= len(content); off, n = off+size, n+1 {
+		b := content[off+shndxOff:]
+
+		shndx := uint32(l.order.Uint16(b))
+
+		// The index is in the extended table when it doesn't fit.
+		if shndx == uint32(elf.SHN_XINDEX) && 4*n+4 <= len(xcontent) {
+			x := xcontent[4*n:]
+
+			shndx = l.order.Uint32(x)
+
+			if shndx == 0 || int(shndx) >= len(index) {
+				continue
+			}
+
+			if removed[shndx] {
+				l.order.PutUint32(x, 0)
+				l.order.PutUint16(b, uint16(elf.SHN_ABS))
+			} else {
+				l.order.PutUint32(x, index[shndx])
+			}
+
+			continue
+		}
+
+		if shndx == 0 || shndx >= uint32(elf.SHN_LORESERVE) || int(shndx) >= len(index) {
+			continue
+		}
+
+		if removed[shndx] {
+			l.order.PutUint16(b, uint16(elf.SHN_ABS))
+		} else {
+			l.order.PutUint16(b, uint16(index[shndx]))
+		}
+	}
+
+	return content, xcontent
+}
+
+// debugFile returns an ELF file with the removed sections and the build id
+// note. The other allocated sections are kept as headers without contents,
+// the way objcopy --only-keep-debug does, so debuggers can match the debug
+// info to the sections of the original file.
+func (l *elfLayout) debugFile(data []byte, removed []bool) []byte {
+	out := append([]byte(nil), data[:l.ehsize]...)
+
+	// No program headers
+	if l.is64 {
+		l.order.PutUint64(out[0x20:], 0)
+		l.order.PutUint16(out[0x38:], 0)
+	} else {
+		l.order.PutUint32(out[0x1c:], 0)
+		l.order.PutUint16(out[0x2c:], 0)
+	}
+
+	shstrtab := []byte{0}
+
+	addName := func(name string) uint32 {
+		off := uint32(len(shstrtab))
+		shstrtab = append(shstrtab, name...)
+		shstrtab = append(shstrtab, 0)
+		return off
+	}
+
+	sections := []rawSection{{}}
+
+	for i, s := range l.sections {
+		if i == 0 {
+			continue
+		}
+
+		keep := removed[i] && isDebugSection(l.names[i], s)
+
+		switch {
+		case keep || l.names[i] == ".note.gnu.build-id":
+			out, s.Offset = appendAligned(out, data[s.Offset:s.Offset+s.Size], s.Addralign)
+		case s.Flags&uint64(elf.SHF_ALLOC) != 0:
+			s.Type = uint32(elf.SHT_NOBITS)
+			s.Offset = uint64(len(out))
+		default:
+			continue
+		}
+
+		s.Name = addName(l.names[i])
+		s.Link = 0
+		s.Info = 0
+
+		sections = append(sections, s)
+	}
+
+	name := addName(".shstrtab")
+
+	var off uint64
+	out, off = appendAligned(out, shstrtab, 1)
+
+	sections = append(sections, rawSection{
+		Name:      name,
+		Type:      uint32(elf.SHT_STRTAB),
+		Offset:    off,
+		Size:      uint64(len(shstrtab)),
+		Addralign: 1,
+	})
+
+	return l.writeSections(out, sections, len(sections)-1)
+}
@@ -0,0 +1,241 @@
+package rpath
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitDebug(t *testing.T) {
+	for _, name := range []string{"hellodbg", "progdbg32"} {
+		t.Run("splits the debug info of "+name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+			require.NoError(t, err)
+
+			stripped, info, err := SplitDebug(data)
+			require.NoError(t, err)
+
+			assert.True(t, len(stripped) < len(data))
+			assert.Len(t, info.BuildID, 40)
+			assert.Equal(t, filepath.Join(".build-id", info.BuildID[:2], info.BuildID[2:]+".debug"), info.Path())
+
+			ef, err := elf.NewFile(bytes.NewReader(stripped))
+			require.NoError(t, err)
+
+			for _, sec := range ef.Sections {
+				assert.False(t, strings.HasPrefix(sec.Name, ".debug_"), sec.Name)
+			}
+
+			_, err = ef.DWARF()
+			assert.Error(t, err)
+
+			// The symbols and dynamic information are left alone.
+			syms, err := ef.Symbols()
+			require.NoError(t, err)
+			assert.NotEmpty(t, syms)
+
+			libs, err := ef.ImportedLibraries()
+			require.NoError(t, err)
+			assert.NotEmpty(t, libs)
+
+			df, err := elf.NewFile(bytes.NewReader(info.Data))
+			require.NoError(t, err)
+
+			assert.Equal(t, ef.Machine, df.Machine)
+			assert.Empty(t, df.Progs)
+
+			// The allocated sections are there to map the debug info onto.
+			text := df.Section(".text")
+			require.NotNil(t, text)
+			assert.Equal(t, elf.SHT_NOBITS, text.Type)
+			assert.Equal(t, ef.Section(".text").Addr, text.Addr)
+
+			id, err := readBuildID(df)
+			require.NoError(t, err)
+			assert.Equal(t, info.BuildID, id)
+
+			dw, err := df.DWARF()
+			require.NoError(t, err)
+
+			entry, err := dw.Reader().Next()
+			require.NoError(t, err)
+
+			assert.Equal(t, dwarf.TagCompileUnit, entry.Tag)
+			assert.Contains(t, entry.Val(dwarf.AttrName), ".c")
+		})
+	}
+
+	t.Run("skips files without debug info", func(t *testing.T) {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "hello"))
+		require.NoError(t, err)
+
+		_, _, err = SplitDebug(data)
+		assert.ErrorIs(t, err, ErrNoDebugInfo)
+	})
+
+	t.Run("produces programs that run", func(t *testing.T) {
+		if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+			t.Skip("fixtures are for linux/amd64")
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "hellodbg"))
+		require.NoError(t, err)
+
+		stripped, _, err := SplitDebug(data)
+		require.NoError(t, err)
+
+		tmp, err := ioutil.TempFile("", "debug")
+		require.NoError(t, err)
+
+		defer os.Remove(tmp.Name())
+
+		_, err = tmp.Write(stripped)
+		require.NoError(t, err)
+		require.NoError(t, tmp.Chmod(0755))
+		require.NoError(t, tmp.Close())
+
+		out, err := exec.Command(tmp.Name()).CombinedOutput()
+		require.NoError(t, err, string(out))
+
+		assert.Equal(t, "hello\n", string(out))
+	})
+
+	t.Run("strips programs built by gcc", func(t *testing.T) {
+		if _, err := exec.LookPath("gcc"); err != nil {
+			t.Skip("gcc not available")
+		}
+
+		top, err := ioutil.TempDir("", "debug")
+		require.NoError(t, err)
+
+		defer os.RemoveAll(top)
+
+		prog := filepath.Join(top, "hello")
+
+		out, err := exec.Command("gcc", "-g", "-O1", "-Wl,--build-id",
+			"-o", prog, filepath.Join("testdata", "hello.c")).CombinedOutput()
+		require.NoError(t, err, string(out))
+
+		data, err := ioutil.ReadFile(prog)
+		require.NoError(t, err)
+
+		orig, err := elf.NewFile(bytes.NewReader(data))
+		require.NoError(t, err)
+
+		stripped, info, err := SplitDebug(data)
+		require.NoError(t, err)
+
+		require.NoError(t, ioutil.WriteFile(prog, stripped, 0755))
+
+		out, err = exec.Command(prog).CombinedOutput()
+		require.NoError(t, err, string(out))
+
+		assert.Equal(t, "hello\n", string(out))
+
+		ef, err := elf.Open(prog)
+		require.NoError(t, err)
+
+		defer ef.Close()
+
+		for _, sec := range ef.Sections {
+			assert.False(t, strings.HasPrefix(sec.Name, ".debug_"), sec.Name)
+
+			if sec.Type != elf.SHT_NOBITS {
+				_, err := sec.Data()
+				assert.NoError(t, err, sec.Name)
+			}
+		}
+
+		// Symbols still refer to the sections they did.
+		syms, err := ef.Symbols()
+		require.NoError(t, err)
+
+		origSyms, err := orig.Symbols()
+		require.NoError(t, err)
+
+		require.Len(t, syms, len(origSyms))
+
+		for i, sym := range syms {
+			if sym.Section == elf.SHN_UNDEF || sym.Section >= elf.SHN_LORESERVE {
+				continue
+			}
+
+			assert.Equal(t, orig.Sections[origSyms[i].Section].Name, ef.Sections[sym.Section].Name, sym.Name)
+		}
+
+		df, err := elf.NewFile(bytes.NewReader(info.Data))
+		require.NoError(t, err)
+
+		_, err = df.DWARF()
+		assert.NoError(t, err)
+	})
+
+	t.Run("skips files with debug sections before allocated ones", func(t *testing.T) {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "hellodbg"))
+		require.NoError(t, err)
+
+		ef, err := elf.NewFile(bytes.NewReader(data))
+		require.NoError(t, err)
+
+		l, err := readLayout(data, ef)
+		require.NoError(t, err)
+
+		// Mark the last section as allocated, sh_flags follows sh_name and
+		// sh_type in the section header.
+		shoff := ef.ByteOrder.Uint64(data[0x28:])
+		shentsize := uint64(ef.ByteOrder.Uint16(data[0x3a:]))
+
+		last := uint64(len(l.sections) - 1)
+		ef.ByteOrder.PutUint64(data[shoff+last*shentsize+8:], l.sections[last].Flags|uint64(elf.SHF_ALLOC))
+
+		_, _, err = SplitDebug(data)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "comes before allocated section")
+	})
+
+	t.Run("remaps extended section indexes", func(t *testing.T) {
+		l := &elfLayout{is64: true, order: binary.LittleEndian}
+
+		sym := func(shndx uint16) []byte {
+			b := make([]byte, 24)
+			binary.LittleEndian.PutUint16(b[6:], shndx)
+			return b
+		}
+
+		var content, xcontent []byte
+
+		for _, shndx := range []uint16{uint16(elf.SHN_XINDEX), uint16(elf.SHN_XINDEX), 3} {
+			content = append(content, sym(shndx)...)
+		}
+
+		for _, x := range []uint32{2, 3, 0} {
+			b := make([]byte, 4)
+			binary.LittleEndian.PutUint32(b, x)
+			xcontent = append(xcontent, b...)
+		}
+
+		index := []uint32{0, 1, 0, 2}
+		removed := []bool{false, false, true, false}
+
+		syms, xsyms := l.remapSymbols(content, xcontent, index, removed)
+
+		assert.Equal(t, uint16(elf.SHN_ABS), binary.LittleEndian.Uint16(syms[6:]))
+		assert.Equal(t, uint32(0), binary.LittleEndian.Uint32(xsyms[0:]))
+
+		assert.Equal(t, uint16(elf.SHN_XINDEX), binary.LittleEndian.Uint16(syms[24+6:]))
+		assert.Equal(t, uint32(2), binary.LittleEndian.Uint32(xsyms[4:]))
+
+		assert.Equal(t, uint16(2), binary.LittleEndian.Uint16(syms[48+6:]))
+	})
+}
@@ -0,0 +1,820 @@
+package rpath
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrNoDynamic     = errors.New("ELF file has no dynamic section")
+	ErrNoInterpreter = errors.New("ELF file has no interpreter")
+	ErrNoSegment     = errors.New("no program header available to add a segment")
+)
+
+const gnuPropertySegment = 0x6474e553
+
+// Program header flags
+const (
+	pfW = 0x2
+	pfR = 0x4
+)
+
+// segment is a program header, independent of the ELF class.
+type segment struct {
+	Type     ProgramHeaderType
+	Flags    ProgramHeaderFlags
+	Offset   uint64
+	VAddr    uint64
+	PAddr    uint64
+	FileSize uint64
+	MemSize  uint64
+	Align    uint64
+}
+
+type dynEntry struct {
+	Tag   int64
+	Value uint64
+}
+
+// Editor changes the dynamic linking information of an ELF file: its
+// interpreter, the libraries it needs and where they're searched for.
+// Values are changed in place when they fit, otherwise the dynamic string
+// table, dynamic section and interpreter are moved to a segment at the end
+// of the file. Both ELF32 and ELF64 files are supported.
+type Editor struct {
+	raw   []byte
+	ef    ELFFile
+	is64  bool
+	order binary.ByteOrder
+
+	phoff, phentsize uint64
+	shoff, shentsize uint64
+
+	segments []*segment
+
+	dynSeg, interpSeg         int
+	dynSec, strSec, interpSec int
+
+	// The entries of the dynamic section, not including the terminating
+	// DT_NULL, and how many entries fit in the section.
+	dynamic []dynEntry
+	dynCap  int
+
+	// The dynamic string table, which only grows, so that the offsets in it
+	// used elsewhere stay valid.
+	strtab  []byte
+	strSize int
+
+	interp string
+}
+
+// NewEditor returns an Editor for the ELF file in data.
+func NewEditor(data []byte) (*Editor, error) {
+	ef, err := ParseELFFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Editor{
+		raw:       data,
+		ef:        ef,
+		dynSeg:    -1,
+		interpSeg: -1,
+		dynSec:    -1,
+		strSec:    -1,
+		interpSec: -1,
+	}
+
+	if data[5] == 2 {
+		e.order = binary.BigEndian
+	} else {
+		e.order = binary.LittleEndian
+	}
+
+	switch f := ef.(type) {
+	case *ELF64File:
+		e.is64 = true
+		e.phoff = f.Header.ProgramHeaderOffset
+		e.phentsize = uint64(f.Header.ProgramHeaderEntrySize)
+		e.shoff = f.Header.SectionHeaderOffset
+		e.shentsize = uint64(f.Header.SectionHeaderEntrySize)
+	case *ELF32File:
+		e.phoff = uint64(f.Header.ProgramHeaderOffset)
+		e.phentsize = uint64(f.Header.ProgramHeaderEntrySize)
+		e.shoff = uint64(f.Header.SectionHeaderOffset)
+		e.shentsize = uint64(f.Header.SectionHeaderEntrySize)
+	}
+
+	for i := uint16(0); i < ef.GetSegmentCount(); i++ {
+		ph, err := ef.GetProgramHeader(i)
+		if err != nil {
+			return nil, err
+		}
+
+		seg := &segment{
+			Type:     ph.GetType(),
+			Flags:    ph.GetFlags(),
+			Offset:   ph.GetFileOffset(),
+			VAddr:    ph.GetVirtualAddress(),
+			PAddr:    ph.GetPhysicalAddress(),
+			FileSize: ph.GetFileSize(),
+			MemSize:  ph.GetMemorySize(),
+			Align:    ph.GetAlignment(),
+		}
+
+		switch seg.Type {
+		case DynamicLinkingSegment:
+			e.dynSeg = int(i)
+		case InterpreterSegment:
+			e.interpSeg = int(i)
+		}
+
+		e.segments = append(e.segments, seg)
+	}
+
+	for i := uint16(0); i < ef.GetSectionCount(); i++ {
+		if ef.IsDynamicSection(i) {
+			e.dynSec = int(i)
+			continue
+		}
+
+		if name, err := ef.GetSectionName(i); err == nil && name == ".interp" {
+			e.interpSec = int(i)
+		}
+	}
+
+	if e.dynSeg == -1 || e.dynSec == -1 {
+		return nil, ErrNoDynamic
+	}
+
+	hdr, err := ef.GetSectionHeader(uint16(e.dynSec))
+	if err != nil {
+		return nil, err
+	}
+
+	e.dynCap = int(hdr.GetSize() / e.dynEntSize())
+	e.strSec = int(hdr.GetLinkedIndex())
+
+	strtab, err := ef.GetSectionContent(uint16(e.strSec))
+	if err != nil {
+		return nil, err
+	}
+
+	e.strtab = append([]byte(nil), strtab...)
+	e.strSize = len(strtab)
+
+	entries, err := ef.DynamicEntries(uint16(e.dynSec))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ent := range entries {
+		if ent.GetTag().GetValue() == DT_NULL {
+			break
+		}
+
+		e.dynamic = append(e.dynamic, dynEntry{Tag: ent.GetTag().GetValue(), Value: ent.GetValue()})
+	}
+
+	if e.interpSeg != -1 {
+		content, err := ef.GetSegmentContent(uint16(e.interpSeg))
+		if err != nil {
+			return nil, err
+		}
+
+		e.interp = NullStr(content)
+	}
+
+	return e, nil
+}
+
+func (e *Editor) wordSize() uint64 {
+	if e.is64 {
+		return 8
+	}
+
+	return 4
+}
+
+func (e *Editor) dynEntSize() uint64 {
+	return 2 * e.wordSize()
+}
+
+func (e *Editor) putWord(b []byte, v uint64) {
+	if e.is64 {
+		e.order.PutUint64(b, v)
+	} else {
+		e.order.PutUint32(b, uint32(v))
+	}
+}
+
+// str returns the string at off in the dynamic string table.
+func (e *Editor) str(off uint64) string {
+	if off >= uint64(len(e.strtab)) {
+		return ""
+	}
+
+	return NullStr(e.strtab[off:])
+}
+
+// addString returns the offset of s in the dynamic string table, adding it
+// if it's not already there.
+func (e *Editor) addString(s string) uint64 {
+	if idx := bytes.Index(e.strtab, append([]byte(s), 0)); idx != -1 {
+		return uint64(idx)
+	}
+
+	off := len(e.strtab)
+
+	e.strtab = append(e.strtab, s...)
+	e.strtab = append(e.strtab, 0)
+
+	return uint64(off)
+}
+
+// Interpreter returns the path of the program interpreter, or "" if there
+// isn't one.
+func (e *Editor) Interpreter() string {
+	return e.interp
+}
+
+// SetInterpreter changes the program interpreter to path.
+func (e *Editor) SetInterpreter(path string) error {
+	if e.interpSeg == -1 {
+		return ErrNoInterpreter
+	}
+
+	e.interp = path
+
+	return nil
+}
+
+// searchPath returns the entries of RUNPATH, or of RPATH if there's no
+// RUNPATH, along with the tag they came from.
+func (e *Editor) searchPath() ([]string, int64) {
+	var (
+		rpath []string
+		found int64
+	)
+
+	for _, ent := range e.dynamic {
+		switch ent.Tag {
+		case DT_RUNPATH:
+			return strings.Split(e.str(ent.Value), ":"), DT_RUNPATH
+		case DT_RPATH:
+			rpath = strings.Split(e.str(ent.Value), ":")
+			found = DT_RPATH
+		}
+	}
+
+	return rpath, found
+}
+
+// RunPath returns the directories libraries are searched for in, from
+// RUNPATH or, if there's no RUNPATH, RPATH.
+func (e *Editor) RunPath() []string {
+	paths, _ := e.searchPath()
+	return paths
+}
+
+// HasRPath returns true if the file uses the deprecated RPATH.
+func (e *Editor) HasRPath() bool {
+	for _, ent := range e.dynamic {
+		if ent.Tag == DT_RPATH {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetRunPath sets RUNPATH to paths, removing any RPATH. With no paths,
+// both are removed.
+func (e *Editor) SetRunPath(paths []string) {
+	e.setSearchPath(paths, DT_RUNPATH)
+}
+
+// ConvertRPath changes RPATH to RUNPATH, keeping its value.
+func (e *Editor) ConvertRPath() {
+	if e.HasRPath() {
+		e.SetRunPath(e.RunPath())
+	}
+}
+
+// setSearchPath sets the entry with tag to paths, replacing both RPATH and
+// RUNPATH.
+func (e *Editor) setSearchPath(paths []string, tag int64) {
+	var (
+		out  []dynEntry
+		prev = -1
+	)
+
+	for _, ent := range e.dynamic {
+		if ent.Tag == DT_RPATH || ent.Tag == DT_RUNPATH {
+			if prev == -1 {
+				prev = len(out)
+				out = append(out, ent)
+			}
+
+			continue
+		}
+
+		out = append(out, ent)
+	}
+
+	if len(paths) == 0 {
+		if prev != -1 {
+			out = append(out[:prev], out[prev+1:]...)
+		}
+
+		e.dynamic = out
+
+		return
+	}
+
+	value := strings.Join(paths, ":")
+
+	if prev == -1 {
+		out = append(out, dynEntry{Tag: tag, Value: e.addString(value)})
+	} else {
+		out[prev].Tag = tag
+		out[prev].Value = e.replaceString(out[prev].Value, value)
+	}
+
+	e.dynamic = out
+}
+
+// replaceString returns the offset of s in the string table, overwriting
+// the string at off with it if it fits and nothing else refers to it.
+func (e *Editor) replaceString(off uint64, s string) uint64 {
+	if idx := bytes.Index(e.strtab, append([]byte(s), 0)); idx != -1 {
+		return uint64(idx)
+	}
+
+	old := e.str(off)
+
+	if len(s) > len(old) {
+		return e.addString(s)
+	}
+
+	end := off + uint64(len(old))
+
+	users := 0
+
+	for _, ent := range e.dynamic {
+		switch ent.Tag {
+		case DT_NEEDED, DT_SONAME, DT_RPATH, DT_RUNPATH:
+			if ent.Value >= off && ent.Value < end {
+				users++
+			}
+		}
+	}
+
+	// Symbol names and versions can share the string, or be merged into
+	// its tail, as well.
+	syms, err := e.symbolRefs()
+	if err != nil || users > 1 || hasRef(syms, off) || refersInside(syms, off, end) {
+		return e.addString(s)
+	}
+
+	copy(e.strtab[off:], s)
+
+	for i := off + uint64(len(s)); i < off+uint64(len(old)); i++ {
+		e.strtab[i] = 0
+	}
+
+	return off
+}
+
+// symbolRefs returns the offsets in the dynamic string table that the
+// dynamic symbols and symbol versions refer to.
+func (e *Editor) symbolRefs() ([]uint64, error) {
+	ef, err := elf.NewFile(bytes.NewReader(e.raw))
+	if err != nil {
+		return nil, err
+	}
+
+	return symbolStringRefs(ef)
+}
+
+func hasRef(refs []uint64, off uint64) bool {
+	for _, r := range refs {
+		if r == off {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Needed returns the libraries the file needs, in order.
+func (e *Editor) Needed() []string {
+	var needed []string
+
+	for _, ent := range e.dynamic {
+		if ent.Tag == DT_NEEDED {
+			needed = append(needed, e.str(ent.Value))
+		}
+	}
+
+	return needed
+}
+
+// AddNeeded adds lib to the libraries the file needs, after the existing
+// ones. It does nothing if lib is already needed.
+func (e *Editor) AddNeeded(lib string) {
+	last := -1
+
+	for i, ent := range e.dynamic {
+		if ent.Tag == DT_NEEDED {
+			if e.str(ent.Value) == lib {
+				return
+			}
+
+			last = i
+		}
+	}
+
+	ent := dynEntry{Tag: DT_NEEDED, Value: e.addString(lib)}
+
+	var out []dynEntry
+
+	out = append(out, e.dynamic[:last+1]...)
+	out = append(out, ent)
+	out = append(out, e.dynamic[last+1:]...)
+
+	e.dynamic = out
+}
+
+// RemoveNeeded removes lib from the libraries the file needs, returning
+// false if it wasn't needed.
+func (e *Editor) RemoveNeeded(lib string) bool {
+	var (
+		out     []dynEntry
+		removed bool
+	)
+
+	for _, ent := range e.dynamic {
+		if ent.Tag == DT_NEEDED && e.str(ent.Value) == lib {
+			removed = true
+			continue
+		}
+
+		out = append(out, ent)
+	}
+
+	e.dynamic = out
+
+	return removed
+}
+
+func alignUp(v, align uint64) uint64 {
+	if align <= 1 {
+		return v
+	}
+
+	return (v + align - 1) / align * align
+}
+
+func (e *Editor) setDynamic(tag int64, value uint64) {
+	for i := range e.dynamic {
+		if e.dynamic[i].Tag == tag {
+			e.dynamic[i].Value = value
+		}
+	}
+}
+
+func (e *Editor) encodeDynamic(slots int) []byte {
+	sz := e.dynEntSize()
+	buf := make([]byte, uint64(slots)*sz)
+
+	for i, ent := range e.dynamic {
+		b := buf[uint64(i)*sz:]
+		e.putWord(b, uint64(ent.Tag))
+		e.putWord(b[e.wordSize():], ent.Value)
+	}
+
+	return buf
+}
+
+// addedSegment returns the index of the segment to put moved data in and
+// whether that segment already exists at the end of the file and can be
+// extended.
+func (e *Editor) addedSegment() (int, bool, error) {
+	var last = -1
+
+	for i, s := range e.segments {
+		if s.Type == LoadableSegment && (last == -1 || s.VAddr > e.segments[last].VAddr) {
+			last = i
+		}
+	}
+
+	// A segment from an earlier edit is at the end of the file, so it can
+	// be grown.
+	if last != -1 {
+		s := e.segments[last]
+		if s.Offset+s.FileSize == uint64(len(e.raw)) && s.FileSize == s.MemSize {
+			return last, true, nil
+		}
+	}
+
+	for i, s := range e.segments {
+		if s.Type == NullSegment {
+			return i, false, nil
+		}
+	}
+
+	// Notes described by PT_GNU_PROPERTY are still found through it, so
+	// prefer those. Otherwise use the last note, losing it from the
+	// program headers, though it's still available as a section.
+	note := -1
+
+	for i, s := range e.segments {
+		if s.Type != NoteSegment {
+			continue
+		}
+
+		note = i
+
+		for _, p := range e.segments {
+			if p.Type == gnuPropertySegment && p.Offset == s.Offset && p.FileSize == s.FileSize {
+				return i, false, nil
+			}
+		}
+	}
+
+	if note == -1 {
+		return 0, false, ErrNoSegment
+	}
+
+	return note, false, nil
+}
+
+// Bytes returns the edited file.
+func (e *Editor) Bytes() ([]byte, error) {
+	out := append([]byte(nil), e.raw...)
+
+	strHdr, err := e.ef.GetSectionHeader(uint16(e.strSec))
+	if err != nil {
+		return nil, err
+	}
+
+	dynHdr, err := e.ef.GetSectionHeader(uint16(e.dynSec))
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		moveStr    = len(e.strtab) > e.strSize
+		moveDyn    = len(e.dynamic)+1 > e.dynCap
+		moveInterp = false
+		interpData = append([]byte(e.interp), 0)
+	)
+
+	if e.interpSeg != -1 && uint64(len(interpData)) > e.segments[e.interpSeg].FileSize {
+		moveInterp = true
+	}
+
+	if !moveStr {
+		copy(out[strHdr.GetFileOffset():], e.strtab)
+	}
+
+	if e.interpSeg != -1 && !moveInterp {
+		s := e.segments[e.interpSeg]
+
+		buf := make([]byte, s.FileSize)
+		copy(buf, interpData)
+		copy(out[s.Offset:], buf)
+	}
+
+	if !moveStr && !moveDyn && !moveInterp {
+		copy(out[dynHdr.GetFileOffset():], e.encodeDynamic(e.dynCap))
+		return out, nil
+	}
+
+	idx, extend, err := e.addedSegment()
+	if err != nil {
+		return nil, err
+	}
+
+	seg := e.segments[idx]
+
+	var (
+		base  uint64
+		vbase uint64
+	)
+
+	if extend {
+		base = alignUp(seg.Offset+seg.FileSize, e.wordSize())
+		vbase = seg.VAddr + (base - seg.Offset)
+	} else {
+		var (
+			pageSize uint64 = 0x1000
+			end      uint64
+		)
+
+		for _, s := range e.segments {
+			if s.Type != LoadableSegment {
+				continue
+			}
+
+			if s.Align > pageSize {
+				pageSize = s.Align
+			}
+
+			if s.VAddr+s.MemSize > end {
+				end = s.VAddr + s.MemSize
+			}
+		}
+
+		base = alignUp(uint64(len(out)), e.wordSize())
+
+		// The offset and address only have to agree modulo the page size,
+		// so the file doesn't need padding to a page boundary.
+		vbase = alignUp(end, pageSize) + base%pageSize
+
+		*seg = segment{
+			Type:   LoadableSegment,
+			Flags:  pfR | pfW,
+			Offset: base,
+			VAddr:  vbase,
+			PAddr:  vbase,
+			Align:  pageSize,
+		}
+	}
+
+	var blob []byte
+
+	// place reserves space for data in the new segment, returning its
+	// offset and address.
+	place := func(data []byte) (uint64, uint64) {
+		pos := alignUp(uint64(len(blob)), e.wordSize())
+		blob = append(blob, make([]byte, pos-uint64(len(blob)))...)
+		blob = append(blob, data...)
+
+		return base + pos, vbase + pos
+	}
+
+	if moveInterp {
+		off, addr := place(interpData)
+
+		s := e.segments[e.interpSeg]
+		s.Offset, s.VAddr, s.PAddr = off, addr, addr
+		s.FileSize, s.MemSize = uint64(len(interpData)), uint64(len(interpData))
+
+		if e.interpSec != -1 {
+			e.putSection(out, e.interpSec, addr, off, uint64(len(interpData)))
+		}
+	}
+
+	if moveStr {
+		off, addr := place(e.strtab)
+
+		e.setDynamic(DT_STRTAB, addr)
+		e.setDynamic(DT_STRSZ, uint64(len(e.strtab)))
+
+		e.putSection(out, e.strSec, addr, off, uint64(len(e.strtab)))
+	}
+
+	if moveDyn {
+		// Leave room for more entries so later edits can be done in place.
+		slots := len(e.dynamic) + 4
+
+		data := e.encodeDynamic(slots)
+		off, addr := place(data)
+
+		s := e.segments[e.dynSeg]
+		s.Offset, s.VAddr, s.PAddr = off, addr, addr
+		s.FileSize, s.MemSize = uint64(len(data)), uint64(len(data))
+
+		e.putSection(out, e.dynSec, addr, off, uint64(len(data)))
+	} else {
+		copy(out[dynHdr.GetFileOffset():], e.encodeDynamic(e.dynCap))
+	}
+
+	out = append(out, make([]byte, base-uint64(len(out)))...)
+	out = append(out, blob...)
+
+	seg.FileSize = uint64(len(out)) - seg.Offset
+	seg.MemSize = seg.FileSize
+	seg.Flags |= pfR | pfW
+
+	e.putSegments(out, idx)
+
+	return out, nil
+}
+
+// putSection updates the location of section idx in out.
+func (e *Editor) putSection(out []byte, idx int, addr, offset, size uint64) {
+	b := out[e.shoff+uint64(idx)*e.shentsize:]
+
+	if e.is64 {
+		e.order.PutUint64(b[16:], addr)
+		e.order.PutUint64(b[24:], offset)
+		e.order.PutUint64(b[32:], size)
+	} else {
+		e.order.PutUint32(b[12:], uint32(addr))
+		e.order.PutUint32(b[16:], uint32(offset))
+		e.order.PutUint32(b[20:], uint32(size))
+	}
+}
+
+// putSegments writes the program headers to out. The segment at added is
+// moved after the other loadable segments, since they have to be sorted by
+// address.
+func (e *Editor) putSegments(out []byte, added int) {
+	order := make([]int, 0, len(e.segments))
+
+	for i := range e.segments {
+		if i != added {
+			order = append(order, i)
+		}
+	}
+
+	lastLoad := -1
+
+	for pos, i := range order {
+		if e.segments[i].Type == LoadableSegment {
+			lastLoad = pos
+		}
+	}
+
+	order = append(order, 0)
+	copy(order[lastLoad+2:], order[lastLoad+1:])
+	order[lastLoad+1] = added
+
+	for pos, i := range order {
+		s := e.segments[i]
+		b := out[e.phoff+uint64(pos)*e.phentsize:]
+
+		if e.is64 {
+			e.order.PutUint32(b[0:], uint32(s.Type))
+			e.order.PutUint32(b[4:], uint32(s.Flags))
+			e.order.PutUint64(b[8:], s.Offset)
+			e.order.PutUint64(b[16:], s.VAddr)
+			e.order.PutUint64(b[24:], s.PAddr)
+			e.order.PutUint64(b[32:], s.FileSize)
+			e.order.PutUint64(b[40:], s.MemSize)
+			e.order.PutUint64(b[48:], s.Align)
+		} else {
+			e.order.PutUint32(b[0:], uint32(s.Type))
+			e.order.PutUint32(b[4:], uint32(s.Offset))
+			e.order.PutUint32(b[8:], uint32(s.VAddr))
+			e.order.PutUint32(b[12:], uint32(s.PAddr))
+			e.order.PutUint32(b[16:], uint32(s.FileSize))
+			e.order.PutUint32(b[20:], uint32(s.MemSize))
+			e.order.PutUint32(b[24:], uint32(s.Flags))
+			e.order.PutUint32(b[28:], uint32(s.Align))
+		}
+	}
+}
+
+// EditFile applies the changes fn makes to the ELF file at path.
+func EditFile(path string, fn func(e *Editor) error) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "attempting to read ELF file (%s)", fi.Mode().Perm().String())
+	}
+
+	e, err := NewEditor(data)
+	if err != nil {
+		return err
+	}
+
+	err = fn(e)
+	if err != nil {
+		return err
+	}
+
+	out, err := e.Bytes()
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+
+	err = ioutil.WriteFile(tmp, out, fi.Mode().Perm())
+	if err != nil {
+		return errors.Wrapf(err, "attempting to open the file for rewrite")
+	}
+
+	// WriteFile only applies the mode to new files.
+	err = os.Chmod(tmp, fi.Mode().Perm())
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
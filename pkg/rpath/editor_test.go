@@ -0,0 +1,212 @@
+package rpath
+
+import (
+	"bytes"
+	"debug/elf"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEditor(t *testing.T) {
+	load := func(t *testing.T, name string) (*Editor, []byte) {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		require.NoError(t, err)
+
+		e, err := NewEditor(data)
+		require.NoError(t, err)
+
+		return e, data
+	}
+
+	// check parses out with debug/elf, which also makes sure the dynamic
+	// symbols can still be read.
+	check := func(t *testing.T, out []byte) *elf.File {
+		ef, err := elf.NewFile(bytes.NewReader(out))
+		require.NoError(t, err)
+
+		_, err = ef.DynamicSymbols()
+		require.NoError(t, err)
+
+		return ef
+	}
+
+	interp := func(t *testing.T, ef *elf.File) string {
+		for _, p := range ef.Progs {
+			if p.Type == elf.PT_INTERP {
+				data, err := ioutil.ReadAll(p.Open())
+				require.NoError(t, err)
+
+				return strings.TrimRight(string(data), "\x00")
+			}
+		}
+
+		return ""
+	}
+
+	dynStrings := func(t *testing.T, ef *elf.File, tag elf.DynTag) []string {
+		strs, err := ef.DynString(tag)
+		require.NoError(t, err)
+
+		return strs
+	}
+
+	long := "/" + strings.Repeat("x", 300)
+
+	t.Run("reads the dynamic information", func(t *testing.T) {
+		e, _ := load(t, "hello")
+		assert.Equal(t, "/lib64/ld-linux-x86-64.so.2", e.Interpreter())
+		assert.Equal(t, []string{"libc.so.6"}, e.Needed())
+		assert.Nil(t, e.RunPath())
+
+		e, _ = load(t, "prog32")
+		assert.Equal(t, "/lib/ld-linux.so.2", e.Interpreter())
+		assert.Equal(t, []string{"libfix32.so"}, e.Needed())
+		assert.Equal(t, []string{"/opt/lib"}, e.RunPath())
+		assert.True(t, e.HasRPath())
+
+		e, _ = load(t, "libfix.so")
+		assert.Equal(t, "", e.Interpreter())
+		assert.Equal(t, []string{"/opt/iris/store/abc-dep-1.0/lib", "/usr/lib"}, e.RunPath())
+		assert.False(t, e.HasRPath())
+
+		assert.ErrorIs(t, e.SetInterpreter("/lib/ld.so"), ErrNoInterpreter)
+	})
+
+	t.Run("edits in place when the values fit", func(t *testing.T) {
+		e, data := load(t, "libfix.so")
+
+		e.SetRunPath([]string{"/usr/lib"})
+
+		out, err := e.Bytes()
+		require.NoError(t, err)
+
+		assert.Len(t, out, len(data))
+
+		ef := check(t, out)
+		assert.Equal(t, []string{"/usr/lib"}, dynStrings(t, ef, elf.DT_RUNPATH))
+	})
+
+	t.Run("converts RPATH to RUNPATH", func(t *testing.T) {
+		e, data := load(t, "libfix32.so")
+
+		e.ConvertRPath()
+
+		out, err := e.Bytes()
+		require.NoError(t, err)
+
+		assert.Len(t, out, len(data))
+
+		ef := check(t, out)
+		assert.Empty(t, dynStrings(t, ef, elf.DT_RPATH))
+		assert.Equal(t, []string{"/opt/iris/store/abc-dep-1.0/lib"}, dynStrings(t, ef, elf.DT_RUNPATH))
+	})
+
+	for _, name := range []string{"hello", "prog32"} {
+		t.Run("moves values that don't fit in "+name, func(t *testing.T) {
+			e, data := load(t, name)
+
+			needed := e.Needed()[0]
+
+			require.NoError(t, e.SetInterpreter(long+"/ld.so"))
+			e.SetRunPath([]string{long + "/lib", "/opt/lib"})
+			e.AddNeeded("libextra.so")
+
+			out, err := e.Bytes()
+			require.NoError(t, err)
+
+			assert.True(t, len(out) > len(data))
+
+			ef := check(t, out)
+
+			assert.Equal(t, long+"/ld.so", interp(t, ef))
+			assert.Equal(t, []string{long + "/lib:/opt/lib"}, dynStrings(t, ef, elf.DT_RUNPATH))
+			assert.Empty(t, dynStrings(t, ef, elf.DT_RPATH))
+			assert.Equal(t, []string{needed, "libextra.so"}, dynStrings(t, ef, elf.DT_NEEDED))
+
+			// Editing again grows the segment added by the first edit.
+			e, err = NewEditor(out)
+			require.NoError(t, err)
+
+			assert.True(t, e.RemoveNeeded(needed))
+			assert.False(t, e.RemoveNeeded("libnope.so"))
+			e.AddNeeded("libmore" + strings.Repeat("x", 100) + ".so")
+
+			out2, err := e.Bytes()
+			require.NoError(t, err)
+
+			ef2 := check(t, out2)
+
+			assert.Equal(t, len(ef.Progs), len(ef2.Progs))
+			assert.Equal(t, long+"/ld.so", interp(t, ef2))
+			assert.Equal(t, []string{"libextra.so", "libmore" + strings.Repeat("x", 100) + ".so"}, dynStrings(t, ef2, elf.DT_NEEDED))
+
+			// Loadable segments must stay sorted by address.
+			var last uint64
+
+			for _, p := range ef2.Progs {
+				if p.Type == elf.PT_LOAD {
+					assert.True(t, p.Vaddr >= last)
+					last = p.Vaddr
+				}
+			}
+		})
+	}
+
+	t.Run("produces programs that run", func(t *testing.T) {
+		if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+			t.Skip("fixtures are for linux/amd64")
+		}
+
+		ldso, err := filepath.EvalSymlinks("/lib64/ld-linux-x86-64.so.2")
+		if err != nil {
+			t.Skip("no system dynamic linker")
+		}
+
+		top, err := ioutil.TempDir("", "rpath")
+		require.NoError(t, err)
+
+		defer os.RemoveAll(top)
+
+		// Long enough that nothing fits in place
+		dir := filepath.Join(top, strings.Repeat("d", 200))
+		require.NoError(t, os.MkdirAll(dir, 0755))
+
+		for name, src := range map[string]string{"ld.so": ldso, "libfix.so": "testdata/libfix.so", "hello": "testdata/hello"} {
+			data, err := ioutil.ReadFile(src)
+			require.NoError(t, err)
+
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), data, 0755))
+		}
+
+		prog := filepath.Join(dir, "hello")
+
+		err = EditFile(prog, func(e *Editor) error {
+			e.SetRunPath([]string{dir})
+			e.AddNeeded("libfix.so")
+			return e.SetInterpreter(filepath.Join(dir, "ld.so"))
+		})
+		require.NoError(t, err)
+
+		out, err := exec.Command(prog).CombinedOutput()
+		require.NoError(t, err, string(out))
+
+		assert.Equal(t, "hello\n", string(out))
+
+		// The dynamic linker shows it loaded libfix.so from the runpath.
+		cmd := exec.Command(prog)
+		cmd.Env = []string{"LD_TRACE_LOADED_OBJECTS=1"}
+
+		out, err = cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+
+		assert.Contains(t, string(out), filepath.Join(dir, "libfix.so"))
+	})
+}
@@ -0,0 +1,257 @@
+package rpath
+
+import (
+	"bytes"
+	"debug/elf"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrPrefixTooLong = errors.New("new prefix is longer than the old one")
+	ErrUnterminated  = errors.New("prefix found in unterminated string")
+	ErrUnsafeString  = errors.New("prefix found outside of a string section")
+)
+
+// ReplacePadded replaces from with to in each null terminated string in data
+// that contains it, in place. The rest of the string is moved up to follow
+// the new value and the space left at the end is filled with nulls, so the
+// offsets of everything else in data are unchanged. It returns the number
+// of strings that were changed.
+func ReplacePadded(data []byte, from, to string) (int, error) {
+	if len(to) > len(from) {
+		return 0, errors.Wrapf(ErrPrefixTooLong, "replacing %s with %s", from, to)
+	}
+
+	var (
+		bfrom = []byte(from)
+		bto   = []byte(to)
+		count int
+	)
+
+	for pos := 0; pos < len(data); {
+		idx := bytes.Index(data[pos:], bfrom)
+		if idx == -1 {
+			break
+		}
+
+		start := pos + idx
+
+		end := bytes.IndexByte(data[start:], 0)
+		if end == -1 {
+			return count, errors.Wrapf(ErrUnterminated, "at offset %d", start)
+		}
+
+		end += start
+
+		replaceString(data[start:end], bfrom, bto)
+
+		count++
+		pos = end
+	}
+
+	return count, nil
+}
+
+// RelocateStrings is ReplacePadded for the ELF file in data, limited to
+// the sections known to hold null terminated strings: string tables,
+// .interp, sections flagged as holding strings and, outside of Go binaries,
+// .rodata. Elsewhere the prefix may be in data that isn't null terminated,
+// such as Go strings or length prefixed tables, where padding up to the
+// next null would corrupt what follows it, so ErrUnsafeString is returned
+// instead. It's also returned for strings that others may have been merged
+// into the tail of: those in the dynamic string table that something refers
+// to the middle of, and any in loaded sections of merged strings.
+func RelocateStrings(data []byte, from, to string) (int, error) {
+	if len(to) > len(from) {
+		return 0, errors.Wrapf(ErrPrefixTooLong, "replacing %s with %s", from, to)
+	}
+
+	ef, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+
+	goBinary := ef.Section(".go.buildinfo") != nil || ef.Section(".note.go.buildid") != nil
+
+	var dynstr *elf.Section
+
+	if dyn := ef.SectionByType(elf.SHT_DYNAMIC); dyn != nil && int(dyn.Link) < len(ef.Sections) {
+		dynstr = ef.Sections[dyn.Link]
+	}
+
+	refs, err := dynstrRefs(data)
+	if err != nil {
+		return 0, err
+	}
+
+	// Check every occurrence before changing anything, so data is left
+	// alone when it can't be relocated.
+	sections := map[*elf.Section]bool{}
+
+	for pos := 0; pos < len(data); {
+		idx := bytes.Index(data[pos:], []byte(from))
+		if idx == -1 {
+			break
+		}
+
+		start := pos + idx
+
+		s := sectionAt(ef, uint64(start))
+		if s == nil || uint64(start+len(from)) > s.Offset+s.Size {
+			return 0, errors.Wrapf(ErrUnsafeString, "at offset %d, outside of any section", start)
+		}
+
+		if !isStringSection(s, goBinary) {
+			return 0, errors.Wrapf(ErrUnsafeString, "at offset %d, in %s", start, s.Name)
+		}
+
+		// The rest of the string moves, so nothing may refer to it.
+		if s == dynstr {
+			end := bytes.IndexByte(data[start:s.Offset+s.Size], 0)
+			if end == -1 {
+				return 0, errors.Wrapf(ErrUnterminated, "at offset %d", start)
+			}
+
+			if refersInside(refs, uint64(start)-s.Offset, uint64(start+end)-s.Offset) {
+				return 0, errors.Wrapf(ErrUnsafeString, "at offset %d, in a string of %s shared with others", start, s.Name)
+			}
+		}
+
+		sections[s] = true
+		pos = start + len(from)
+	}
+
+	var count int
+
+	for s := range sections {
+		n, err := ReplacePadded(data[s.Offset:s.Offset+s.Size], from, to)
+		if err != nil {
+			return count, errors.Wrapf(err, "in %s", s.Name)
+		}
+
+		count += n
+	}
+
+	return count, nil
+}
+
+// sectionAt returns the section of ef whose contents include offset.
+func sectionAt(ef *elf.File, offset uint64) *elf.Section {
+	for _, s := range ef.Sections {
+		if s.Type == elf.SHT_NOBITS || s.Type == elf.SHT_NULL {
+			continue
+		}
+
+		if offset >= s.Offset && offset < s.Offset+s.Size {
+			return s
+		}
+	}
+
+	return nil
+}
+
+func isStringSection(s *elf.Section, goBinary bool) bool {
+	switch {
+	case s.Flags&(elf.SHF_ALLOC|elf.SHF_MERGE|elf.SHF_STRINGS) == elf.SHF_ALLOC|elf.SHF_MERGE|elf.SHF_STRINGS:
+		// The linker merges strings that are the tail of another, and
+		// what refers to them can't be found.
+		return false
+	case s.Type == elf.SHT_STRTAB, s.Flags&elf.SHF_STRINGS != 0, s.Name == ".interp":
+		return true
+	case s.Name == ".rodata" || strings.HasPrefix(s.Name, ".rodata."):
+		// Go doesn't null terminate its strings and packs them together
+		// in .rodata.
+		return !goBinary
+	default:
+		return false
+	}
+}
+
+// replaceString replaces from with to in str, which must not be longer
+// after the replacement, and fills the rest of it with nulls.
+func replaceString(str, from, to []byte) {
+	repl := bytes.Replace(str, from, to, -1)
+
+	copy(str, repl)
+
+	for i := len(repl); i < len(str); i++ {
+		str[i] = 0
+	}
+}
+
+// Relocate replaces from with to in the RPATH and RUNPATH entries of the
+// ELF file in data, in place. It returns true if any entries were changed.
+func Relocate(data []byte, from, to string) (bool, error) {
+	if len(to) > len(from) {
+		return false, errors.Wrapf(ErrPrefixTooLong, "replacing %s with %s", from, to)
+	}
+
+	ef, err := ParseELFFile(data)
+	if err != nil {
+		return false, err
+	}
+
+	refs, err := dynstrRefs(data)
+	if err != nil {
+		return false, err
+	}
+
+	var changed bool
+
+	for i := uint16(0); i < ef.GetSectionCount(); i++ {
+		if !ef.IsDynamicSection(i) {
+			continue
+		}
+
+		hdr, err := ef.GetSectionHeader(i)
+		if err != nil {
+			return false, err
+		}
+
+		strtab, err := ef.GetSectionContent(uint16(hdr.GetLinkedIndex()))
+		if err != nil {
+			return false, err
+		}
+
+		entries, err := ef.DynamicEntries(i)
+		if err != nil {
+			return false, err
+		}
+
+		for _, e := range entries {
+			tag := e.GetTag().GetValue()
+
+			if tag == DT_NULL {
+				break
+			}
+
+			if tag != DT_RPATH && tag != DT_RUNPATH {
+				continue
+			}
+
+			if e.GetValue() >= uint64(len(strtab)) {
+				return false, errors.Errorf("invalid rpath offset: %d", e.GetValue())
+			}
+
+			str := strtab[e.GetValue():]
+
+			end := bytes.IndexByte(str, 0)
+			if end == -1 {
+				return false, errors.Wrapf(ErrUnterminated, "rpath at offset %d", e.GetValue())
+			}
+
+			if bytes.Contains(str[:end], []byte(from)) {
+				if refersInside(refs, e.GetValue(), e.GetValue()+uint64(end)) {
+					return false, errors.Wrapf(ErrUnsafeString, "rpath at offset %d is shared with other strings", e.GetValue())
+				}
+
+				replaceString(str[:end], []byte(from), []byte(to))
+				changed = true
+			}
+		}
+	}
+
+	return changed, nil
+}
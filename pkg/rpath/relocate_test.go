@@ -0,0 +1,190 @@
+package rpath
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelocate(t *testing.T) {
+	t.Run("replaces and pads null terminated strings", func(t *testing.T) {
+		data := []byte("x\x00/old/prefix/a:/old/prefix/b\x00y\x00/old/prefix/c\x00")
+
+		n, err := ReplacePadded(data, "/old/prefix/", "/new/")
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, n)
+		assert.Equal(t, "x\x00/new/a:/new/b\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00y\x00/new/c\x00\x00\x00\x00\x00\x00\x00\x00", string(data))
+	})
+
+	t.Run("refuses longer prefixes and unterminated strings", func(t *testing.T) {
+		_, err := ReplacePadded([]byte("/a/\x00"), "/a/", "/abc/")
+		assert.ErrorIs(t, err, ErrPrefixTooLong)
+
+		_, err = ReplacePadded([]byte("/a/b"), "/a/", "/b/")
+		assert.ErrorIs(t, err, ErrUnterminated)
+	})
+
+	for _, name := range []string{"libfix.so", "libfix32.so"} {
+		t.Run("rewrites the rpath of "+name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+			require.NoError(t, err)
+
+			changed, err := Relocate(data, "/opt/iris/store/", "/home/x/s/")
+			require.NoError(t, err)
+			assert.True(t, changed)
+
+			tmp, err := ioutil.TempFile("", "rpath")
+			require.NoError(t, err)
+
+			defer os.Remove(tmp.Name())
+
+			_, err = tmp.Write(data)
+			require.NoError(t, err)
+			require.NoError(t, tmp.Close())
+
+			ef, err := elf.Open(tmp.Name())
+			require.NoError(t, err)
+
+			defer ef.Close()
+
+			paths, err := ef.DynString(elf.DT_RUNPATH)
+			require.NoError(t, err)
+
+			rpaths, err := ef.DynString(elf.DT_RPATH)
+			require.NoError(t, err)
+
+			paths = append(paths, rpaths...)
+
+			require.Len(t, paths, 1)
+			assert.Contains(t, paths[0], "/home/x/s/abc-dep-1.0/lib")
+			assert.NotContains(t, paths[0], "/opt/iris")
+
+			// Strings elsewhere in the file are left for ReplacePadded.
+			assert.Contains(t, string(data), "/opt/iris/store/abc-dep-1.0/share")
+		})
+	}
+	for _, name := range []string{"libfix.so", "libfix32.so"} {
+		t.Run("rewrites the strings of "+name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+			require.NoError(t, err)
+
+			size := len(data)
+
+			n, err := RelocateStrings(data, "/opt/iris/store/", "/home/x/s/")
+			require.NoError(t, err)
+
+			assert.Equal(t, 2, n)
+			assert.Len(t, data, size)
+			assert.Contains(t, string(data), "/home/x/s/abc-dep-1.0/share\x00")
+			assert.NotContains(t, string(data), "/opt/iris")
+		})
+	}
+
+	t.Run("refuses strings outside of string sections", func(t *testing.T) {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "libfix.so"))
+		require.NoError(t, err)
+
+		data = append(data, "/opt/iris/store/abc-dep-1.0/etc\x00"...)
+
+		_, err = RelocateStrings(data, "/opt/iris/store/", "/home/x/s/")
+		assert.ErrorIs(t, err, ErrUnsafeString)
+	})
+
+	// tailMerged returns libfix.so with the name of a dynamic symbol
+	// pointing into the middle of its runpath, the way a linker merges a
+	// string into the tail of another. It returns the runpath's offset.
+	tailMerged := func(t *testing.T) ([]byte, uint64) {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "libfix.so"))
+		require.NoError(t, err)
+
+		ef, err := elf.NewFile(bytes.NewReader(data))
+		require.NoError(t, err)
+
+		dynstr := ef.Section(".dynstr")
+		dynsym := ef.Section(".dynsym")
+
+		idx := bytes.Index(data[dynstr.Offset:dynstr.Offset+dynstr.Size], []byte("/opt/iris/store/"))
+		require.NotEqual(t, -1, idx)
+
+		off := uint64(idx)
+
+		// The first symbol is the null symbol, st_name is its first field.
+		binary.LittleEndian.PutUint32(data[dynsym.Offset+24:], uint32(off)+uint32(len("/opt/iris/store/abc-dep-1.0/")))
+
+		return data, off
+	}
+
+	t.Run("refuses strings that others are merged into", func(t *testing.T) {
+		data, _ := tailMerged(t)
+		orig := append([]byte(nil), data...)
+
+		_, err := RelocateStrings(data, "/opt/iris/store/", "/home/x/s/")
+		assert.ErrorIs(t, err, ErrUnsafeString)
+
+		_, err = Relocate(data, "/opt/iris/store/", "/home/x/s/")
+		assert.ErrorIs(t, err, ErrUnsafeString)
+
+		assert.Equal(t, orig, data)
+	})
+
+	t.Run("adds a string rather than overwrite a merged one", func(t *testing.T) {
+		data, off := tailMerged(t)
+
+		e, err := NewEditor(data)
+		require.NoError(t, err)
+
+		e.SetRunPath([]string{"/x/lib"})
+
+		out, err := e.Bytes()
+		require.NoError(t, err)
+
+		ef, err := elf.NewFile(bytes.NewReader(out))
+		require.NoError(t, err)
+
+		paths, err := ef.DynString(elf.DT_RUNPATH)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/x/lib"}, paths)
+
+		dynstr, err := ef.Section(".dynstr").Data()
+		require.NoError(t, err)
+
+		assert.True(t, bytes.HasPrefix(dynstr[off:], []byte("/opt/iris/store/abc-dep-1.0/lib:/usr/lib\x00")))
+	})
+
+	t.Run("refuses loaded sections of merged strings", func(t *testing.T) {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "libfix.so"))
+		require.NoError(t, err)
+
+		ef, err := elf.NewFile(bytes.NewReader(data))
+		require.NoError(t, err)
+
+		var idx int
+
+		for i, s := range ef.Sections {
+			if s.Name == ".rodata" {
+				idx = i
+			}
+		}
+
+		require.NotZero(t, idx)
+
+		// Mark .rodata as merged strings, sh_flags follows sh_name and
+		// sh_type in the section header.
+		shoff := binary.LittleEndian.Uint64(data[0x28:])
+		shentsize := uint64(binary.LittleEndian.Uint16(data[0x3a:]))
+
+		flags := elf.SHF_ALLOC | elf.SHF_MERGE | elf.SHF_STRINGS
+		binary.LittleEndian.PutUint64(data[shoff+uint64(idx)*shentsize+8:], uint64(flags))
+
+		_, err = RelocateStrings(data, "/opt/iris/store/", "/home/x/s/")
+		assert.ErrorIs(t, err, ErrUnsafeString)
+	})
+}
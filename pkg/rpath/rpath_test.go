@@ -0,0 +1,55 @@
+package rpath
+
+import (
+	"debug/elf"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShrink(t *testing.T) {
+	top, err := ioutil.TempDir("", "rpath")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(top)
+
+	for _, name := range []string{"libfix.so", "prog32"} {
+		t.Run("removes unused entries from "+name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+			require.NoError(t, err)
+
+			path := filepath.Join(top, name)
+			require.NoError(t, ioutil.WriteFile(path, data, 0755))
+
+			require.NoError(t, Shrink(path, []string{"/usr/lib/x"}))
+
+			ef, err := elf.Open(path)
+			require.NoError(t, err)
+
+			defer ef.Close()
+
+			runpath, err := ef.DynString(elf.DT_RUNPATH)
+			require.NoError(t, err)
+
+			rpath, err := ef.DynString(elf.DT_RPATH)
+			require.NoError(t, err)
+
+			if name == "libfix.so" {
+				assert.Equal(t, []string{"/usr/lib"}, runpath)
+			} else {
+				// Nothing is kept, and the RPATH isn't turned into a RUNPATH.
+				assert.Empty(t, runpath)
+				assert.Empty(t, rpath)
+			}
+
+			st, err := os.Stat(path)
+			require.NoError(t, err)
+
+			assert.Equal(t, os.FileMode(0755), st.Mode().Perm())
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package rpath
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// The dynamic entries whose values are offsets in the dynamic string table.
+var dynStringTags = map[elf.DynTag]bool{
+	elf.DT_NEEDED:  true,
+	elf.DT_SONAME:  true,
+	elf.DT_RPATH:   true,
+	elf.DT_RUNPATH: true,
+	0x7ffffffd:     true, // DT_AUXILIARY
+	0x7fffffff:     true, // DT_FILTER
+}
+
+// dynamicStringRefs returns the offsets in the dynamic string table that
+// the dynamic section of ef refers to.
+func dynamicStringRefs(ef *elf.File) ([]uint64, error) {
+	s := ef.SectionByType(elf.SHT_DYNAMIC)
+	if s == nil {
+		return nil, nil
+	}
+
+	data, err := s.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []uint64
+
+	if ef.Class == elf.ELFCLASS64 {
+		for i := 0; i+16 <= len(data); i += 16 {
+			tag := elf.DynTag(ef.ByteOrder.Uint64(data[i:]))
+			if tag == elf.DT_NULL {
+				break
+			}
+
+			if dynStringTags[tag] {
+				refs = append(refs, ef.ByteOrder.Uint64(data[i+8:]))
+			}
+		}
+	} else {
+		for i := 0; i+8 <= len(data); i += 8 {
+			tag := elf.DynTag(ef.ByteOrder.Uint32(data[i:]))
+			if tag == elf.DT_NULL {
+				break
+			}
+
+			if dynStringTags[tag] {
+				refs = append(refs, uint64(ef.ByteOrder.Uint32(data[i+4:])))
+			}
+		}
+	}
+
+	return refs, nil
+}
+
+// symbolStringRefs returns the offsets in the dynamic string table that
+// the dynamic symbols and symbol versions of ef refer to.
+func symbolStringRefs(ef *elf.File) ([]uint64, error) {
+	var refs []uint64
+
+	if s := ef.SectionByType(elf.SHT_DYNSYM); s != nil {
+		data, err := s.Data()
+		if err != nil {
+			return nil, err
+		}
+
+		size := 16
+		if ef.Class == elf.ELFCLASS64 {
+			size = 24
+		}
+
+		// st_name is the first field of both symbol layouts.
+		for i := 0; i+size <= len(data); i += size {
+			refs = append(refs, uint64(ef.ByteOrder.Uint32(data[i:])))
+		}
+	}
+
+	// The version structures have the same layout in ELF32 and ELF64.
+	for _, s := range ef.Sections {
+		var (
+			err  error
+			more []uint64
+		)
+
+		switch s.Type {
+		case elf.SHT_GNU_VERNEED:
+			more, err = verneedStringRefs(s, ef.ByteOrder)
+		case elf.SHT_GNU_VERDEF:
+			more, err = verdefStringRefs(s, ef.ByteOrder)
+		default:
+			continue
+		}
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", s.Name)
+		}
+
+		refs = append(refs, more...)
+	}
+
+	return refs, nil
+}
+
+func verneedStringRefs(s *elf.Section, order binary.ByteOrder) ([]uint64, error) {
+	content, err := s.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		refs []uint64
+		off  uint32
+	)
+
+	for i := uint32(0); i < s.Info; i++ {
+		if int(off) >= len(content) {
+			return nil, errors.New("version requirement out of range")
+		}
+
+		var need ELF32VersionNeed
+
+		err = binary.Read(bytes.NewReader(content[off:]), order, &need)
+		if err != nil {
+			return nil, err
+		}
+
+		refs = append(refs, uint64(need.File))
+
+		aux := off + need.AuxOffset
+
+		for j := uint16(0); j < need.Count; j++ {
+			if int(aux) >= len(content) {
+				return nil, errors.New("version requirement out of range")
+			}
+
+			var na ELF32VersionNeedAux
+
+			err = binary.Read(bytes.NewReader(content[aux:]), order, &na)
+			if err != nil {
+				return nil, err
+			}
+
+			refs = append(refs, uint64(na.Name))
+
+			aux += na.Next
+		}
+
+		if need.Next == 0 {
+			break
+		}
+
+		off += need.Next
+	}
+
+	return refs, nil
+}
+
+func verdefStringRefs(s *elf.Section, order binary.ByteOrder) ([]uint64, error) {
+	content, err := s.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		refs []uint64
+		off  uint32
+	)
+
+	for i := uint32(0); i < s.Info; i++ {
+		if int(off) >= len(content) {
+			return nil, errors.New("version definition out of range")
+		}
+
+		var def ELF32VersionDef
+
+		err = binary.Read(bytes.NewReader(content[off:]), order, &def)
+		if err != nil {
+			return nil, err
+		}
+
+		aux := off + def.AuxOffset
+
+		for j := uint16(0); j < def.Count; j++ {
+			if int(aux) >= len(content) {
+				return nil, errors.New("version definition out of range")
+			}
+
+			var da ELF32VersionDefAux
+
+			err = binary.Read(bytes.NewReader(content[aux:]), order, &da)
+			if err != nil {
+				return nil, err
+			}
+
+			refs = append(refs, uint64(da.Name))
+
+			aux += da.Next
+		}
+
+		if def.Next == 0 {
+			break
+		}
+
+		off += def.Next
+	}
+
+	return refs, nil
+}
+
+// dynstrRefs returns every offset in the dynamic string table of the ELF
+// file in data that something refers to.
+func dynstrRefs(data []byte) ([]uint64, error) {
+	ef, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := dynamicStringRefs(ef)
+	if err != nil {
+		return nil, err
+	}
+
+	syms, err := symbolStringRefs(ef)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(refs, syms...), nil
+}
+
+// refersInside reports whether any of refs points inside the string from
+// start to end, not counting start itself. Linkers merge strings that are
+// the tail of another, so changing the end of a string can change others.
+func refersInside(refs []uint64, start, end uint64) bool {
+	for _, r := range refs {
+		if r > start && r < end {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,2 @@
+const char *where = "/opt/iris/store/abc-dep-1.0/share";
+int fix(void) { return 1; }
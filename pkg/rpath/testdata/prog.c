@@ -0,0 +1,5 @@
+extern int fix(void);
+
+void _start(void) {
+  fix();
+}
@@ -0,0 +1,4 @@
+int old(void) { return 0; }
+int mid(void) { return 1; }
+int fix(void) { return 2; }
+int cxx(void) { return 3; }
@@ -0,0 +1,219 @@
+package rpath
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The flag of the version definition naming the file itself
+const verFlagBase = 0x1
+
+// versionSection returns the content of the first section of type typ, the
+// string table it refers to and the number of entries in it. The version
+// structures have the same layout in ELF32 and ELF64 files, so the ELF32
+// definitions are used to read both.
+func (e *Editor) versionSection(typ SectionHeaderType) ([]byte, []byte, uint32, error) {
+	for i := uint16(0); i < e.ef.GetSectionCount(); i++ {
+		sh, err := e.ef.GetSectionHeader(i)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+
+		if sh.GetType() != typ {
+			continue
+		}
+
+		content, err := e.ef.GetSectionContent(i)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+
+		strtab, err := e.ef.GetSectionContent(uint16(sh.GetLinkedIndex()))
+		if err != nil {
+			return nil, nil, 0, err
+		}
+
+		return content, strtab, sh.GetInfo(), nil
+	}
+
+	return nil, nil, 0, nil
+}
+
+func cString(tab []byte, off uint32) string {
+	if int(off) >= len(tab) {
+		return ""
+	}
+
+	s := tab[off:]
+
+	if i := bytes.IndexByte(s, 0); i != -1 {
+		s = s[:i]
+	}
+
+	return string(s)
+}
+
+// VersionRequirements returns the symbol versions the file needs, keyed by
+// the library they're needed from.
+func (e *Editor) VersionRequirements() (map[string][]string, error) {
+	content, strtab, count, err := e.versionSection(GNUVersionRequirementSection)
+	if err != nil || content == nil {
+		return nil, err
+	}
+
+	reqs := map[string][]string{}
+
+	var off uint32
+
+	for i := uint32(0); i < count; i++ {
+		var need ELF32VersionNeed
+
+		err = binary.Read(bytes.NewReader(content[off:]), e.order, &need)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading version requirement")
+		}
+
+		file := cString(strtab, need.File)
+
+		aux := off + need.AuxOffset
+
+		for j := uint16(0); j < need.Count; j++ {
+			var na ELF32VersionNeedAux
+
+			if int(aux) >= len(content) {
+				return nil, errors.New("version requirement out of range")
+			}
+
+			err = binary.Read(bytes.NewReader(content[aux:]), e.order, &na)
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading version requirement")
+			}
+
+			reqs[file] = append(reqs[file], cString(strtab, na.Name))
+
+			aux += na.Next
+		}
+
+		if need.Next == 0 {
+			break
+		}
+
+		off += need.Next
+
+		if int(off) >= len(content) {
+			return nil, errors.New("version requirement out of range")
+		}
+	}
+
+	return reqs, nil
+}
+
+// VersionDefinitions returns the symbol versions the file defines, not
+// including the base version naming the file itself.
+func (e *Editor) VersionDefinitions() ([]string, error) {
+	content, strtab, count, err := e.versionSection(GNUVersionDefinitionSection)
+	if err != nil || content == nil {
+		return nil, err
+	}
+
+	var defs []string
+
+	var off uint32
+
+	for i := uint32(0); i < count; i++ {
+		var def ELF32VersionDef
+
+		err = binary.Read(bytes.NewReader(content[off:]), e.order, &def)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading version definition")
+		}
+
+		// The first aux entry is the name of the version, the rest are
+		// its parents.
+		if def.Flags&verFlagBase == 0 && def.Count > 0 {
+			aux := off + def.AuxOffset
+
+			if int(aux) >= len(content) {
+				return nil, errors.New("version definition out of range")
+			}
+
+			var da ELF32VersionDefAux
+
+			err = binary.Read(bytes.NewReader(content[aux:]), e.order, &da)
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading version definition")
+			}
+
+			defs = append(defs, cString(strtab, da.Name))
+		}
+
+		if def.Next == 0 {
+			break
+		}
+
+		off += def.Next
+
+		if int(off) >= len(content) {
+			return nil, errors.New("version definition out of range")
+		}
+	}
+
+	return defs, nil
+}
+
+// CompareVersions compares the dotted versions a and b, such as 2.17 and
+// 2.2.5, numerically. It returns -1, 0 or 1 like strings.Compare.
+func CompareVersions(a, b string) int {
+	ap := strings.Split(a, ".")
+	bp := strings.Split(b, ".")
+
+	for i := 0; i < len(ap) || i < len(bp); i++ {
+		var x, y int
+
+		if i < len(ap) {
+			x, _ = strconv.Atoi(ap[i])
+		}
+
+		if i < len(bp) {
+			y, _ = strconv.Atoi(bp[i])
+		}
+
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// MaxVersion returns the highest of the numeric versions with the given
+// prefix, without the prefix. For example the highest of GLIBC_2.2.5,
+// GLIBC_2.17 and GLIBC_PRIVATE with the prefix GLIBC_ is 2.17.
+func MaxVersion(versions []string, prefix string) string {
+	var max string
+
+	for _, v := range versions {
+		if !strings.HasPrefix(v, prefix) {
+			continue
+		}
+
+		v = v[len(prefix):]
+
+		if v == "" || v[0] < '0' || v[0] > '9' {
+			continue
+		}
+
+		if max == "" || CompareVersions(v, max) > 0 {
+			max = v
+		}
+	}
+
+	return max
+}
@@ -0,0 +1,60 @@
+package rpath
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersions(t *testing.T) {
+	load := func(t *testing.T, name string) *Editor {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		require.NoError(t, err)
+
+		e, err := NewEditor(data)
+		require.NoError(t, err)
+
+		return e
+	}
+
+	t.Run("reads the versions needed", func(t *testing.T) {
+		reqs, err := load(t, "hello").VersionRequirements()
+		require.NoError(t, err)
+
+		assert.Equal(t, map[string][]string{"libc.so.6": {"GLIBC_2.2.5", "GLIBC_2.34"}}, reqs)
+
+		reqs, err = load(t, "ver32").VersionRequirements()
+		require.NoError(t, err)
+
+		assert.Equal(t, map[string][]string{"libver32.so": {"GLIBC_2.28"}}, reqs)
+
+		reqs, err = load(t, "prog32").VersionRequirements()
+		require.NoError(t, err)
+
+		assert.Empty(t, reqs)
+	})
+
+	for _, name := range []string{"libver.so", "libver32.so"} {
+		t.Run("reads the versions defined by "+name, func(t *testing.T) {
+			defs, err := load(t, name).VersionDefinitions()
+			require.NoError(t, err)
+
+			assert.Equal(t, []string{"GLIBC_2.2.5", "GLIBC_2.17", "GLIBC_2.28", "GLIBCXX_3.4.21"}, defs)
+
+			assert.Equal(t, "2.28", MaxVersion(defs, "GLIBC_"))
+			assert.Equal(t, "3.4.21", MaxVersion(defs, "GLIBCXX_"))
+		})
+	}
+
+	t.Run("compares versions numerically", func(t *testing.T) {
+		assert.Equal(t, 1, CompareVersions("2.17", "2.2.5"))
+		assert.Equal(t, -1, CompareVersions("2.2", "2.2.5"))
+		assert.Equal(t, 0, CompareVersions("2.34", "2.34"))
+
+		assert.Equal(t, "2.17", MaxVersion([]string{"GLIBC_PRIVATE", "GLIBC_2.17", "GLIBC_2.2.5"}, "GLIBC_"))
+		assert.Equal(t, "", MaxVersion([]string{"GLIBCXX_3.4"}, "GLIBC_"))
+	})
+}